@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+	"github.com/spf13/cobra"
+)
+
+// exitRejected is returned by complete --strict when the output drops
+// critical content (EX_DATAERR)
+const exitRejected = 65
+
+func completeCmd() *cobra.Command {
+	var outputPath string
+	var strict bool
+	var c db.Completion
+	cmd := &cobra.Command{
+		Use:   "complete <event-id>",
+		Short: "Record the final message sent for a synthesis event",
+		Long: `Record the final message sent for a synthesis event.
+
+flush stores the prompt it generated; complete attaches what the model
+actually returned (--output, a file or - for stdin), with the model name,
+token counts and delivery state. The prompt and the output are kept in
+separate columns. Running complete again updates the record; omitted flags
+keep their recorded values.
+
+The output is checked against the event's critical (P0) thoughts: their URLs,
+numbers and key terms should survive synthesis. The coverage score is recorded
+on the event. With --strict, an output scoring below coverage.min_score is
+rejected with exit code 65 and nothing is recorded, so the caller can retry.
+An output longer than its channel's channels.<name>.max_length is recorded
+with a reminder to split it.
+
+For a map-reduce plan, complete the reduce event once flush --submit has
+stored its output.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid event ID %q", args[0])
+			}
+			if outputPath != "" {
+				output, err := readInput("output", outputPath)
+				if err != nil {
+					return err
+				}
+				if c.Output = strings.TrimSpace(output); c.Output == "" {
+					return fmt.Errorf("--output is empty")
+				}
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			report, checked, err := checkCoverage(d, id, c.Output)
+			if err != nil {
+				return err
+			}
+			if checked && strict && report.Score < cfg.Coverage.MinScore {
+				// Rejection is an expected outcome, not a usage error
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				if outputJSON {
+					printJSON(map[string]interface{}{
+						"ok":       false,
+						"rejected": true,
+						"coverage": report,
+					})
+				} else {
+					tokyoRed.Fprint(os.Stderr, "  ✗ ")
+					tokyoMuted.Fprintf(os.Stderr, "Rejected output for synthesis event %d ", id)
+					tokyoDim.Fprintf(os.Stderr, "(coverage %.0f%% < %.0f%%)\n", report.Score*100, cfg.Coverage.MinScore*100)
+					printMissing(os.Stderr, report)
+				}
+				return &exitError{
+					code: exitRejected,
+					msg:  fmt.Sprintf("output drops critical content (coverage %.2f < %.2f)", report.Score, cfg.Coverage.MinScore),
+				}
+			}
+			if checked {
+				c.Coverage = &report.Score
+			}
+			event, err := d.CompleteSynthesis(id, c)
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				out := map[string]interface{}{
+					"ok":       true,
+					"rejected": false,
+					"event":    event,
+				}
+				if checked {
+					out["coverage"] = report
+				}
+				return printJSON(out)
+			}
+			tokyoGreen.Print("  ✓ ")
+			tokyoMuted.Printf("Completed synthesis event %d", event.ID)
+			var details []string
+			if event.Model != "" {
+				details = append(details, "model: "+event.Model)
+			}
+			if event.PromptTokens > 0 || event.OutputTokens > 0 {
+				details = append(details, fmt.Sprintf("tokens: %d in, %d out", event.PromptTokens, event.OutputTokens))
+			}
+			if event.Delivery != "" {
+				details = append(details, "delivery: "+event.Delivery)
+			}
+			if event.Coverage != nil {
+				details = append(details, fmt.Sprintf("coverage: %.0f%%", *event.Coverage*100))
+			}
+			if len(details) > 0 {
+				tokyoDim.Printf(" (%s)", strings.Join(details, ", "))
+			}
+			fmt.Println()
+			if checked {
+				printMissing(os.Stdout, report)
+			}
+			if limit := cfg.ProfileFor(event.Channel).MaxLength; limit > 0 && utf8.RuneCountInString(event.Output) > limit {
+				tokyoYellow.Printf("  ⚠ Output is %d characters; %s takes %d", utf8.RuneCountInString(event.Output), event.Channel, limit)
+				tokyoDim.Printf(" (antibeaver split %d)\n", event.ID)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&outputPath, "output", "", "File with the final message (- reads stdin)")
+	cmd.Flags().StringVar(&c.Model, "model", "", "Model that produced the output")
+	cmd.Flags().IntVar(&c.PromptTokens, "prompt-tokens", 0, "Prompt tokens reported by the model")
+	cmd.Flags().IntVar(&c.OutputTokens, "output-tokens", 0, "Output tokens reported by the model")
+	cmd.Flags().StringVar(&c.Delivery, "delivery", "", "Delivery state: sent, failed or acked")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Reject output whose critical-content coverage is below coverage.min_score (exit 65)")
+
+	return cmd
+}
+
+// checkCoverage scores output, or the event's recorded output when empty,
+// against the critical thoughts the event consumed. checked is false when
+// there is no output yet or the event cannot be completed, leaving the error
+// to CompleteSynthesis.
+func checkCoverage(d *db.DB, eventID int64, output string) (report synthesis.CoverageReport, checked bool, err error) {
+	event, ok, err := d.GetSynthesisEvent(eventID)
+	if err != nil || !ok || event.Kind == db.EventMap || event.State != db.StateDone {
+		return report, false, err
+	}
+	if output == "" {
+		output = event.Output
+	}
+	if output == "" {
+		return report, false, nil
+	}
+	thoughts, err := d.GetEventThoughts(eventID)
+	if err != nil {
+		return report, false, err
+	}
+	// Near-duplicates are represented by the thought they were coalesced into
+	var checkable []db.Thought
+	for _, t := range thoughts {
+		if t.CoalescedInto == 0 {
+			checkable = append(checkable, t)
+		}
+	}
+	return synthesis.CheckCoverage(checkable, output), true, nil
+}
+
+// printMissing lists the critical items an output left out
+func printMissing(w io.Writer, report synthesis.CoverageReport) {
+	for _, m := range report.Missing {
+		tokyoDim.Fprintf(w, "    #%d missing: ", m.ThoughtID)
+		tokyoYellow.Fprintln(w, strings.Join(m.Items, ", "))
+	}
+}
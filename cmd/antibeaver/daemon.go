@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/recovery"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+	"github.com/spf13/cobra"
+)
+
+func daemonCmd() *cobra.Command {
+	var interval time.Duration
+	var once, staggered bool
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run the background loop that fires due flushes",
+		Long: `Run the background loop that fires due flushes.
+
+Each pass flushes agents whose debounce or cooldown window has closed. Once the
+network is healthy, agents with other pending thoughts are flushed too —
+staggered in slow-start waves by default so the recovered link is not flooded.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if !once && !outputJSON {
+				tokyoBlue.Printf("  ◆ Daemon running (every %s, Ctrl+C to stop)\n", interval)
+			}
+
+			dm := &daemon{
+				d:         d,
+				staggered: staggered,
+				rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				if err := dm.tick(time.Now()); err != nil {
+					return err
+				}
+				if once {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "How often to check for due flushes")
+	cmd.Flags().BoolVar(&once, "once", false, "Run a single pass and exit")
+	cmd.Flags().BoolVar(&staggered, "staggered", true, "Admit recovering agents in jittered slow-start waves")
+
+	return cmd
+}
+
+// daemon carries recovery progress between ticks
+type daemon struct {
+	d         *db.DB
+	staggered bool
+	rng       *rand.Rand
+	waves     []recovery.Wave
+	nextWave  time.Time
+}
+
+// tick flushes every agent whose window has closed, then admits the next
+// recovery wave when it is due. Nothing is flushed while the network is congested.
+func (dm *daemon) tick(now time.Time) error {
+	result := synthesis.ShouldBuffer(currentState(dm.d))
+	if err := dm.d.TrackCongestion(result.Buffering, result.Reason); err != nil {
+		return err
+	}
+	if result.Buffering {
+		// Congestion is back; replan from scratch after it clears
+		dm.waves = nil
+		return nil
+	}
+	// Waves are ordered by effective priority
+	if err := applyAging(dm.d); err != nil {
+		return err
+	}
+
+	agents, err := dm.d.ExpiredDebounceWindows(now)
+	if err != nil {
+		return err
+	}
+	if err := flushAgents(dm.d, agents, false, synthesis.TriggerDebounce, emitFlush(synthesis.TriggerDebounce)); err != nil {
+		return err
+	}
+
+	if len(dm.waves) == 0 {
+		due, err := dueAgents(dm.d, now)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		if dm.staggered {
+			dm.waves = recovery.Plan(due, cfg.Recovery.Policy(), dm.rng)
+		} else {
+			dm.waves = []recovery.Wave{{Agents: due}}
+		}
+		dm.nextWave = now
+	}
+
+	if now.Before(dm.nextWave) {
+		return nil
+	}
+	wave := dm.waves[0]
+	dm.waves = dm.waves[1:]
+	if len(dm.waves) > 0 {
+		dm.nextWave = now.Add(dm.waves[0].Delay)
+	}
+	return flushAgents(dm.d, wave.Agents, false, synthesis.TriggerRecovery, emitFlush(synthesis.TriggerRecovery))
+}
+
+// dueAgents returns agents with pending thoughts, most urgent first, that are
+// not held by an open debounce window or an active cooldown
+func dueAgents(d *db.DB, now time.Time) ([]string, error) {
+	agents, err := d.GetPendingAgents()
+	if err != nil {
+		return nil, err
+	}
+	var due []string
+	for _, a := range agents {
+		if until, open, err := d.GetDebounceWindow(a); err != nil {
+			return nil, err
+		} else if open && until.After(now) {
+			continue
+		}
+		if remaining, _ := cooldownRemaining(d, a, now); remaining > 0 {
+			continue
+		}
+		due = append(due, a)
+	}
+	return due, nil
+}
+
+// flushAgents synthesizes each agent in turn, skipping any still inside their minimum interval,
+// and passes every destination's result to emit
+func flushAgents(d *db.DB, agents []string, force bool, trigger string, emit func(flushResult) error) error {
+	for _, a := range agents {
+		results, err := synthesizeAgent(d, a, force, trigger)
+		var soon *tooSoonError
+		if errors.As(err, &soon) {
+			// Still inside the minimum interval; a later pass picks it up
+			continue
+		}
+		for _, res := range results {
+			if err := emit(res); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitFlush returns an emitter that writes triggered flushes as text or JSON lines
+func emitFlush(trigger string) func(flushResult) error {
+	return func(res flushResult) error {
+		if outputJSON {
+			return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+				"agent":    res.Agent,
+				"channel":  res.Channel,
+				"target":   res.Target,
+				"trigger":  trigger,
+				"thoughts": len(res.Thoughts),
+				"prompt":   res.Output.Text,
+				"event_id": res.EventID,
+			})
+		}
+		tokyoPurple.Printf("\n  ═══ Agent: %s → %s (%s, %d thoughts) ═══\n\n", res.Agent, res.destination(), trigger, len(res.Thoughts))
+		fmt.Println(res.Output.Text)
+		return nil
+	}
+}
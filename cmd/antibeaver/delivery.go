@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/spf13/cobra"
+)
+
+func deliveredCmd() *cobra.Command {
+	var status string
+	cmd := &cobra.Command{
+		Use:   "delivered <event-id>",
+		Short: "Record whether a synthesized message reached its channel",
+		Long: `Record whether a synthesized message reached its channel.
+
+--status is sent when the message was handed to the channel, acked when the
+channel confirmed it, or failed when the send did not go through. Events
+without a delivery state, and failed ones, count as undelivered; see
+undelivered. A failed event's thoughts can be flushed again with requeue.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid event ID %q", args[0])
+			}
+			if !db.ValidDelivery(status) {
+				return fmt.Errorf("invalid --status %q (must be %s, %s or %s)", status, db.DeliverySent, db.DeliveryFailed, db.DeliveryAcked)
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			event, err := d.SetDelivery(id, status)
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				return printJSON(map[string]interface{}{
+					"ok":    true,
+					"event": event,
+				})
+			}
+			if status == db.DeliveryFailed {
+				tokyoOrange.Print("  ✗ ")
+				tokyoMuted.Printf("Synthesis event %d failed to deliver", id)
+				tokyoDim.Printf(" (antibeaver requeue %d to flush its thoughts again)\n", id)
+				return nil
+			}
+			tokyoGreen.Print("  ✓ ")
+			tokyoMuted.Printf("Synthesis event %d %s\n", id, status)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&status, "status", db.DeliverySent, "Delivery state: sent, failed or acked")
+
+	return cmd
+}
+
+func undeliveredCmd() *cobra.Command {
+	var olderThan time.Duration
+	cmd := &cobra.Command{
+		Use:   "undelivered",
+		Short: "List synthesized messages not delivered after a while",
+		Long: `List synthesized messages not delivered after a while.
+
+Reports finished synthesis events older than --older-than (default
+delivery.overdue) that have no delivery state or whose delivery failed,
+oldest first.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("older-than") {
+				olderThan = cfg.Delivery.Overdue.Std()
+			}
+			if olderThan < 0 {
+				return fmt.Errorf("--older-than must be >= 0")
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			events, err := d.GetUndeliveredEvents(time.Now().Add(-olderThan))
+			if err != nil {
+				return err
+			}
+			if events == nil {
+				events = []db.SynthesisEvent{}
+			}
+
+			if outputJSON {
+				return printJSON(events)
+			}
+			if len(events) == 0 {
+				tokyoDim.Printf("  No undelivered messages older than %s\n", olderThan)
+				return nil
+			}
+			for _, e := range events {
+				tokyoDim.Printf("  #%-4d ", e.ID)
+				if e.Delivery == db.DeliveryFailed {
+					tokyoRed.Printf("%-8s ", e.Delivery)
+				} else {
+					tokyoYellow.Printf("%-8s ", "unknown")
+				}
+				tokyoPurple.Printf("%-10s ", e.AgentID)
+				tokyoMuted.Printf("%d thoughts → %s", e.ThoughtsCount, destinationName(e.Channel, e.Target))
+				tokyoDim.Printf("  (%s)\n", e.TriggeredAt)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&olderThan, "older-than", 0, "Only events triggered longer ago than this, e.g. 30m (default from config)")
+
+	return cmd
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/spf13/cobra"
+)
+
+func dlqCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dlq",
+		Short: "Inspect and drain the dead-letter queue",
+		Long: `Inspect and drain the dead-letter queue.
+
+requeue counts each failed send against the thoughts involved. A thought
+reaching dlq.max_attempts (default 3) is moved to status 'dead' so it stops
+bouncing between flush and requeue. It stays there until retried, which
+returns it to pending with its attempts reset, or purged, which deletes it.`,
+	}
+	cmd.AddCommand(dlqListCmd(), dlqRetryCmd(), dlqPurgeCmd())
+	return cmd
+}
+
+func dlqListCmd() *cobra.Command {
+	var agent string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List dead thoughts, oldest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			thoughts, err := d.GetDeadThoughts(agent)
+			if err != nil {
+				return err
+			}
+			if thoughts == nil {
+				thoughts = []db.Thought{}
+			}
+
+			if outputJSON {
+				return printJSON(thoughts)
+			}
+			if len(thoughts) == 0 {
+				tokyoDim.Println("  Dead-letter queue is empty")
+				return nil
+			}
+			for _, t := range thoughts {
+				tokyoDim.Printf("  #%-4d ", t.ID)
+				tokyoRed.Printf("×%-3d ", t.Attempts)
+				tokyoPurple.Printf("%-10s ", t.AgentID)
+				tokyoMuted.Print(preview(t.Content, 60))
+				tokyoDim.Printf("  (%s)\n", t.CreatedAt)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&agent, "agent", "", "Only this agent's dead thoughts (default all agents)")
+
+	return cmd
+}
+
+func dlqRetryCmd() *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "retry [thought-id...]",
+		Short: "Return dead thoughts to pending with their attempts reset",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids, err := dlqTargets(args, all)
+			if err != nil {
+				return err
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			n, err := d.RetryDeadThoughts(ids)
+			if err != nil {
+				return err
+			}
+			if outputJSON {
+				return printJSON(map[string]interface{}{"ok": true, "retried": n})
+			}
+			tokyoGreen.Print("  ✓ ")
+			tokyoMuted.Printf("Returned %d dead thoughts to pending\n", n)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Retry the whole queue")
+
+	return cmd
+}
+
+func dlqPurgeCmd() *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "purge [thought-id...]",
+		Short: "Delete dead thoughts",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids, err := dlqTargets(args, all)
+			if err != nil {
+				return err
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			n, err := d.PurgeDeadThoughts(ids)
+			if err != nil {
+				return err
+			}
+			if outputJSON {
+				return printJSON(map[string]interface{}{"ok": true, "purged": n})
+			}
+			tokyoGreen.Print("  ✓ ")
+			tokyoMuted.Printf("Purged %d dead thoughts\n", n)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Purge the whole queue")
+
+	return cmd
+}
+
+// dlqTargets parses the thought IDs given to retry or purge; nil with --all
+// means the whole queue
+func dlqTargets(args []string, all bool) ([]int64, error) {
+	if all && len(args) > 0 {
+		return nil, fmt.Errorf("give thought IDs or --all, not both")
+	}
+	if !all && len(args) == 0 {
+		return nil, fmt.Errorf("give thought IDs, or --all for the whole queue")
+	}
+	var ids []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid thought ID %q", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+	"github.com/spf13/cobra"
+)
+
+func gateCmd() *cobra.Command {
+	var channel, target string
+	cmd := &cobra.Command{
+		Use:   "gate [thought]",
+		Short: "Decide whether a message may be sent now, buffering it if not",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			content, err := synthesis.ValidateThought(args[0])
+			if err != nil {
+				return err
+			}
+
+			p, err := synthesis.ValidatePriority(priorityFlag)
+			if err != nil {
+				return err
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			now := time.Now()
+			state := currentState(d)
+			state.Debounce = cfg.DebounceFor(agentID)
+			buffering := synthesis.ShouldBuffer(state)
+			d.TrackCongestion(buffering.Buffering, buffering.Reason)
+
+			// A closed debounce window makes this agent's burst flushable
+			var flushed []flushResult
+			until, windowOpen, err := d.GetDebounceWindow(agentID)
+			if err != nil {
+				return err
+			}
+			if windowOpen && !now.Before(until) && !buffering.Buffering {
+				flushed, err = synthesizeAgent(d, agentID, false, synthesis.TriggerDebounce)
+				var soon *tooSoonError
+				if errors.As(err, &soon) {
+					// Hold the burst until the minimum interval has passed
+					err = nil
+				}
+				if err != nil {
+					return err
+				}
+				windowOpen = len(flushed) == 0 && soon != nil
+			}
+
+			var cooldownUntil time.Time
+			state.Cooldown, cooldownUntil = cooldownRemaining(d, agentID, now)
+
+			expressSent, err := d.CountExpressSends(cfg.Express.Window.Std())
+			if err != nil {
+				return err
+			}
+			policy := synthesis.ExpressPolicy{
+				Enabled: cfg.Express.Enabled,
+				Limit:   cfg.Express.Limit,
+			}
+			result := synthesis.Gate(state, p, expressSent, policy)
+
+			if result.Express {
+				n, claimed, err := d.ClaimExpressSend(agentID, p, cfg.Express.Window.Std(), cfg.Express.Limit)
+				if err != nil {
+					return err
+				}
+				if !claimed {
+					// A concurrent gate took the last express slot
+					result = synthesis.Gate(state, p, n, policy)
+				}
+			}
+
+			var id int64
+			if !result.Pass {
+				id, err = d.InsertThought(agentID, channel, target, content, p)
+				if err != nil {
+					return err
+				}
+				if result.Debounced && !windowOpen {
+					until = now.Add(state.Debounce)
+					if err := d.OpenDebounceWindow(agentID, until); err != nil {
+						return err
+					}
+				}
+				// Flush what the cooldown held back once it ends
+				if result.Cooldown && !windowOpen {
+					until = cooldownUntil
+					if err := d.OpenDebounceWindow(agentID, until); err != nil {
+						return err
+					}
+				}
+			}
+
+			action := "buffer"
+			if result.Pass {
+				action = "pass"
+			}
+
+			if outputJSON {
+				out := map[string]interface{}{
+					"action":   action,
+					"express":  result.Express,
+					"reason":   result.Reason,
+					"agent":    agentID,
+					"priority": p,
+				}
+				if id > 0 {
+					out["id"] = id
+				}
+				if result.Debounced || result.Cooldown {
+					out["window_closes_at"] = until.UTC().Format(time.RFC3339)
+				}
+				if len(flushed) > 0 {
+					// flushed keeps its single-object shape; flushed_all lists
+					// every destination when the burst spanned several
+					docs := make([]map[string]interface{}, len(flushed))
+					for i, res := range flushed {
+						docs[i] = map[string]interface{}{
+							"channel":  res.Channel,
+							"target":   res.Target,
+							"thoughts": len(res.Thoughts),
+							"prompt":   res.Output.Text,
+							"event_id": res.EventID,
+						}
+					}
+					out["flushed"] = docs[0]
+					if len(docs) > 1 {
+						out["flushed_all"] = docs
+					}
+				}
+				enc := json.NewEncoder(os.Stdout)
+				return enc.Encode(out)
+			}
+
+			for _, res := range flushed {
+				tokyoPurple.Printf("\n  ═══ Debounce window closed: %d thoughts for %s ═══\n\n", len(res.Thoughts), res.destination())
+				fmt.Println(res.Output.Text)
+				fmt.Println()
+			}
+
+			switch {
+			case result.Express:
+				tokyoOrange.Print("  ⚡ Express lane: ")
+				tokyoBold.Print("PASS")
+				tokyoDim.Printf(" (%s)\n", result.Reason)
+			case result.Pass:
+				tokyoGreen.Print("  ✓ ")
+				tokyoBold.Print("PASS")
+				tokyoDim.Printf(" (%s)\n", result.Reason)
+			default:
+				tokyoYellow.Print("  ⏸ ")
+				tokyoMuted.Print("Buffered thought ")
+				tokyoDim.Printf("(id: %d, priority: %s, agent: %s — %s)\n", id, p, agentID, result.Reason)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&agentID, "agent", "main", "Agent ID")
+	cmd.Flags().StringVar(&priorityFlag, "priority", "", "Priority class (P0/P1/P2 unless configured; default P1)")
+	cmd.Flags().StringVar(&channel, "channel", "cli", "Destination channel")
+	cmd.Flags().StringVar(&target, "target", "", "Destination target within the channel")
+
+	return cmd
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/spf13/cobra"
+)
+
+// exitBusy is returned by acquire when the resource is full (EX_TEMPFAIL)
+const exitBusy = 75
+
+// exitError carries a specific process exit code out of a command
+type exitError struct {
+	code int
+	msg  string
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
+func acquireCmd() *cobra.Command {
+	var resource string
+	var ttl time.Duration
+	cmd := &cobra.Command{
+		Use:   "acquire",
+		Short: "Take a concurrency slot on a resource, printing the lease ID",
+		Long: `Take a concurrency slot on a resource, printing the lease ID.
+
+Limits per agent and across all agents come from the bulkhead config. When the
+resource is full, acquire exits with status 75 so callers can back off and
+retry. Leases expire after their TTL, so a crashed worker cannot hold a slot
+forever; pass the ID to 'antibeaver release' when the work is done.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			limit, ok := cfg.Bulkhead.Resources[resource]
+			if !ok {
+				return fmt.Errorf("unknown resource %q (configured: %s)", resource, strings.Join(resourceNames(), ", "))
+			}
+			if !cmd.Flags().Changed("ttl") {
+				ttl = cfg.Bulkhead.LeaseTTL.Std()
+			}
+			if ttl < db.MinLeaseTTL {
+				return fmt.Errorf("--ttl must be at least %s", db.MinLeaseTTL)
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			lease, acquired, err := d.AcquireLease(agentID, resource, limit.PerAgent, limit.Global, ttl, time.Now())
+			if err != nil {
+				return err
+			}
+
+			if !acquired {
+				// Busy is an expected outcome, not a usage error
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				if outputJSON {
+					json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+						"ok":       false,
+						"busy":     true,
+						"agent":    agentID,
+						"resource": resource,
+					})
+				} else {
+					tokyoYellow.Fprint(os.Stderr, "  ⏸ ")
+					tokyoMuted.Fprintf(os.Stderr, "Resource %s busy for agent %s\n", resource, agentID)
+				}
+				return &exitError{code: exitBusy, msg: "resource busy"}
+			}
+
+			if outputJSON {
+				return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+					"ok":         true,
+					"lease_id":   lease.ID,
+					"agent":      lease.AgentID,
+					"resource":   lease.Resource,
+					"expires_at": lease.ExpiresAt,
+				})
+			}
+			// Bare ID so scripts can capture it with $(antibeaver acquire ...)
+			fmt.Println(lease.ID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&agentID, "agent", "main", "Agent ID")
+	cmd.Flags().StringVar(&resource, "resource", "llm", "Bulkhead resource to lease")
+	cmd.Flags().DurationVar(&ttl, "ttl", 0, "Lease lifetime, at least 1s (default from config)")
+
+	return cmd
+}
+
+func releaseCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "release <lease-id>",
+		Short: "Free a lease taken with acquire",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			released, err := d.ReleaseLease(args[0], time.Now())
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+					"ok":       true,
+					"released": released,
+					"lease_id": args[0],
+				})
+			}
+			if released {
+				tokyoGreen.Print("  ✓ ")
+				tokyoMuted.Printf("Released lease %s\n", args[0])
+			} else {
+				tokyoDim.Printf("  Lease %s not held (already released or expired)\n", args[0])
+			}
+			return nil
+		},
+	}
+}
+
+// resourceUsage summarises live leases on one bulkhead resource
+type resourceUsage struct {
+	InUse    int            `json:"in_use"`
+	Global   int            `json:"global_limit"`
+	PerAgent int            `json:"per_agent_limit"`
+	Agents   map[string]int `json:"agents"`
+}
+
+// leaseUsage merges live lease counts with the configured limits.
+// Every configured resource appears, even with nothing held.
+func leaseUsage(d *db.DB, now time.Time) map[string]*resourceUsage {
+	usage := map[string]*resourceUsage{}
+	get := func(name string) *resourceUsage {
+		u, ok := usage[name]
+		if !ok {
+			limit := cfg.Bulkhead.Resources[name]
+			u = &resourceUsage{Global: limit.Global, PerAgent: limit.PerAgent, Agents: map[string]int{}}
+			usage[name] = u
+		}
+		return u
+	}
+	for _, name := range resourceNames() {
+		get(name)
+	}
+	counts, _ := d.LeaseCounts(now)
+	for _, c := range counts {
+		u := get(c.Resource)
+		u.InUse += c.Count
+		u.Agents[c.AgentID] = c.Count
+	}
+	return usage
+}
+
+func resourceNames() []string {
+	return sortedKeys(cfg.Bulkhead.Resources)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLimit renders a bulkhead limit, where zero means unlimited
+func formatLimit(n int) string {
+	if n == 0 {
+		return "∞"
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/priority"
+	"github.com/spf13/cobra"
+)
+
+func listCmd() *cobra.Command {
+	var listAll bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List pending thoughts with original and effective priority",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			if err := applyAging(d); err != nil {
+				return err
+			}
+
+			agents := []string{agentID}
+			if listAll {
+				agents, err = d.GetPendingAgents()
+				if err != nil {
+					return err
+				}
+			}
+
+			thoughts := []db.Thought{}
+			for _, a := range agents {
+				pending, err := d.GetPendingThoughts(a)
+				if err != nil {
+					return err
+				}
+				thoughts = append(thoughts, pending...)
+			}
+
+			if outputJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(thoughts)
+			}
+
+			if len(thoughts) == 0 {
+				tokyoDim.Println("  No pending thoughts")
+				return nil
+			}
+
+			// Wide enough for "original→effective" with the longest class names
+			longest := 0
+			for _, name := range priority.Current().Names() {
+				if n := utf8.RuneCountInString(name); n > longest {
+					longest = n
+				}
+			}
+			width := 2*longest + 1
+
+			for _, t := range thoughts {
+				tokyoDim.Printf("  #%-4d ", t.ID)
+				if t.Effective() != t.Priority {
+					tokyoYellow.Printf("%-*s ", width, t.Priority+"→"+t.Effective())
+				} else {
+					tokyoBlue.Printf("%-*s ", width, t.Priority)
+				}
+				tokyoPurple.Printf("%-10s ", t.AgentID)
+				tokyoMuted.Print(preview(t.Content, 60))
+				tokyoDim.Printf("  (%s)\n", t.CreatedAt)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&agentID, "agent", "main", "Agent ID")
+	cmd.Flags().BoolVar(&listAll, "all", false, "List all agents")
+
+	return cmd
+}
+
+// preview flattens content to a single line of at most n runes
+func preview(content string, n int) string {
+	s := strings.Join(strings.Fields(content), " ")
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
@@ -2,23 +2,40 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/rickhallett/antibeaver/internal/config"
 	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/priority"
+	"github.com/rickhallett/antibeaver/internal/recovery"
 	"github.com/rickhallett/antibeaver/internal/synthesis"
 	"github.com/spf13/cobra"
 )
 
 var (
-	version    = "0.3.0"
-	dbPath     string
-	outputJSON bool
-	agentID    string
-	priority   string
-	noColor    bool
+	version        = "0.3.0"
+	dbPath         string
+	configPath     string
+	cfg            config.Config
+	outputJSON     bool
+	agentID        string
+	priorityFlag   string
+	strategyFlag   string
+	maxTokens      int
+	channelContext string // trimmed --context transcript
+	showObsolete   bool   // list superseded thoughts in the prompt
+	dryRun         bool   // render flushes without recording them
+	messageShape   string // chat API shape of flush --format messages
+	thoughtFilter  db.ThoughtFilter
+	noColor        bool
 )
 
 // Tokyo Night color palette
@@ -76,10 +93,21 @@ func main() {
 through circuit breaking, message buffering, and thought coalescing.
 
 Dam it.`,
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			if noColor {
 				color.NoColor = true
 			}
+			var err error
+			cfg, err = config.Load(configPath)
+			if err != nil {
+				return err
+			}
+			reg, err := cfg.Registry()
+			if err != nil {
+				return err
+			}
+			priority.Use(reg)
+			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			printBanner()
@@ -90,13 +118,26 @@ Dam it.`,
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&dbPath, "db", defaultDBPath(), "Path to SQLite database")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", defaultConfigPath(), "Path to JSON config file")
 	rootCmd.PersistentFlags().BoolVar(&outputJSON, "json", false, "Output as JSON")
 	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colors")
 
 	// Add commands
 	rootCmd.AddCommand(statusCmd())
 	rootCmd.AddCommand(bufferCmd())
+	rootCmd.AddCommand(retractCmd())
+	rootCmd.AddCommand(gateCmd())
+	rootCmd.AddCommand(daemonCmd())
 	rootCmd.AddCommand(flushCmd())
+	rootCmd.AddCommand(completeCmd())
+	rootCmd.AddCommand(requeueCmd())
+	rootCmd.AddCommand(dlqCmd())
+	rootCmd.AddCommand(deliveredCmd())
+	rootCmd.AddCommand(undeliveredCmd())
+	rootCmd.AddCommand(splitCmd())
+	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(acquireCmd())
+	rootCmd.AddCommand(releaseCmd())
 	rootCmd.AddCommand(haltCmd())
 	rootCmd.AddCommand(resumeCmd())
 	rootCmd.AddCommand(simulateCmd())
@@ -105,6 +146,10 @@ Dam it.`,
 	rootCmd.AddCommand(versionCmd())
 
 	if err := rootCmd.Execute(); err != nil {
+		var exit *exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -114,10 +159,393 @@ func defaultDBPath() string {
 	return home + "/.openclaw/antibeaver/governance.db"
 }
 
+func defaultConfigPath() string {
+	home, _ := os.UserHomeDir()
+	return home + "/.openclaw/antibeaver/config.json"
+}
+
 func openDB() (*db.DB, error) {
 	return db.Open(dbPath)
 }
 
+// currentState gathers the buffering inputs from the database
+func currentState(d *db.DB) synthesis.State {
+	avgLatency, _ := d.GetAverageLatency(1) // last 1 minute
+	maxLatency, _ := d.GetMaxLatency(1)
+
+	return synthesis.State{
+		AvgLatency:      avgLatency,
+		MaxLatency:      maxLatency,
+		Threshold:       5000,
+		ForcedBuffering: d.IsForcedBuffering(),
+		SimulatedMs:     d.GetSimulatedLatency(),
+		Halted:          d.IsHalted(),
+	}
+}
+
+// applyAging refreshes effective priorities before thoughts are read.
+// synthesizeAgent and synthesizeChannel call it, so every flush path
+// prompts with current priorities.
+func applyAging(d *db.DB) error {
+	_, err := d.ApplyAging(time.Now().UTC(), cfg.Aging.Interval.Std(), cfg.AgingCeiling())
+	return err
+}
+
+// tooSoonError reports that an agent's minimum send interval has not elapsed
+type tooSoonError struct {
+	agent string
+	wait  time.Duration
+}
+
+func (e *tooSoonError) Error() string {
+	return fmt.Sprintf("agent %s synthesized too recently; next flush allowed in %s (use --force to override)", e.agent, e.wait.Round(time.Second))
+}
+
+// flushResult is one agent's synthesized output for one destination
+type flushResult struct {
+	Agent     string   // db.AllAgents for a cross-agent flush
+	Agents    []string // contributing agents of a cross-agent flush
+	Channel   string
+	Target    string
+	Strategy  string
+	EventID   int64
+	Thoughts  []db.Thought // the thoughts the output consumed
+	Deferred  int          // thoughts left pending by the token budget or strategy
+	Discarded int          // thoughts the strategy superseded as outdated
+	Context   string       // channel transcript recorded with the event
+	Output    synthesis.Result
+	DryRun    bool
+	Consumed  []int64 // IDs the output consumes, coalesced duplicates included
+}
+
+// synthesizeAgent runs the agent's synthesis strategy over its pending thoughts, once per
+// channel and target, and marks each destination's thoughts synthesized as its own event.
+// No results means there was nothing to flush. On error, the results for destinations
+// already recorded are still returned.
+// Unless force is set, it refuses with a *tooSoonError inside the agent's minimum interval.
+// thoughtFilter narrows the flush to some of the pending thoughts; with dryRun set,
+// nothing is recorded. trigger, such as synthesis.TriggerDebounce, says why the
+// flush happened and sets the prompt's wording.
+func synthesizeAgent(d *db.DB, agent string, force bool, trigger string) ([]flushResult, error) {
+	if err := applyAging(d); err != nil {
+		return nil, err
+	}
+	thoughts, err := d.GetFilteredPendingThoughts(agent, thoughtFilter)
+	if err != nil {
+		return nil, err
+	}
+	if len(thoughts) == 0 {
+		if dryRun || !thoughtFilter.IsZero() {
+			// A dry run changes nothing, and thoughts outside the filter may still
+			// be waiting in the debounce window
+			return nil, nil
+		}
+		return nil, d.CloseDebounceWindow(agent)
+	}
+	return synthesizeThoughts(d, agent, nil, thoughts, force, trigger)
+}
+
+// synthesizeChannel synthesizes every agent's pending thoughts for channel
+// together, once per target, attributing each thought to its agent. Each event
+// is recorded against all contributing agents, and each of them must be
+// outside its minimum interval unless force is set.
+func synthesizeChannel(d *db.DB, channel string, force bool) ([]flushResult, error) {
+	if err := applyAging(d); err != nil {
+		return nil, err
+	}
+	f := thoughtFilter
+	f.Channel = channel
+	thoughts, err := d.GetFilteredPendingThoughts(db.AllAgents, f)
+	if err != nil || len(thoughts) == 0 {
+		return nil, err
+	}
+	var agents []string
+	seen := map[string]bool{}
+	for _, t := range thoughts {
+		if !seen[t.AgentID] {
+			seen[t.AgentID] = true
+			agents = append(agents, t.AgentID)
+		}
+	}
+	sort.Strings(agents)
+	return synthesizeThoughts(d, db.AllAgents, agents, thoughts, force, synthesis.TriggerRecovery)
+}
+
+// synthesizeThoughts is the body of synthesizeAgent and synthesizeChannel.
+// agents lists the owners of thoughts when agent is db.AllAgents.
+func synthesizeThoughts(d *db.DB, agent string, agents []string, thoughts []db.Thought, force bool, trigger string) ([]flushResult, error) {
+	owners := agents
+	if agent != db.AllAgents {
+		owners = []string{agent}
+	}
+	now := time.Now()
+	// A dry run sends nothing, so the minimum interval does not apply
+	if !force && !dryRun {
+		for _, a := range owners {
+			if err := checkMinInterval(d, a, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var err error
+
+	strategy := strategyFlag
+	if strategy == "" {
+		strategy = cfg.StrategyFor(agent)
+	}
+	synth, err := synthesis.Strategy(strategy)
+	if err != nil {
+		return nil, err
+	}
+	in := synthesis.Input{
+		AgentID:   agent,
+		Template:  cfg.TemplateFor(agent),
+		MaxTokens: maxTokens,
+		Trigger:   trigger,
+	}
+	if in.MaxTokens == 0 {
+		in.MaxTokens = cfg.MaxTokens
+	}
+	if trigger == synthesis.TriggerRecovery {
+		in.Reason, in.CongestionDuration = episodeContext(d, now)
+	}
+	in.Context = channelContext
+
+	var results []flushResult
+	for _, g := range synthesis.GroupByDestination(thoughts) {
+		var res flushResult
+		var clusters []synthesis.Cluster
+		if g, clusters, err = coalesceGroup(d, g); err == nil && showObsolete {
+			in.Obsolete, err = d.GetSupersededBy(thoughtIDs(g.Thoughts))
+		}
+		if err == nil {
+			in.Thoughts = g.Thoughts
+			in.Profile = cfg.ProfileFor(g.Channel)
+			res, err = synthesizeGroup(d, synth, in, g)
+		}
+		if err != nil {
+			if len(results) > 0 && !dryRun {
+				// The destinations already recorded were flushed
+				for _, a := range owners {
+					afterFlush(d, a, now)
+				}
+			}
+			return results, err
+		}
+		res.Strategy = strategy
+		res.Agents = agents
+		res.Consumed = consumedIDs(res.Thoughts, clusters)
+		results = append(results, res)
+	}
+	if dryRun {
+		return results, nil
+	}
+	for _, a := range owners {
+		if err := afterFlush(d, a, now); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// synthesizeGroup synthesizes one destination's thoughts and records them as one event
+func synthesizeGroup(d *db.DB, synth synthesis.Synthesizer, in synthesis.Input, g synthesis.Group) (flushResult, error) {
+	res := flushResult{Agent: in.AgentID, Channel: g.Channel, Target: g.Target, Context: in.Context}
+	var err error
+	if res.Output, err = synth.Synthesize(in); err != nil {
+		return res, err
+	}
+
+	thoughts := g.Thoughts
+	if consumed := res.Output.Consumed; consumed != nil {
+		if len(consumed) == 0 {
+			return res, fmt.Errorf("token budget of %d leaves no room for any thought", in.MaxTokens)
+		}
+		keep := map[int64]bool{}
+		for _, id := range consumed {
+			keep[id] = true
+		}
+		var used []db.Thought
+		for _, t := range thoughts {
+			if keep[t.ID] {
+				used = append(used, t)
+			}
+		}
+		res.Discarded = len(res.Output.Discarded)
+		res.Deferred = len(thoughts) - len(used) - res.Discarded
+		thoughts = used
+	}
+
+	event := db.SynthesisEvent{
+		AgentID:   in.AgentID,
+		Context:   in.Context,
+		Channel:   g.Channel,
+		Target:    g.Target,
+		Truncated: res.Output.Truncated,
+	}
+	if res.Output.Final {
+		event.Output = res.Output.Text
+	} else {
+		event.Prompt = res.Output.Text
+	}
+	if dryRun {
+		res.DryRun = true
+		res.Thoughts = thoughts
+		return res, nil
+	}
+	res.EventID, err = d.RecordSynthesis(event, thoughtIDs(thoughts))
+	if err != nil {
+		return res, err
+	}
+	// Match the rows as RecordSynthesis left them
+	claimed := make([]db.Thought, len(thoughts))
+	for i, t := range thoughts {
+		t.Status = "synthesized"
+		t.EventID = res.EventID
+		claimed[i] = t
+	}
+	res.Thoughts = claimed
+	if len(res.Output.Discarded) > 0 {
+		// Recorded after the event: on failure they stay pending and are sent later
+		if res.Discarded, err = d.SupersedeThoughts(res.Output.Discarded, res.Output.Consumed[0]); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// joinIDs formats thought IDs for display, e.g. "#3, #5"
+func joinIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = "#" + strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// thoughtIDs returns the IDs of thoughts, in order
+func thoughtIDs(thoughts []db.Thought) []int64 {
+	ids := make([]int64, len(thoughts))
+	for i, t := range thoughts {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+// consumedIDs returns the IDs of thoughts followed by those of the duplicates
+// coalesced into them
+func consumedIDs(thoughts []db.Thought, clusters []synthesis.Cluster) []int64 {
+	ids := thoughtIDs(thoughts)
+	kept := map[int64]bool{}
+	for _, id := range ids {
+		kept[id] = true
+	}
+	for _, c := range clusters {
+		if kept[c.Keep.ID] {
+			ids = append(ids, c.IDs()...)
+		}
+	}
+	return ids
+}
+
+// coalesceGroup collapses near-duplicates among a destination's thoughts and,
+// unless this is a dry run, records the collapsed rows against the thought
+// they were folded into
+func coalesceGroup(d *db.DB, g synthesis.Group) (synthesis.Group, []synthesis.Cluster, error) {
+	thoughts, clusters := synthesis.Coalesce(g.Thoughts, cfg.Coalesce.Threshold)
+	for _, c := range clusters {
+		if dryRun {
+			break
+		}
+		if _, err := d.CoalesceThoughts(c.Keep.ID, c.IDs(), c.Keep.Effective()); err != nil {
+			return g, nil, err
+		}
+	}
+	g.Thoughts = thoughts
+	return g, clusters, nil
+}
+
+// checkMinInterval returns a *tooSoonError if the agent synthesized within its minimum interval
+func checkMinInterval(d *db.DB, agent string, now time.Time) error {
+	min := cfg.MinIntervalFor(agent)
+	if min <= 0 {
+		return nil
+	}
+	last, ok, err := d.LastSynthesisAt(agent)
+	if err != nil {
+		return err
+	}
+	if wait := last.Add(min).Sub(now); ok && wait > 0 {
+		return &tooSoonError{agent: agent, wait: wait}
+	}
+	return nil
+}
+
+// afterFlush closes the agent's debounce window and starts its cooldown
+func afterFlush(d *db.DB, agent string, now time.Time) error {
+	if err := d.CloseDebounceWindow(agent); err != nil {
+		return err
+	}
+	if cooldown := cfg.CooldownFor(agent); cooldown > 0 {
+		return d.SetCooldown(agent, now.Add(cooldown))
+	}
+	return nil
+}
+
+// episodeContext describes the most recent congestion episode for prompts
+func episodeContext(d *db.DB, now time.Time) (string, time.Duration) {
+	episode, err := d.GetCongestionEpisode()
+	if err != nil {
+		return "", 0
+	}
+	return episode.Reason, episode.Duration(now).Round(time.Second)
+}
+
+// flushStaggered admits agents wave by wave per the recovery policy, stopping
+// early if the network degrades again
+func flushStaggered(d *db.DB, agents []string, force bool, emit func(flushResult) error) error {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	waves := recovery.Plan(agents, cfg.Recovery.Policy(), rng)
+
+	for i, w := range waves {
+		if w.Delay > 0 {
+			if !outputJSON {
+				tokyoDim.Printf("\n  ⏳ Wave %d/%d in %s\n", i+1, len(waves), w.Delay.Round(time.Millisecond))
+			}
+			time.Sleep(w.Delay)
+			if result := synthesis.ShouldBuffer(currentState(d)); result.Buffering {
+				if !outputJSON {
+					tokyoOrange.Printf("  ⚡ Stopped: %s — remaining agents stay pending\n", result.Reason)
+				}
+				return nil
+			}
+		}
+		if err := flushAgents(d, w.Agents, force, synthesis.TriggerRecovery, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cooldownRemaining returns how long the agent's post-flush cooldown still runs
+func cooldownRemaining(d *db.DB, agent string, now time.Time) (time.Duration, time.Time) {
+	until, ok, err := d.GetCooldown(agent)
+	if err != nil || !ok || !until.After(now) {
+		return 0, time.Time{}
+	}
+	return until.Sub(now), until
+}
+
+// expressSentThisEpisode counts express-lane sends during the active congestion episode
+func expressSentThisEpisode(d *db.DB) int {
+	episode, err := d.GetCongestionEpisode()
+	if err != nil || !episode.Active() {
+		return 0
+	}
+	n, _ := d.CountExpressSendsSince(episode.StartedAt)
+	return n
+}
+
 func statusCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "status",
@@ -130,28 +558,35 @@ func statusCmd() *cobra.Command {
 			defer d.Close()
 
 			pending, _ := d.GetPendingCount("")
-			halted := d.IsHalted()
-			forced := d.IsForcedBuffering()
-			simulated := d.GetSimulatedLatency()
+			dead, _ := d.GetDeadCount("")
 
-			// Get latency from database
-			avgLatency, _ := d.GetAverageLatency(1) // last 1 minute
-			maxLatency, _ := d.GetMaxLatency(1)
-
-			state := synthesis.State{
-				AvgLatency:      avgLatency,
-				MaxLatency:      maxLatency,
-				Threshold:       5000,
-				ForcedBuffering: forced,
-				SimulatedMs:     simulated,
-				Halted:          halted,
-			}
+			state := currentState(d)
+			halted := state.Halted
+			forced := state.ForcedBuffering
+			simulated := state.SimulatedMs
+			avgLatency := state.AvgLatency
+			maxLatency := state.MaxLatency
 
 			result := synthesis.ShouldBuffer(state)
+			expressSent := expressSentThisEpisode(d)
+
+			now := time.Now()
+			cooldowns, _ := d.ActiveCooldowns(now)
+			cooldownSecs := map[string]int64{}
+			for _, c := range cooldowns {
+				cooldownSecs[c.AgentID] = int64(c.Until.Sub(now).Round(time.Second).Seconds())
+			}
+			leases := leaseUsage(d, now)
+			var undelivered []db.SynthesisEvent
+			overdue := cfg.Delivery.Overdue.Std()
+			if overdue > 0 {
+				undelivered, _ = d.GetUndeliveredEvents(now.Add(-overdue))
+			}
 
 			if outputJSON {
 				out := map[string]interface{}{
 					"pending":          pending,
+					"dead":             dead,
 					"halted":           halted,
 					"forced_buffering": forced,
 					"simulated_ms":     simulated,
@@ -160,6 +595,14 @@ func statusCmd() *cobra.Command {
 					"avg_latency_ms":   state.AvgLatency,
 					"max_latency_ms":   state.MaxLatency,
 					"threshold_ms":     state.Threshold,
+					"express_sent":     expressSent,
+					"cooldowns":        cooldownSecs,
+					"leases":           leases,
+				}
+				// null rather than 0 when the check is off, which would claim all was delivered
+				out["undelivered"] = nil
+				if overdue > 0 {
+					out["undelivered"] = len(undelivered)
 				}
 				enc := json.NewEncoder(os.Stdout)
 				enc.SetIndent("", "  ")
@@ -187,12 +630,51 @@ func statusCmd() *cobra.Command {
 			} else {
 				tokyoMuted.Println("0 thoughts")
 			}
+			if dead > 0 {
+				tokyoBlue.Print("  ◆ Dead letters: ")
+				tokyoRed.Printf("%d thoughts", dead)
+				tokyoDim.Println(" (antibeaver dlq list)")
+			}
 
 			// Latency
 			tokyoBlue.Print("  ◆ Latency: ")
 			tokyoMuted.Printf("avg %dms / max %dms", avgLatency, maxLatency)
 			tokyoDim.Printf(" (threshold: %dms)\n", state.Threshold)
 
+			// Express lane
+			if result.Buffering && !halted {
+				tokyoBlue.Print("  ◆ Express: ")
+				tokyoMuted.Printf("%d express sends this episode", expressSent)
+				tokyoDim.Printf(" (limit: %d per %s)\n", cfg.Express.Limit, cfg.Express.Window.Std())
+			}
+
+			// Cooldowns
+			if len(cooldowns) > 0 {
+				tokyoBlue.Print("  ◆ Cooldown: ")
+				for i, c := range cooldowns {
+					if i > 0 {
+						tokyoDim.Print(", ")
+					}
+					tokyoMuted.Printf("%s %s", c.AgentID, c.Until.Sub(now).Round(time.Second))
+				}
+				fmt.Println()
+			}
+
+			// Bulkhead leases
+			for _, name := range sortedKeys(leases) {
+				u := leases[name]
+				if u.InUse == 0 {
+					continue
+				}
+				tokyoBlue.Print("  ◆ Leases: ")
+				tokyoMuted.Printf("%s %d/%s", name, u.InUse, formatLimit(u.Global))
+				var held []string
+				for _, a := range sortedKeys(u.Agents) {
+					held = append(held, fmt.Sprintf("%s %d", a, u.Agents[a]))
+				}
+				tokyoDim.Printf(" (%s)\n", strings.Join(held, ", "))
+			}
+
 			// Warnings
 			if halted {
 				fmt.Println()
@@ -204,6 +686,10 @@ func statusCmd() *cobra.Command {
 			if simulated > 0 {
 				tokyoPurple.Printf("  🔮 Simulated latency: %dms\n", simulated)
 			}
+			if len(undelivered) > 0 {
+				tokyoOrange.Printf("  ⚠️  %d synthesized messages undelivered after %s", len(undelivered), cfg.Delivery.Overdue.Std())
+				tokyoDim.Println(" (antibeaver undelivered)")
+			}
 
 			fmt.Println()
 			return nil
@@ -212,6 +698,8 @@ func statusCmd() *cobra.Command {
 }
 
 func bufferCmd() *cobra.Command {
+	var channel, target string
+	var supersedes int64
 	cmd := &cobra.Command{
 		Use:   "buffer [thought]",
 		Short: "Buffer a thought for later synthesis",
@@ -225,7 +713,7 @@ func bufferCmd() *cobra.Command {
 				return err
 			}
 
-			p, err := synthesis.ValidatePriority(priority)
+			p, err := synthesis.ValidatePriority(priorityFlag)
 			if err != nil {
 				return err
 			}
@@ -236,7 +724,7 @@ func bufferCmd() *cobra.Command {
 			}
 			defer d.Close()
 
-			id, err := d.InsertThought(agentID, "cli", "", content, p)
+			id, superseded, err := d.InsertSupersedingThought(agentID, channel, target, content, p, supersedes)
 			if err != nil {
 				return err
 			}
@@ -247,6 +735,12 @@ func bufferCmd() *cobra.Command {
 					"id":       id,
 					"agent":    agentID,
 					"priority": p,
+					"channel":  channel,
+					"target":   target,
+				}
+				if supersedes != 0 {
+					out["supersedes"] = supersedes
+					out["superseded"] = superseded
 				}
 				enc := json.NewEncoder(os.Stdout)
 				return enc.Encode(out)
@@ -255,74 +749,500 @@ func bufferCmd() *cobra.Command {
 			tokyoGreen.Print("  ✓ ")
 			tokyoMuted.Print("Buffered thought ")
 			tokyoDim.Printf("(id: %d, priority: %s, agent: %s)\n", id, p, agentID)
+			if supersedes != 0 {
+				if superseded {
+					tokyoMuted.Printf("    Superseded thought %d\n", supersedes)
+				} else {
+					tokyoDim.Printf("    Thought %d not pending for %s; nothing superseded\n", supersedes, agentID)
+				}
+			}
 			return nil
 		},
 	}
 
 	cmd.Flags().StringVar(&agentID, "agent", "main", "Agent ID")
-	cmd.Flags().StringVar(&priority, "priority", "P1", "Priority (P0/P1/P2)")
+	cmd.Flags().StringVar(&priorityFlag, "priority", "", "Priority class (P0/P1/P2 unless configured; default P1)")
+	cmd.Flags().StringVar(&channel, "channel", "cli", "Destination channel")
+	cmd.Flags().StringVar(&target, "target", "", "Destination target within the channel")
+	cmd.Flags().Int64Var(&supersedes, "supersedes", 0, "ID of an earlier pending thought this one replaces")
 
 	return cmd
 }
 
+func retractCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "retract <thought-id>",
+		Short: "Withdraw a pending thought without sending it",
+		Long: `Withdraw a pending thought without sending it.
+
+The thought leaves the pending set but stays in the database with status
+'retracted' for audit.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid thought ID %q", args[0])
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			retracted, err := d.RetractThought(id)
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+					"ok":        true,
+					"retracted": retracted,
+					"id":        id,
+				})
+			}
+			if retracted {
+				tokyoGreen.Print("  ✓ ")
+				tokyoMuted.Printf("Retracted thought %d\n", id)
+			} else {
+				tokyoDim.Printf("  Thought %d not pending (already sent, retracted or unknown)\n", id)
+			}
+			return nil
+		},
+	}
+}
+
+// Output formats for flush
+const (
+	formatText     = "text"
+	formatMessages = "messages"
+	formatJSON     = "json"
+)
+
 func flushCmd() *cobra.Command {
-	var flushAll bool
+	var flushAll, force, staggered, mapReduce, crossAgent bool
+	var format, stepOutput, contextPath, channel, priorityFilter string
+	var ids []int64
+	var since time.Duration
+	var chunkSize int
+	var submitID int64
 	cmd := &cobra.Command{
 		Use:   "flush",
 		Short: "Flush buffered thoughts and generate synthesis prompt",
+		Long: `Flush buffered thoughts and generate synthesis prompt.
+
+--format text prints the synthesized output. --format messages prints the
+chat messages, with the system instruction and the buffered thoughts kept
+apart: --shape openai (the default) gives an array of {role, content} with a
+system message, --shape anthropic gives {system, messages} for the Anthropic
+Messages API. --format json prints the thoughts,
+the output, the messages and the synthesis event ID; with --all, one JSON
+object per agent per line.
+
+--map-reduce splits a backlog larger than --chunk-size thoughts into map
+rounds whose outputs a final reduce round combines. Each run prints the next
+round's prompt; hand the model's answer back with --submit <event-id>
+--output <text|-> until the plan reports done. Rounds are stored as linked
+synthesis events, so an interrupted plan resumes where it stopped.
+
+--context supplies the recent channel transcript (a file, or - for stdin) that
+the prompt is reviewed against. It is trimmed to context_tokens, keeping the
+newest lines, and recorded on the synthesis event.
+
+--channel X --cross-agent synthesizes the pending thoughts of every agent bound
+for channel X into one prompt per target, each thought attributed to its agent.
+The synthesis event is recorded against all contributing agents, and each of
+them must be outside its minimum interval unless --force is given.
+
+--ids, --priority, --since and --channel flush only the matching pending
+thoughts; the rest stay buffered. --priority matches the effective priority.
+
+--dry-run renders exactly what flush would print and lists the thought IDs it
+would consume, without recording anything. Near-duplicates are coalesced in
+the preview only, and the minimum interval is not enforced.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if strategyFlag != "" {
+				if _, err := synthesis.Strategy(strategyFlag); err != nil {
+					return err
+				}
+			}
+			if maxTokens < 0 {
+				return fmt.Errorf("--max-tokens must be >= 0")
+			}
+			// --json is shorthand for --format json
+			if outputJSON && !cmd.Flags().Changed("format") {
+				format = formatJSON
+			}
+			switch format {
+			case formatText, formatJSON:
+			case formatMessages:
+				if flushAll {
+					return fmt.Errorf("--format messages needs a single agent; use --format json with --all")
+				}
+			default:
+				return fmt.Errorf("invalid format %q (must be text, messages or json)", format)
+			}
+			if _, err := synthesis.ShapeMessages(nil, messageShape); err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("shape") && format != formatMessages {
+				return fmt.Errorf("--shape needs --format messages")
+			}
+			// Machine-readable formats keep progress text off stdout
+			if format != formatText {
+				outputJSON = true
+			}
+			if (mapReduce || submitID != 0) && flushAll {
+				return fmt.Errorf("--map-reduce and --submit need a single agent")
+			}
+			if crossAgent {
+				if channel == "" {
+					return fmt.Errorf("--cross-agent needs --channel")
+				}
+				if flushAll || mapReduce || submitID != 0 {
+					return fmt.Errorf("--cross-agent cannot be combined with --all, --map-reduce or --submit")
+				}
+			}
+			if since < 0 {
+				return fmt.Errorf("--since must be >= 0")
+			}
+			if priorityFilter != "" {
+				if _, ok := priority.Current().Lookup(priorityFilter); !ok {
+					return fmt.Errorf("invalid priority: %s", priorityFilter)
+				}
+			}
+			thoughtFilter = db.ThoughtFilter{IDs: ids, Priority: priorityFilter}
+			if since > 0 {
+				thoughtFilter.Since = time.Now().Add(-since)
+			}
+			if !crossAgent {
+				thoughtFilter.Channel = channel
+			}
+			if (mapReduce || submitID != 0) && (dryRun || !thoughtFilter.IsZero() || channel != "") {
+				return fmt.Errorf("--map-reduce and --submit cannot be combined with --dry-run or filters")
+			}
+			if staggered && !flushAll {
+				return fmt.Errorf("--staggered needs --all")
+			}
+			if dryRun && staggered {
+				return fmt.Errorf("--dry-run cannot be combined with --staggered")
+			}
+			if submitID != 0 && !cmd.Flags().Changed("output") {
+				return fmt.Errorf("--submit needs --output")
+			}
+			if !cmd.Flags().Changed("chunk-size") {
+				chunkSize = cfg.MapReduce.ChunkSize
+			}
+			if chunkSize < 1 {
+				return fmt.Errorf("--chunk-size must be >= 1")
+			}
+			if contextPath == "-" && stepOutput == "-" {
+				return fmt.Errorf("--context and --output cannot both read stdin")
+			}
+			if contextPath != "" {
+				transcript, err := readInput("context", contextPath)
+				if err != nil {
+					return err
+				}
+				channelContext = synthesis.TrimContext(transcript, cfg.ContextTokens)
+			}
+
 			d, err := openDB()
 			if err != nil {
 				return err
 			}
 			defer d.Close()
 
+			if submitID != 0 {
+				output, err := readStepOutput(stepOutput)
+				if err != nil {
+					return err
+				}
+				step, err := submitPlanStep(d, submitID, output)
+				if err != nil {
+					return err
+				}
+				return printPlanStep(step, format)
+			}
+
 			if flushAll {
+				// Order agents by fresh effective priorities; aging follows from
+				// the clock alone, so a dry run refreshes it as list does
+				if err := applyAging(d); err != nil {
+					return err
+				}
 				agents, err := d.GetPendingAgents()
 				if err != nil {
 					return err
 				}
 				if len(agents) == 0 {
-					tokyoDim.Println("  No pending thoughts to flush")
+					if format == formatText {
+						tokyoDim.Println("  No pending thoughts to flush")
+					}
+					return nil
+				}
+				emit := func(res flushResult) error {
+					if format == formatJSON {
+						return json.NewEncoder(os.Stdout).Encode(res.document())
+					}
+					tokyoPurple.Printf("\n  ═══ Agent: %s → %s ═══\n\n", res.Agent, res.destination())
+					fmt.Println(res.Output.Text)
+					if res.DryRun {
+						tokyoDim.Printf("\n  ◌ Dry run: would consume %s\n", joinIDs(res.Consumed))
+					}
 					return nil
 				}
+				if staggered {
+					return flushStaggered(d, agents, force, emit)
+				}
 				for _, a := range agents {
-					thoughts, _ := d.GetPendingThoughts(a)
-					if len(thoughts) > 0 {
-						tokyoPurple.Printf("\n  ═══ Agent: %s ═══\n\n", a)
-						prompt := synthesis.GeneratePrompt(thoughts)
-						fmt.Println(prompt)
-						d.MarkSynthesized(a, prompt)
+					results, err := synthesizeAgent(d, a, force, synthesis.TriggerRecovery)
+					var soon *tooSoonError
+					if errors.As(err, &soon) {
+						if format == formatText {
+							tokyoDim.Printf("\n  ⏳ Skipped %s: next flush in %s\n", a, soon.wait.Round(time.Second))
+						}
+						continue
+					}
+					for _, res := range results {
+						if err := emit(res); err != nil {
+							return err
+						}
+					}
+					if err != nil {
+						return err
 					}
 				}
 				return nil
 			}
 
-			thoughts, err := d.GetPendingThoughts(agentID)
-			if err != nil {
-				return err
+			if mapReduce {
+				step, ok, err := startPlan(d, agentID, force, chunkSize)
+				if err != nil {
+					return err
+				}
+				if ok {
+					return printPlanStep(step, format)
+				}
 			}
 
-			if len(thoughts) == 0 {
+			var results []flushResult
+			if crossAgent {
+				results, err = synthesizeChannel(d, channel, force)
+			} else {
+				results, err = synthesizeAgent(d, agentID, force, synthesis.TriggerRecovery)
+			}
+			if len(results) == 0 {
+				if err != nil {
+					return err
+				}
+				switch format {
+				case formatMessages:
+					return printMessages(nil)
+				case formatJSON:
+					if crossAgent {
+						return printJSON(flushResult{Agent: db.AllAgents}.document())
+					}
+					return printJSON(flushResult{Agent: agentID}.document())
+				}
+				if crossAgent {
+					tokyoDim.Printf("  No pending thoughts for channel: %s\n", channel)
+					return nil
+				}
 				tokyoDim.Printf("  No pending thoughts for agent: %s\n", agentID)
 				return nil
 			}
 
-			prompt := synthesis.GeneratePrompt(thoughts)
-			fmt.Println(prompt)
+			// Several destinations print as one JSON array, one element each
+			if format != formatText && len(results) > 1 {
+				docs := make([]interface{}, len(results))
+				for i, res := range results {
+					if format == formatJSON {
+						docs[i] = res.document()
+						continue
+					}
+					if docs[i], err = destinationMessages(res); err != nil {
+						return err
+					}
+				}
+				if perr := printJSON(docs); perr != nil {
+					return perr
+				}
+				return err
+			}
 
-			d.MarkSynthesized(agentID, prompt)
-			tokyoGreen.Printf("\n  ✓ Synthesized %d thoughts\n", len(thoughts))
-			return nil
+			// One document, or one prompt, per destination
+			thoughts, deferred, discarded := 0, 0, 0
+			var consumed, truncated []int64
+			for _, res := range results {
+				switch format {
+				case formatMessages:
+					if err := printMessages(res.Output.ChatMessages()); err != nil {
+						return err
+					}
+					continue
+				case formatJSON:
+					if err := printJSON(res.document()); err != nil {
+						return err
+					}
+					continue
+				}
+				if len(results) > 1 {
+					tokyoPurple.Printf("\n  ═══ Destination: %s ═══\n\n", res.destination())
+				}
+				fmt.Println(res.Output.Text)
+				thoughts += len(res.Thoughts)
+				deferred += res.Deferred
+				discarded += res.Discarded
+				consumed = append(consumed, res.Consumed...)
+				if res.Output.Truncated != 0 {
+					truncated = append(truncated, res.Output.Truncated)
+				}
+			}
+			if format != formatText {
+				return err
+			}
+
+			if dryRun {
+				tokyoYellow.Printf("\n  ◌ Dry run: would synthesize %d thoughts", thoughts)
+				tokyoDim.Printf(" (consuming %s); nothing recorded\n", joinIDs(consumed))
+				return err
+			}
+
+			tokyoGreen.Printf("\n  ✓ Synthesized %d thoughts", thoughts)
+			if crossAgent {
+				tokyoGreen.Printf(" from %d agents", len(results[0].Agents))
+			}
+			if len(results) > 1 {
+				tokyoGreen.Printf(" for %d destinations", len(results))
+			}
+			if deferred > 0 {
+				tokyoDim.Printf(" (%d deferred to a later flush)", deferred)
+			}
+			if discarded > 0 {
+				tokyoDim.Printf(" (%d superseded as outdated)", discarded)
+			}
+			fmt.Println()
+			if len(truncated) > 0 {
+				tokyoOrange.Printf("  ⚠️  %s cut short to fit the token budget; the full text stays on the thought\n", joinIDs(truncated))
+			}
+			return err
 		},
 	}
 
 	cmd.Flags().StringVar(&agentID, "agent", "main", "Agent ID")
 	cmd.Flags().BoolVar(&flushAll, "all", false, "Flush all agents")
+	cmd.Flags().BoolVar(&force, "force", false, "Ignore the minimum interval between outputs")
+	cmd.Flags().BoolVar(&staggered, "staggered", false, "With --all, flush agents in jittered slow-start waves")
+	cmd.Flags().StringVar(&strategyFlag, "strategy", "", "Synthesis strategy: "+strings.Join(synthesis.StrategyNames(), ", ")+" (default from config)")
+	cmd.Flags().StringVar(&format, "format", formatText, "Output format: text, messages or json")
+	cmd.Flags().StringVar(&messageShape, "shape", synthesis.ShapeOpenAI, "Chat API shape for --format messages: openai or anthropic")
+	cmd.Flags().IntVar(&maxTokens, "max-tokens", 0, "Approximate token budget for the prompt (default from config; 0 is unlimited)")
+	cmd.Flags().BoolVar(&mapReduce, "map-reduce", false, "Synthesize a large backlog in resumable map and reduce rounds")
+	cmd.Flags().IntVar(&chunkSize, "chunk-size", 0, "With --map-reduce, most thoughts per map round (default from config)")
+	cmd.Flags().Int64Var(&submitID, "submit", 0, "Store --output for this pending round and print the next one")
+	cmd.Flags().StringVar(&stepOutput, "output", "", "With --submit, the model output for the round (- reads stdin)")
+	cmd.Flags().StringVar(&contextPath, "context", "", "File with the recent channel transcript to include in the prompt (- reads stdin)")
+	cmd.Flags().StringVar(&channel, "channel", "", "Flush only thoughts for this channel (with --cross-agent, from every agent)")
+	cmd.Flags().Int64SliceVar(&ids, "ids", nil, "Flush only these thought IDs, e.g. 3,5,9")
+	cmd.Flags().StringVar(&priorityFilter, "priority", "", "Flush only thoughts with this effective priority")
+	cmd.Flags().DurationVar(&since, "since", 0, "Flush only thoughts buffered within this long, e.g. 10m")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would be flushed without recording anything")
+	cmd.Flags().BoolVar(&crossAgent, "cross-agent", false, "Synthesize all agents' pending thoughts for --channel together")
+	cmd.Flags().BoolVar(&showObsolete, "obsolete", false, "List thoughts superseded by the flushed ones in an \"already obsolete\" section")
 
 	return cmd
 }
 
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printMessages writes chat messages in the --shape request shape
+func printMessages(messages []synthesis.Message) error {
+	body, err := synthesis.ShapeMessages(messages, messageShape)
+	if err != nil {
+		return err
+	}
+	return printJSON(body)
+}
+
+// destinationMessages is a flush's chat messages in --shape, tagged with
+// where they are bound, for output that covers several destinations
+func destinationMessages(r flushResult) (map[string]interface{}, error) {
+	body, err := synthesis.ShapeMessages(r.Output.ChatMessages(), messageShape)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{
+		"channel": r.Channel,
+		"target":  r.Target,
+	}
+	if shaped, ok := body.(synthesis.AnthropicMessages); ok {
+		out["system"] = shaped.System
+		out["messages"] = shaped.Messages
+	} else {
+		out["messages"] = body
+	}
+	return out, nil
+}
+
+// destination names where the result is bound, e.g. "discord #ops"
+func (r flushResult) destination() string {
+	return destinationName(r.Channel, r.Target)
+}
+
+// destinationName joins a channel and an optional target for display
+func destinationName(channel, target string) string {
+	if target == "" {
+		return channel
+	}
+	return channel + " " + target
+}
+
+// document is the --format json shape of a flush
+func (r flushResult) document() map[string]interface{} {
+	thoughts := r.Thoughts
+	if thoughts == nil {
+		thoughts = []db.Thought{}
+	}
+	out := map[string]interface{}{
+		"agent":    r.Agent,
+		"thoughts": thoughts,
+	}
+	if r.Agents != nil {
+		out["agents"] = r.Agents
+	}
+	if r.DryRun {
+		out["dry_run"] = true
+	}
+	if len(r.Thoughts) > 0 {
+		out["channel"] = r.Channel
+		out["target"] = r.Target
+		out["strategy"] = r.Strategy
+		if r.DryRun {
+			out["consumed"] = r.Consumed
+		} else {
+			out["event_id"] = r.EventID
+		}
+		out["prompt"] = r.Output.Text
+		out["messages"] = r.Output.ChatMessages()
+		out["deferred"] = r.Deferred
+		out["discarded"] = r.Discarded
+		if r.Output.Truncated != 0 {
+			out["truncated"] = r.Output.Truncated
+		}
+		if r.Context != "" {
+			out["context"] = r.Context
+		}
+	}
+	return out
+}
+
 func haltCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "halt",
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// planStep is where a multi-round synthesis stands: the prompt awaiting
+// model output, or the final output once the reduce round is done
+type planStep struct {
+	Agent   string
+	Channel string
+	Target  string
+	PlanID  int64             // the reduce event ID
+	Event   db.SynthesisEvent // the step awaiting output; zero when done
+	Round   int               // 1-based; map rounds first, reduce last
+	Rounds  int
+	Done    bool
+	Output  string // final output once done
+}
+
+// startPlan returns the next step of the agent's open plan, or splits the
+// pending thoughts of its first destination too big for one round into chunks
+// and starts a new one. ok is false when every destination fits a single
+// round and should be flushed normally.
+func startPlan(d *db.DB, agent string, force bool, chunkSize int) (step planStep, ok bool, err error) {
+	reduce, rounds, open, err := d.GetOpenPlan(agent)
+	if err != nil {
+		return step, false, err
+	}
+	if open {
+		step, err = nextPlanStep(d, reduce, rounds)
+		return step, true, err
+	}
+
+	if err := applyAging(d); err != nil {
+		return step, false, err
+	}
+	thoughts, err := d.GetPendingThoughts(agent)
+	if err != nil || len(thoughts) == 0 {
+		return step, false, err
+	}
+	now := time.Now()
+	if !force {
+		if err := checkMinInterval(d, agent, now); err != nil {
+			return step, false, err
+		}
+	}
+
+	budget := maxTokens
+	if budget == 0 {
+		budget = cfg.MaxTokens
+	}
+	var group synthesis.Group
+	var chunks [][]db.Thought
+	for _, g := range synthesis.GroupByDestination(thoughts) {
+		if g, _, err = coalesceGroup(d, g); err != nil {
+			return step, false, err
+		}
+		if chunks = synthesis.Chunk(g.Thoughts, chunkSize, budget); len(chunks) > 1 {
+			group = g
+			break
+		}
+	}
+	if len(chunks) <= 1 {
+		return step, false, nil
+	}
+
+	ids := make([][]int64, len(chunks))
+	prompts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		for _, t := range chunk {
+			ids[i] = append(ids[i], t.ID)
+		}
+		prompts[i] = synthesis.MapPrompt(agent, chunk, i+1, len(chunks), len(group.Thoughts))
+	}
+	plan := db.SynthesisEvent{AgentID: agent, Channel: group.Channel, Target: group.Target}
+	planID, err := d.CreateSynthesisPlan(plan, ids, prompts)
+	if err != nil {
+		return step, false, err
+	}
+	if err := afterFlush(d, agent, now); err != nil {
+		return step, false, err
+	}
+	step, err = planStepFor(d, planID)
+	return step, true, err
+}
+
+// submitPlanStep stores the model output for a pending step and returns the next one
+func submitPlanStep(d *db.DB, eventID int64, output string) (planStep, error) {
+	event, ok, err := d.GetSynthesisEvent(eventID)
+	if err != nil {
+		return planStep{}, err
+	}
+	if !ok {
+		return planStep{}, fmt.Errorf("synthesis event %d not found", eventID)
+	}
+	if event.Kind == db.EventSingle {
+		return planStep{}, fmt.Errorf("synthesis event %d is not part of a multi-round plan", eventID)
+	}
+	if err := d.CompleteSynthesisStep(eventID, output); err != nil {
+		return planStep{}, err
+	}
+	planID := event.ID
+	if event.Kind == db.EventMap {
+		planID = event.ParentID
+	}
+	return planStepFor(d, planID)
+}
+
+// planStepFor loads a plan by its reduce event ID and returns its next step
+func planStepFor(d *db.DB, planID int64) (planStep, error) {
+	reduce, ok, err := d.GetSynthesisEvent(planID)
+	if err != nil {
+		return planStep{}, err
+	}
+	if !ok {
+		return planStep{}, fmt.Errorf("synthesis plan %d not found", planID)
+	}
+	rounds, err := d.GetPlanRounds(planID)
+	if err != nil {
+		return planStep{}, err
+	}
+	return nextPlanStep(d, reduce, rounds)
+}
+
+// nextPlanStep finds the first map round still awaiting output. Once every
+// map round is done it renders the reduce prompt from their outputs and the
+// current channel context.
+func nextPlanStep(d *db.DB, reduce db.SynthesisEvent, rounds []db.SynthesisEvent) (planStep, error) {
+	step := planStep{
+		Agent:   reduce.AgentID,
+		Channel: reduce.Channel,
+		Target:  reduce.Target,
+		PlanID:  reduce.ID,
+		Rounds:  len(rounds) + 1,
+	}
+	for i, r := range rounds {
+		if r.State == db.StatePending {
+			step.Event, step.Round = r, i+1
+			return step, nil
+		}
+	}
+
+	switch reduce.State {
+	case db.StateDone:
+		step.Done, step.Output = true, reduce.Output
+		return step, nil
+	case db.StateWaiting:
+		data := synthesis.ReduceData{
+			AgentID: reduce.AgentID,
+			Channel: reduce.Channel,
+			Target:  reduce.Target,
+			Total:   reduce.ThoughtsCount,
+			Parts:   len(rounds),
+			Profile: cfg.ProfileFor(reduce.Channel),
+		}
+		for i, r := range rounds {
+			data.Partials = append(data.Partials, synthesis.Partial{Part: i + 1, Output: r.Output})
+		}
+		data.Reason, data.CongestionDuration = episodeContext(d, time.Now())
+		data.Context = channelContext
+		prompt := synthesis.ReducePrompt(data)
+		if err := d.OpenReduceStep(reduce.ID, prompt, data.Context); err != nil {
+			return step, err
+		}
+		reduce.Prompt, reduce.State, reduce.Context = prompt, db.StatePending, data.Context
+	}
+	step.Event, step.Round = reduce, step.Rounds
+	return step, nil
+}
+
+// readStepOutput returns the --output value, reading stdin for "-"
+func readStepOutput(value string) (string, error) {
+	if value == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		value = string(b)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("--output is empty")
+	}
+	return value, nil
+}
+
+// readInput returns the contents of the file named by a flag, or stdin for "-"
+func readInput(flag, path string) (string, error) {
+	if path == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		return string(b), err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading --%s: %w", flag, err)
+	}
+	return string(b), nil
+}
+
+// document is the --format json shape of a plan step
+func (s planStep) document() map[string]interface{} {
+	out := map[string]interface{}{
+		"agent":   s.Agent,
+		"channel": s.Channel,
+		"target":  s.Target,
+		"plan_id": s.PlanID,
+		"rounds":  s.Rounds,
+		"done":    s.Done,
+	}
+	if s.Done {
+		out["output"] = s.Output
+		return out
+	}
+	out["event_id"] = s.Event.ID
+	out["kind"] = s.Event.Kind
+	out["round"] = s.Round
+	out["thoughts"] = s.Event.ThoughtsCount
+	out["prompt"] = s.Event.Prompt
+	return out
+}
+
+// printPlanStep writes a plan step in the flush output format
+func printPlanStep(s planStep, format string) error {
+	switch format {
+	case formatMessages:
+		var messages []synthesis.Message
+		if !s.Done {
+			messages = append(messages, synthesis.Message{Role: "user", Content: s.Event.Prompt})
+		}
+		return printMessages(messages)
+	case formatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s.document())
+	}
+
+	if s.Done {
+		fmt.Println(s.Output)
+		tokyoGreen.Printf("\n  ✓ Plan %d complete (%d rounds)\n", s.PlanID, s.Rounds)
+		return nil
+	}
+	tokyoPurple.Printf("\n  ═══ Round %d/%d for %s (%s, event %d) ═══\n\n", s.Round, s.Rounds, destinationName(s.Channel, s.Target), s.Event.Kind, s.Event.ID)
+	fmt.Println(s.Event.Prompt)
+	tokyoDim.Printf("\n  → Submit the model output with: antibeaver flush --submit %d --output -\n", s.Event.ID)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+func requeueCmd() *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "requeue <event-id>",
+		Short: "Return a synthesis event's thoughts to pending",
+		Long: `Return a synthesis event's thoughts to pending.
+
+Use it when the message produced by a flush could not be delivered. Exactly
+the thoughts that event consumed go back to pending, including near-duplicates
+coalesced into them, and the next flush synthesizes them again. The event
+stays in the history with state 'rolled_back' and no longer counts toward the
+minimum interval.
+
+An event whose delivery was reported sent or acked is refused, since its
+thoughts already reached the channel; --force requeues it anyway.
+
+Each requeue counts as a failed attempt for the thoughts involved. A thought
+reaching dlq.max_attempts moves to the dead-letter queue instead of pending;
+see dlq.
+
+For a map-reduce plan, requeue the reduce event: the thoughts of every map
+round are requeued and the plan is abandoned.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid event ID %q", args[0])
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			r, requeued, err := d.RequeueSynthesis(id, cfg.DLQ.MaxAttempts, force)
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				return printJSON(map[string]interface{}{
+					"ok":       true,
+					"requeued": requeued,
+					"event_id": id,
+					"thoughts": r.Pending,
+					"dead":     r.Dead,
+				})
+			}
+			if requeued {
+				tokyoGreen.Print("  ✓ ")
+				tokyoMuted.Printf("Requeued %d thoughts from synthesis event %d\n", r.Pending, id)
+				if r.Dead > 0 {
+					tokyoOrange.Printf("  ☠ %d thoughts reached %d attempts and moved to the dead-letter queue\n", r.Dead, cfg.DLQ.MaxAttempts)
+				}
+			} else {
+				tokyoDim.Printf("  Synthesis event %d not found or already rolled back\n", id)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "Requeue even if the event's delivery was reported sent or acked")
+
+	return cmd
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+	"github.com/spf13/cobra"
+)
+
+func splitCmd() *cobra.Command {
+	var maxLength int
+	cmd := &cobra.Command{
+		Use:   "split <event-id>",
+		Short: "Split a synthesized message into parts that fit its channel",
+		Long: `Split a synthesized message into parts that fit its channel.
+
+The output recorded with complete is broken into an ordered sequence of
+messages no longer than the channel's channels.<name>.max_length, or
+--max-length when given. Breaks fall between sentences or lines where
+possible, then between words; a single word is cut only when it alone is too
+long. The sequence is stored with the synthesis event, replacing any earlier
+split, and printed in the order to send it. An output that already fits is a
+sequence of one.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid event ID %q", args[0])
+			}
+			if maxLength < 0 {
+				return fmt.Errorf("--max-length must be >= 0")
+			}
+
+			d, err := openDB()
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			event, ok, err := d.GetSynthesisEvent(id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("synthesis event %d not found", id)
+			}
+			if event.Kind == db.EventMap {
+				return fmt.Errorf("synthesis event %d is a map round; split its reduce event", id)
+			}
+			if event.Output == "" {
+				return fmt.Errorf("synthesis event %d has no output yet; record it with complete", id)
+			}
+			if !cmd.Flags().Changed("max-length") {
+				maxLength = cfg.ProfileFor(event.Channel).MaxLength
+			}
+
+			messages, err := d.SetMessages(id, synthesis.Split(event.Output, maxLength))
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				return printJSON(map[string]interface{}{
+					"ok":         true,
+					"event_id":   id,
+					"max_length": maxLength,
+					"messages":   messages,
+				})
+			}
+			limit := "no limit"
+			if maxLength > 0 {
+				limit = fmt.Sprintf("max %d characters", maxLength)
+			}
+			tokyoGreen.Print("  ✓ ")
+			tokyoMuted.Printf("Split synthesis event %d into %d messages for %s", id, len(messages), destinationName(event.Channel, event.Target))
+			tokyoDim.Printf(" (%s)\n", limit)
+			for _, m := range messages {
+				tokyoPurple.Printf("\n  ── %d/%d ", m.Seq, len(messages))
+				tokyoDim.Printf("(%d chars) ──\n", utf8.RuneCountInString(m.Content))
+				fmt.Println(m.Content)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&maxLength, "max-length", 0, "Longest message in characters, 0 for no limit (default from the channel profile)")
+
+	return cmd
+}
@@ -3,6 +3,7 @@ module github.com/rickhallett/antibeaver
 go 1.23
 
 require (
+	github.com/fatih/color v1.18.0
 	github.com/spf13/cobra v1.10.2
 	modernc.org/sqlite v1.34.5
 )
@@ -10,7 +11,6 @@ require (
 require (
 	github.com/common-nighthawk/go-figure v0.0.0-20210622060536-734e95fb86be // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
-	github.com/fatih/color v1.18.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
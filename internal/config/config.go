@@ -0,0 +1,506 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/priority"
+	"github.com/rickhallett/antibeaver/internal/recovery"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// Duration is a time.Duration that reads and writes as a Go duration string ("30s", "5m")
+type Duration time.Duration
+
+// UnmarshalJSON accepts a duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		*d = Duration(v)
+		return nil
+	}
+	var secs float64
+	if err := json.Unmarshal(b, &secs); err != nil {
+		return fmt.Errorf("invalid duration %s: want a string like \"30s\" or a number of seconds", string(b))
+	}
+	*d = Duration(time.Duration(secs * float64(time.Second)))
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Std returns the value as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// Express configures the P0 express lane that bypasses buffering
+type Express struct {
+	Enabled bool     `json:"enabled"`
+	Limit   int      `json:"limit"`
+	Window  Duration `json:"window"`
+}
+
+// Aging raises a pending thought's effective priority one level per Interval
+// waited, up to Ceiling. A zero Interval disables aging.
+type Aging struct {
+	Interval Duration `json:"interval"`
+	// Ceiling is a priority class; empty means the default class
+	Ceiling string `json:"ceiling"`
+}
+
+// Priorities replaces the built-in P0/P1/P2 classes when Classes is non-empty
+type Priorities struct {
+	Default string           `json:"default"`
+	Classes []priority.Class `json:"classes"`
+}
+
+// Recovery controls staggered flushing once congestion clears
+type Recovery struct {
+	MaxConcurrent int      `json:"max_concurrent"`
+	InitialWave   int      `json:"initial_wave"`
+	Spacing       Duration `json:"spacing"`
+	Jitter        Duration `json:"jitter"`
+}
+
+// Policy converts the settings for the recovery scheduler
+func (r Recovery) Policy() recovery.Policy {
+	return recovery.Policy{
+		MaxConcurrent: r.MaxConcurrent,
+		InitialWave:   r.InitialWave,
+		Spacing:       r.Spacing.Std(),
+		Jitter:        r.Jitter.Std(),
+	}
+}
+
+// ResourceLimit caps concurrent leases on a resource; zero means unlimited
+type ResourceLimit struct {
+	PerAgent int `json:"per_agent"`
+	Global   int `json:"global"`
+}
+
+// Bulkhead configures the lease-based concurrency limits behind acquire/release
+type Bulkhead struct {
+	LeaseTTL  Duration                 `json:"lease_ttl"`
+	Resources map[string]ResourceLimit `json:"resources"`
+}
+
+// MapReduce configures multi-round synthesis of large backlogs
+type MapReduce struct {
+	// ChunkSize is the most thoughts condensed in one map round
+	ChunkSize int `json:"chunk_size"`
+}
+
+// Coalesce configures near-duplicate collapsing before synthesis
+type Coalesce struct {
+	// Threshold is the word-set similarity, 0..1, at which thoughts count as
+	// near-duplicates; zero disables coalescing
+	Threshold float64 `json:"threshold"`
+}
+
+// Coverage configures the critical-content check run by complete
+type Coverage struct {
+	// MinScore is the coverage, 0..1, below which complete --strict rejects an output
+	MinScore float64 `json:"min_score"`
+}
+
+// DLQ configures the dead-letter queue for thoughts whose sends keep failing
+type DLQ struct {
+	// MaxAttempts is how many failed sends a thought may have before requeue
+	// moves it to the dead-letter queue; zero keeps requeueing it forever
+	MaxAttempts int `json:"max_attempts"`
+}
+
+// Delivery configures tracking of whether synthesized messages were sent
+type Delivery struct {
+	// Overdue is how long a synthesized message may go without a successful
+	// delivery report before status warns about it; zero disables the check
+	Overdue Duration `json:"overdue"`
+}
+
+// Channel describes what a destination channel accepts
+type Channel struct {
+	// MaxLength is the longest message the channel takes, in characters;
+	// zero is unlimited
+	MaxLength int `json:"max_length"`
+	// Markdown is whether the channel renders markdown; nil means it does
+	Markdown *bool `json:"markdown,omitempty"`
+	// Mentions is "all" (the default), "users" to rule out @everyone and
+	// @here, or "none"
+	Mentions string `json:"mentions,omitempty"`
+}
+
+// Channels holds channel profiles by name
+type Channels map[string]Channel
+
+// UnmarshalJSON merges each entry field by field over the profile already
+// held for that channel, so {"discord": {"markdown": false}} keeps the
+// built-in max_length
+func (c *Channels) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	merged := Channels{}
+	for name, ch := range *c {
+		merged[name] = ch
+	}
+	for name, entry := range raw {
+		ch := merged[name]
+		if ch.Markdown != nil {
+			// Decode into a copy rather than through the shared pointer
+			markdown := *ch.Markdown
+			ch.Markdown = &markdown
+		}
+		if err := json.Unmarshal(entry, &ch); err != nil {
+			return fmt.Errorf("channels.%s: %w", name, err)
+		}
+		merged[name] = ch
+	}
+	*c = merged
+	return nil
+}
+
+// Agent holds per-agent overrides; nil fields fall back to the global setting
+type Agent struct {
+	Debounce    *Duration `json:"debounce,omitempty"`
+	Cooldown    *Duration `json:"cooldown,omitempty"`
+	MinInterval *Duration `json:"min_interval,omitempty"`
+	Strategy    *string   `json:"strategy,omitempty"`
+	// PromptTemplate is a text/template file for this agent's prompts
+	PromptTemplate *string `json:"prompt_template,omitempty"`
+}
+
+// Config holds user configuration loaded from a JSON file
+type Config struct {
+	Express    Express    `json:"express"`
+	Aging      Aging      `json:"aging"`
+	Priorities Priorities `json:"priorities"`
+	// Debounce buffers an agent's messages for this long after the first one,
+	// even when the network is healthy, so bursts are coalesced. Zero disables it.
+	Debounce Duration `json:"debounce"`
+	// Cooldown buffers an agent's messages for this long after each flush
+	Cooldown Duration `json:"cooldown"`
+	// MinInterval is the shortest time allowed between an agent's synthesized outputs
+	MinInterval Duration `json:"min_interval"`
+	// Strategy names the synthesizer used by flush
+	Strategy string `json:"strategy"`
+	// PromptTemplate is a text/template file replacing the built-in recovery prompt.
+	// Relative paths are resolved against the config file's directory.
+	PromptTemplate string `json:"prompt_template"`
+	// MaxTokens caps the estimated size of synthesis prompts; zero is unlimited
+	MaxTokens int `json:"max_tokens"`
+	// ContextTokens caps the channel transcript passed to flush --context,
+	// keeping the newest lines; zero is unlimited
+	ContextTokens int       `json:"context_tokens"`
+	MapReduce     MapReduce `json:"map_reduce"`
+	Coalesce      Coalesce  `json:"coalesce"`
+	Coverage      Coverage  `json:"coverage"`
+	DLQ           DLQ       `json:"dlq"`
+	Delivery      Delivery  `json:"delivery"`
+	Recovery      Recovery  `json:"recovery"`
+	Bulkhead      Bulkhead  `json:"bulkhead"`
+	// Channels holds output profiles keyed by channel name
+	Channels Channels `json:"channels"`
+	Agents   map[string]Agent   `json:"agents"`
+
+	// templates holds parsed prompt templates keyed by agent; "" is the global one
+	templates map[string]*template.Template
+}
+
+// Default returns the built-in configuration
+func Default() Config {
+	plain := false
+	return Config{
+		Express: Express{
+			Enabled: true,
+			Limit:   3,
+			Window:  Duration(10 * time.Minute),
+		},
+		Aging: Aging{
+			Interval: Duration(30 * time.Minute),
+		},
+		Strategy:      synthesis.DefaultStrategy,
+		ContextTokens: 1000,
+		MapReduce: MapReduce{
+			ChunkSize: 50,
+		},
+		Coalesce: Coalesce{
+			Threshold: synthesis.DefaultCoalesceThreshold,
+		},
+		Coverage: Coverage{
+			MinScore: synthesis.DefaultMinCoverage,
+		},
+		DLQ: DLQ{
+			MaxAttempts: 3,
+		},
+		Delivery: Delivery{
+			Overdue: Duration(10 * time.Minute),
+		},
+		Recovery: defaultRecovery(),
+		Bulkhead: Bulkhead{
+			LeaseTTL: Duration(2 * time.Minute),
+			Resources: map[string]ResourceLimit{
+				"llm": {PerAgent: 2, Global: 8},
+			},
+		},
+		Channels: Channels{
+			"discord": {MaxLength: 2000},
+			"sms":     {MaxLength: 160, Markdown: &plain, Mentions: synthesis.MentionsNone},
+		},
+	}
+}
+
+func defaultRecovery() Recovery {
+	p := recovery.DefaultPolicy()
+	return Recovery{
+		MaxConcurrent: p.MaxConcurrent,
+		InitialWave:   p.InitialWave,
+		Spacing:       Duration(p.Spacing),
+		Jitter:        Duration(p.Jitter),
+	}
+}
+
+// Load reads configuration from path, layered over the defaults.
+// A missing file is not an error and yields the defaults.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	if err := cfg.loadTemplates(filepath.Dir(path)); err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// loadTemplates reads and parses every configured prompt template
+func (c *Config) loadTemplates(dir string) error {
+	files := map[string]string{}
+	if c.PromptTemplate != "" {
+		files[""] = c.PromptTemplate
+	}
+	for name, a := range c.Agents {
+		if a.PromptTemplate != nil && *a.PromptTemplate != "" {
+			files[name] = *a.PromptTemplate
+		}
+	}
+
+	c.templates = map[string]*template.Template{}
+	for agent, file := range files {
+		key := "prompt_template"
+		if agent != "" {
+			key = "agents." + agent + ".prompt_template"
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		text, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		t, err := synthesis.ParseTemplate(filepath.Base(file), string(text))
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		c.templates[agent] = t
+	}
+	return nil
+}
+
+// Validate checks that configured values are usable
+func (c Config) Validate() error {
+	if c.Express.Limit < 0 {
+		return fmt.Errorf("express.limit must be >= 0, got %d", c.Express.Limit)
+	}
+	if c.Express.Window < 0 {
+		return fmt.Errorf("express.window must be >= 0, got %s", c.Express.Window.Std())
+	}
+	if c.Aging.Interval < 0 {
+		return fmt.Errorf("aging.interval must be >= 0, got %s", c.Aging.Interval.Std())
+	}
+	if c.Recovery.MaxConcurrent < 1 || c.Recovery.InitialWave < 1 {
+		return fmt.Errorf("recovery.max_concurrent and recovery.initial_wave must be >= 1")
+	}
+	if c.Bulkhead.LeaseTTL.Std() < time.Second {
+		return fmt.Errorf("bulkhead.lease_ttl must be at least 1s, got %s", c.Bulkhead.LeaseTTL.Std())
+	}
+	for name, l := range c.Bulkhead.Resources {
+		if l.PerAgent < 0 || l.Global < 0 {
+			return fmt.Errorf("bulkhead.resources.%s limits must be >= 0", name)
+		}
+	}
+	if c.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must be >= 0, got %d", c.MaxTokens)
+	}
+	if c.ContextTokens < 0 {
+		return fmt.Errorf("context_tokens must be >= 0, got %d", c.ContextTokens)
+	}
+	if c.MapReduce.ChunkSize < 1 {
+		return fmt.Errorf("map_reduce.chunk_size must be >= 1, got %d", c.MapReduce.ChunkSize)
+	}
+	if c.Coalesce.Threshold < 0 || c.Coalesce.Threshold > 1 {
+		return fmt.Errorf("coalesce.threshold must be between 0 and 1, got %g", c.Coalesce.Threshold)
+	}
+	if c.Coverage.MinScore < 0 || c.Coverage.MinScore > 1 {
+		return fmt.Errorf("coverage.min_score must be between 0 and 1, got %g", c.Coverage.MinScore)
+	}
+	if c.DLQ.MaxAttempts < 0 {
+		return fmt.Errorf("dlq.max_attempts must be >= 0, got %d", c.DLQ.MaxAttempts)
+	}
+	if _, err := synthesis.Strategy(c.Strategy); err != nil {
+		return fmt.Errorf("strategy: %w", err)
+	}
+	for name, ch := range c.Channels {
+		if ch.MaxLength < 0 {
+			return fmt.Errorf("channels.%s.max_length must be >= 0, got %d", name, ch.MaxLength)
+		}
+		if !synthesis.ValidMentions(ch.Mentions) {
+			return fmt.Errorf("channels.%s.mentions must be %s, %s or %s, got %q", name,
+				synthesis.MentionsAll, synthesis.MentionsUsers, synthesis.MentionsNone, ch.Mentions)
+		}
+	}
+	global := map[string]Duration{
+		"debounce":         c.Debounce,
+		"cooldown":         c.Cooldown,
+		"min_interval":     c.MinInterval,
+		"recovery.spacing": c.Recovery.Spacing,
+		"recovery.jitter":  c.Recovery.Jitter,
+		"delivery.overdue": c.Delivery.Overdue,
+	}
+	for key, v := range global {
+		if v < 0 {
+			return fmt.Errorf("%s must be >= 0, got %s", key, v.Std())
+		}
+	}
+	for name, a := range c.Agents {
+		overrides := map[string]*Duration{
+			"debounce":     a.Debounce,
+			"cooldown":     a.Cooldown,
+			"min_interval": a.MinInterval,
+		}
+		for key, v := range overrides {
+			if v != nil && *v < 0 {
+				return fmt.Errorf("agents.%s.%s must be >= 0, got %s", name, key, v.Std())
+			}
+		}
+		if a.Strategy != nil {
+			if _, err := synthesis.Strategy(*a.Strategy); err != nil {
+				return fmt.Errorf("agents.%s.strategy: %w", name, err)
+			}
+		}
+	}
+	reg, err := c.Registry()
+	if err != nil {
+		return err
+	}
+	if _, ok := reg.Lookup(c.Aging.Ceiling); c.Aging.Ceiling != "" && !ok {
+		return fmt.Errorf("aging.ceiling %q is not a priority class", c.Aging.Ceiling)
+	}
+	return nil
+}
+
+// Registry builds the priority registry described by the config
+func (c Config) Registry() (*priority.Registry, error) {
+	if len(c.Priorities.Classes) == 0 {
+		return priority.Default(), nil
+	}
+	if c.Priorities.Default == "" {
+		return nil, fmt.Errorf("priorities.default is required when priorities.classes is set")
+	}
+	reg, err := priority.New(c.Priorities.Classes, c.Priorities.Default)
+	if err != nil {
+		return nil, fmt.Errorf("priorities: %w", err)
+	}
+	return reg, nil
+}
+
+// AgingCeiling returns the class aging stops at: aging.ceiling, or the
+// default priority class when unset
+func (c Config) AgingCeiling() string {
+	if c.Aging.Ceiling != "" {
+		return c.Aging.Ceiling
+	}
+	reg, err := c.Registry()
+	if err != nil {
+		return priority.Default().DefaultName()
+	}
+	return reg.DefaultName()
+}
+
+// DebounceFor returns the debounce window for an agent
+func (c Config) DebounceFor(agentID string) time.Duration {
+	if a, ok := c.Agents[agentID]; ok && a.Debounce != nil {
+		return a.Debounce.Std()
+	}
+	return c.Debounce.Std()
+}
+
+// CooldownFor returns the post-flush cooldown for an agent
+func (c Config) CooldownFor(agentID string) time.Duration {
+	if a, ok := c.Agents[agentID]; ok && a.Cooldown != nil {
+		return a.Cooldown.Std()
+	}
+	return c.Cooldown.Std()
+}
+
+// MinIntervalFor returns the minimum time between an agent's synthesized outputs
+func (c Config) MinIntervalFor(agentID string) time.Duration {
+	if a, ok := c.Agents[agentID]; ok && a.MinInterval != nil {
+		return a.MinInterval.Std()
+	}
+	return c.MinInterval.Std()
+}
+
+// StrategyFor returns the synthesis strategy for an agent
+func (c Config) StrategyFor(agentID string) string {
+	if a, ok := c.Agents[agentID]; ok && a.Strategy != nil {
+		return *a.Strategy
+	}
+	return c.Strategy
+}
+
+// ProfileFor returns the output profile of a channel; unknown channels have no limits
+func (c Config) ProfileFor(channel string) synthesis.Profile {
+	ch := c.Channels[channel]
+	return synthesis.Profile{
+		MaxLength: ch.MaxLength,
+		PlainText: ch.Markdown != nil && !*ch.Markdown,
+		Mentions:  ch.Mentions,
+	}
+}
+
+// TemplateFor returns the prompt template for an agent, or nil for the built-in one
+func (c Config) TemplateFor(agentID string) *template.Template {
+	if t, ok := c.templates[agentID]; ok {
+		return t
+	}
+	return c.templates[""]
+}
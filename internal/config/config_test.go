@@ -0,0 +1,518 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/config"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// LOAD TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestLoad(t *testing.T) {
+	t.Run("returns defaults for missing file", func(t *testing.T) {
+		cfg, err := config.Load(filepath.Join(t.TempDir(), "missing.json"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Express != config.Default().Express {
+			t.Error("expected default express config")
+		}
+	})
+
+	t.Run("returns defaults for empty path", func(t *testing.T) {
+		cfg, err := config.Load("")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !cfg.Express.Enabled {
+			t.Error("express lane should be enabled by default")
+		}
+	})
+
+	t.Run("overrides only given fields", func(t *testing.T) {
+		path := writeConfig(t, `{"express": {"limit": 1}}`)
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Express.Limit != 1 {
+			t.Errorf("expected limit 1, got %d", cfg.Express.Limit)
+		}
+		if cfg.Express.Window != config.Default().Express.Window {
+			t.Error("window should keep its default")
+		}
+	})
+
+	t.Run("parses duration strings", func(t *testing.T) {
+		path := writeConfig(t, `{"express": {"window": "90s"}}`)
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Express.Window.Std() != 90*time.Second {
+			t.Errorf("expected 90s, got %s", cfg.Express.Window.Std())
+		}
+	})
+
+	t.Run("parses numeric seconds", func(t *testing.T) {
+		path := writeConfig(t, `{"express": {"window": 30}}`)
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Express.Window.Std() != 30*time.Second {
+			t.Errorf("expected 30s, got %s", cfg.Express.Window.Std())
+		}
+	})
+
+	t.Run("rejects bad duration", func(t *testing.T) {
+		path := writeConfig(t, `{"express": {"window": "soon"}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for bad duration")
+		}
+	})
+
+	t.Run("rejects malformed json", func(t *testing.T) {
+		path := writeConfig(t, `{"express": `)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for malformed json")
+		}
+	})
+
+	t.Run("parses aging settings", func(t *testing.T) {
+		path := writeConfig(t, `{"aging": {"interval": "5m", "ceiling": "P0"}}`)
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Aging.Interval.Std() != 5*time.Minute || cfg.Aging.Ceiling != "P0" {
+			t.Errorf("unexpected aging config: %+v", cfg.Aging)
+		}
+	})
+
+	t.Run("rejects unknown aging ceiling", func(t *testing.T) {
+		path := writeConfig(t, `{"aging": {"ceiling": "urgent"}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for unknown ceiling")
+		}
+	})
+
+	t.Run("builds custom priority registry", func(t *testing.T) {
+		path := writeConfig(t, `{
+			"aging": {"ceiling": "user-facing"},
+			"priorities": {
+				"default": "user-facing",
+				"classes": [
+					{"name": "security", "rank": 0, "tag": "[SECURITY]", "express": true, "critical": true},
+					{"name": "user-facing", "rank": 1},
+					{"name": "telemetry", "rank": 2, "tag": "[telemetry]"}
+				]
+			}
+		}`)
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		reg, err := cfg.Registry()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c, ok := reg.Lookup("security")
+		if !ok || !c.Express || c.Tag != "[SECURITY]" {
+			t.Errorf("unexpected security class: %+v", c)
+		}
+		if _, ok := reg.Lookup("P0"); ok {
+			t.Error("custom classes should replace the defaults")
+		}
+	})
+
+	t.Run("requires default with custom classes", func(t *testing.T) {
+		path := writeConfig(t, `{"priorities": {"classes": [{"name": "P1", "rank": 0}]}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for missing default")
+		}
+	})
+
+	t.Run("rejects ceiling outside custom classes", func(t *testing.T) {
+		path := writeConfig(t, `{"aging": {"ceiling": "P1"}, "priorities": {"default": "a", "classes": [{"name": "a", "rank": 0}]}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for aging ceiling P1 not in classes")
+		}
+	})
+
+	t.Run("ceiling defaults to the default class", func(t *testing.T) {
+		if got := config.Default().AgingCeiling(); got != "P1" {
+			t.Errorf("expected P1, got %s", got)
+		}
+		path := writeConfig(t, `{
+			"priorities": {
+				"default": "user-facing",
+				"classes": [
+					{"name": "security", "rank": 0},
+					{"name": "user-facing", "rank": 1},
+					{"name": "telemetry", "rank": 2}
+				]
+			}
+		}`)
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := cfg.AgingCeiling(); got != "user-facing" {
+			t.Errorf("expected user-facing, got %s", got)
+		}
+	})
+
+	t.Run("parses recovery policy", func(t *testing.T) {
+		path := writeConfig(t, `{"recovery": {"max_concurrent": 1, "spacing": "30s", "jitter": "0s"}}`)
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		p := cfg.Recovery.Policy()
+		if p.MaxConcurrent != 1 || p.Spacing != 30*time.Second || p.Jitter != 0 || p.InitialWave != 1 {
+			t.Errorf("unexpected recovery policy: %+v", p)
+		}
+	})
+
+	t.Run("rejects zero concurrency", func(t *testing.T) {
+		path := writeConfig(t, `{"recovery": {"max_concurrent": 0}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for zero concurrency")
+		}
+	})
+
+	t.Run("merges bulkhead resources with defaults", func(t *testing.T) {
+		path := writeConfig(t, `{"bulkhead": {"resources": {"search": {"per_agent": 1, "global": 3}}}}`)
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Bulkhead.Resources["search"].Global != 3 {
+			t.Errorf("expected search limit, got %+v", cfg.Bulkhead.Resources)
+		}
+		if _, ok := cfg.Bulkhead.Resources["llm"]; !ok {
+			t.Error("expected default llm resource to remain")
+		}
+	})
+
+	t.Run("rejects zero lease ttl", func(t *testing.T) {
+		path := writeConfig(t, `{"bulkhead": {"lease_ttl": "0s"}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for zero ttl")
+		}
+	})
+
+	t.Run("rejects sub-second lease ttl", func(t *testing.T) {
+		path := writeConfig(t, `{"bulkhead": {"lease_ttl": "500ms"}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for sub-second ttl")
+		}
+	})
+
+	t.Run("rejects zero chunk size", func(t *testing.T) {
+		path := writeConfig(t, `{"map_reduce": {"chunk_size": 0}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for zero chunk size")
+		}
+	})
+
+	t.Run("rejects coalesce threshold above 1", func(t *testing.T) {
+		path := writeConfig(t, `{"coalesce": {"threshold": 1.5}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for threshold above 1")
+		}
+	})
+
+	t.Run("rejects coverage min score above 1", func(t *testing.T) {
+		path := writeConfig(t, `{"coverage": {"min_score": 2}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for min_score above 1")
+		}
+	})
+
+	t.Run("rejects negative dlq max attempts", func(t *testing.T) {
+		path := writeConfig(t, `{"dlq": {"max_attempts": -1}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for negative max_attempts")
+		}
+	})
+
+	t.Run("delivery overdue defaults to ten minutes", func(t *testing.T) {
+		if overdue := config.Default().Delivery.Overdue.Std(); overdue != 10*time.Minute {
+			t.Errorf("expected 10m default overdue, got %v", overdue)
+		}
+	})
+
+	t.Run("rejects negative delivery overdue", func(t *testing.T) {
+		path := writeConfig(t, `{"delivery": {"overdue": "-1m"}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for negative delivery.overdue")
+		}
+	})
+
+	t.Run("rejects invalid channel profile", func(t *testing.T) {
+		for _, json := range []string{
+			`{"channels": {"discord": {"max_length": -1}}}`,
+			`{"channels": {"slack": {"mentions": "everyone"}}}`,
+		} {
+			if _, err := config.Load(writeConfig(t, json)); err == nil || !strings.Contains(err.Error(), "channels.") {
+				t.Errorf("expected channels error for %s, got %v", json, err)
+			}
+		}
+	})
+
+	t.Run("rejects negative context tokens", func(t *testing.T) {
+		path := writeConfig(t, `{"context_tokens": -1}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for negative context_tokens")
+		}
+	})
+
+	t.Run("rejects negative max tokens", func(t *testing.T) {
+		path := writeConfig(t, `{"max_tokens": -1}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for negative max_tokens")
+		}
+	})
+
+	t.Run("rejects negative limit", func(t *testing.T) {
+		path := writeConfig(t, `{"express": {"limit": -1}}`)
+
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for negative limit")
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// PER-AGENT TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestDebounceFor(t *testing.T) {
+	path := writeConfig(t, `{"debounce": "3s", "agents": {"chatty": {"debounce": "10s"}, "quiet": {"debounce": 0}}}`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("uses global default", func(t *testing.T) {
+		if got := cfg.DebounceFor("main"); got != 3*time.Second {
+			t.Errorf("expected 3s, got %s", got)
+		}
+	})
+
+	t.Run("uses agent override", func(t *testing.T) {
+		if got := cfg.DebounceFor("chatty"); got != 10*time.Second {
+			t.Errorf("expected 10s, got %s", got)
+		}
+	})
+
+	t.Run("agent can disable", func(t *testing.T) {
+		if got := cfg.DebounceFor("quiet"); got != 0 {
+			t.Errorf("expected 0, got %s", got)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		if config.Default().DebounceFor("main") != 0 {
+			t.Error("debounce should be off by default")
+		}
+	})
+}
+
+func TestCooldownAndMinInterval(t *testing.T) {
+	path := writeConfig(t, `{"cooldown": "30s", "min_interval": "1m", "agents": {"main": {"cooldown": "5s"}}}`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("cooldown override", func(t *testing.T) {
+		if got := cfg.CooldownFor("main"); got != 5*time.Second {
+			t.Errorf("expected 5s, got %s", got)
+		}
+		if got := cfg.CooldownFor("architect"); got != 30*time.Second {
+			t.Errorf("expected 30s, got %s", got)
+		}
+	})
+
+	t.Run("min interval falls back to global", func(t *testing.T) {
+		if got := cfg.MinIntervalFor("main"); got != time.Minute {
+			t.Errorf("expected 1m, got %s", got)
+		}
+	})
+
+	t.Run("rejects negative agent override", func(t *testing.T) {
+		path := writeConfig(t, `{"agents": {"main": {"min_interval": "-1s"}}}`)
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for negative override")
+		}
+	})
+}
+
+func TestStrategyFor(t *testing.T) {
+	path := writeConfig(t, `{"strategy": "digest", "agents": {"pager": {"strategy": "latest-only"}}}`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("uses agent override", func(t *testing.T) {
+		if got := cfg.StrategyFor("pager"); got != "latest-only" {
+			t.Errorf("expected latest-only, got %s", got)
+		}
+		if got := cfg.StrategyFor("main"); got != "digest" {
+			t.Errorf("expected digest, got %s", got)
+		}
+	})
+
+	t.Run("defaults to prompt", func(t *testing.T) {
+		if got := config.Default().StrategyFor("main"); got != "prompt" {
+			t.Errorf("expected prompt, got %s", got)
+		}
+	})
+
+	t.Run("rejects unknown strategy", func(t *testing.T) {
+		path := writeConfig(t, `{"agents": {"main": {"strategy": "magic"}}}`)
+		if _, err := config.Load(path); err == nil {
+			t.Error("expected error for unknown strategy")
+		}
+	})
+}
+
+func TestProfileFor(t *testing.T) {
+	path := writeConfig(t, `{"channels": {"slack": {"max_length": 4000, "mentions": "users"}, "discord": {"markdown": false}}}`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("uses configured channel", func(t *testing.T) {
+		p := cfg.ProfileFor("slack")
+		if p.MaxLength != 4000 || p.PlainText || p.Mentions != "users" {
+			t.Errorf("unexpected profile: %+v", p)
+		}
+	})
+
+	t.Run("built-in channels", func(t *testing.T) {
+		if p := config.Default().ProfileFor("discord"); p.MaxLength != 2000 || p.PlainText {
+			t.Errorf("unexpected discord profile: %+v", p)
+		}
+		if p := config.Default().ProfileFor("sms"); p.MaxLength != 160 || !p.PlainText || p.Mentions != "none" {
+			t.Errorf("unexpected sms profile: %+v", p)
+		}
+	})
+
+	t.Run("partial override keeps the other built-in fields", func(t *testing.T) {
+		if p := cfg.ProfileFor("discord"); p.MaxLength != 2000 || !p.PlainText {
+			t.Errorf("unexpected discord profile: %+v", p)
+		}
+	})
+
+	t.Run("override can clear a built-in field", func(t *testing.T) {
+		path := writeConfig(t, `{"channels": {"sms": {"max_length": 0, "markdown": true}}}`)
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p := cfg.ProfileFor("sms"); p.MaxLength != 0 || p.PlainText || p.Mentions != "none" {
+			t.Errorf("unexpected sms profile: %+v", p)
+		}
+		if p := config.Default().ProfileFor("sms"); !p.PlainText {
+			t.Error("override should not change the built-in profile")
+		}
+	})
+
+	t.Run("unknown channel has no limits", func(t *testing.T) {
+		if p := cfg.ProfileFor("cli"); p != (synthesis.Profile{}) {
+			t.Errorf("expected zero profile, got %+v", p)
+		}
+	})
+}
+
+func TestTemplateFor(t *testing.T) {
+	t.Run("built-in template by default", func(t *testing.T) {
+		if config.Default().TemplateFor("main") != nil {
+			t.Error("expected nil for the built-in template")
+		}
+	})
+
+	t.Run("loads global and agent templates relative to config", func(t *testing.T) {
+		path := writeConfig(t, `{"prompt_template": "global.tmpl", "agents": {"es": {"prompt_template": "es.tmpl"}}}`)
+		dir := filepath.Dir(path)
+		os.WriteFile(filepath.Join(dir, "global.tmpl"), []byte(`{{.Count}} thoughts`), 0644)
+		os.WriteFile(filepath.Join(dir, "es.tmpl"), []byte(`{{.Count}} pensamientos`), 0644)
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.TemplateFor("es").Name() != "es.tmpl" {
+			t.Errorf("expected agent template, got %s", cfg.TemplateFor("es").Name())
+		}
+		if cfg.TemplateFor("main").Name() != "global.tmpl" {
+			t.Errorf("expected global template, got %s", cfg.TemplateFor("main").Name())
+		}
+	})
+
+	t.Run("reports missing file", func(t *testing.T) {
+		path := writeConfig(t, `{"prompt_template": "missing.tmpl"}`)
+		_, err := config.Load(path)
+		if err == nil || !strings.Contains(err.Error(), "prompt_template") {
+			t.Errorf("expected prompt_template error, got %v", err)
+		}
+	})
+
+	t.Run("reports invalid template with its key", func(t *testing.T) {
+		path := writeConfig(t, `{"agents": {"es": {"prompt_template": "bad.tmpl"}}}`)
+		os.WriteFile(filepath.Join(filepath.Dir(path), "bad.tmpl"), []byte(`{{.Missing}}`), 0644)
+
+		_, err := config.Load(path)
+		if err == nil || !strings.Contains(err.Error(), "agents.es.prompt_template") {
+			t.Errorf("expected agent template error, got %v", err)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// HELPER
+// ═══════════════════════════════════════════════════════════════════════════
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
@@ -1,11 +1,18 @@
 package db
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/rickhallett/antibeaver/internal/priority"
 	_ "modernc.org/sqlite"
 )
 
@@ -17,8 +24,28 @@ type Thought struct {
 	Target    string `json:"target"`
 	Content   string `json:"content"`
 	Priority  string `json:"priority"`
-	CreatedAt string `json:"created_at"`
-	Status    string `json:"status"`
+	// EffectivePriority is Priority after aging; empty means unchanged
+	EffectivePriority string `json:"effective_priority"`
+	CreatedAt         string `json:"created_at"`
+	Status            string `json:"status"`
+	// EventID is the synthesis event that consumed the thought, if any
+	EventID int64 `json:"event_id,omitempty"`
+	// CoalescedInto is the near-duplicate this thought was collapsed into, if any
+	CoalescedInto int64 `json:"coalesced_into,omitempty"`
+	// Coalesced counts the near-duplicates collapsed into this thought
+	Coalesced int `json:"coalesced,omitempty"`
+	// SupersededBy is the later thought that replaced this one, if any
+	SupersededBy int64 `json:"superseded_by,omitempty"`
+	// Attempts counts the flushes of this thought that were requeued after a failed send
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// Effective returns the priority used for ordering and tagging
+func (t Thought) Effective() string {
+	if t.EffectivePriority != "" {
+		return t.EffectivePriority
+	}
+	return t.Priority
 }
 
 // SynthesisEvent represents a synthesis event
@@ -26,10 +53,132 @@ type SynthesisEvent struct {
 	ID           int64  `json:"id"`
 	AgentID      string `json:"agent_id"`
 	ThoughtsCount int   `json:"thoughts_count"`
-	FinalOutput  string `json:"final_output"`
-	TriggeredAt  string `json:"triggered_at"`
+	// Prompt is what was generated for the model; empty when the strategy
+	// produced the message itself
+	Prompt      string `json:"prompt"`
+	TriggeredAt string `json:"triggered_at"`
+	// ParentID links a map round to the reduce event it feeds
+	ParentID int64  `json:"parent_id,omitempty"`
+	Kind     string `json:"kind"`
+	State    string `json:"state"`
+	// Output is what the model returned, or what a non-LLM strategy produced
+	Output string `json:"output,omitempty"`
+	// Model, PromptTokens and OutputTokens describe the completion, if reported
+	Model        string `json:"model,omitempty"`
+	PromptTokens int    `json:"prompt_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
+	// Delivery is whether the output reached its destination; see DeliverySent
+	Delivery string `json:"delivery,omitempty"`
+	// DeliveredAt is when Delivery was last reported
+	DeliveredAt string `json:"delivered_at,omitempty"`
+	CompletedAt string `json:"completed_at,omitempty"`
+	// Coverage is the share of critical content the output preserved, 0..1;
+	// nil until checked
+	Coverage *float64 `json:"coverage,omitempty"`
+	// Context is the channel transcript the prompt was built with, for auditing
+	Context string `json:"context,omitempty"`
+	// Channel and Target are the destination the synthesized thoughts were bound for
+	Channel string `json:"channel"`
+	Target  string `json:"target"`
+	// Agents are the agents whose thoughts the event consumed, sorted
+	Agents []string `json:"agents,omitempty"`
+	// Truncated is the consumed thought whose content the prompt cut short to
+	// fit the token budget; the full text stays on the thought
+	Truncated int64 `json:"truncated,omitempty"`
+}
+
+// AllAgents is the AgentID of a synthesis across every agent's thoughts for
+// one channel; the contributing agents are listed in SynthesisEvent.Agents
+const AllAgents = "*"
+
+// Synthesis event kinds
+const (
+	EventSingle = "single" // one-shot flush
+	EventMap    = "map"    // one chunk of a multi-round plan
+	EventReduce = "reduce" // combines the map outputs of a plan
+)
+
+// Synthesis event states
+const (
+	StatePending = "pending" // prompt ready, awaiting model output
+	StateWaiting = "waiting" // reduce step waiting on its map rounds
+	StateDone    = "done"
+	// StateRolledBack marks an event undone by requeue; its thoughts went back to pending
+	StateRolledBack = "rolled_back"
+)
+
+// Delivery states of a synthesis event's output. An event with no state, or
+// a failed one, is undelivered.
+const (
+	DeliverySent   = "sent"   // handed to the channel
+	DeliveryFailed = "failed" // the send failed
+	DeliveryAcked  = "acked"  // the channel confirmed receipt
+)
+
+// ValidDelivery reports whether s is a known delivery state
+func ValidDelivery(s string) bool {
+	return s == DeliverySent || s == DeliveryFailed || s == DeliveryAcked
+}
+
+// invalidDelivery is the error for an unknown delivery state
+func invalidDelivery(s string) error {
+	return fmt.Errorf("invalid delivery state %q (must be %s, %s or %s)", s, DeliverySent, DeliveryFailed, DeliveryAcked)
+}
+
+// Completion is the final result of a synthesis event, reported by the caller
+type Completion struct {
+	// Output is the final message; empty keeps the output already recorded
+	Output       string
+	Model        string
+	PromptTokens int
+	OutputTokens int
+	// Delivery is a delivery state, or empty if not yet known
+	Delivery string
+	// Coverage is the critical-content coverage score, if checked
+	Coverage *float64
 }
 
+// Message is one part of a synthesis output split to fit its channel
+type Message struct {
+	EventID int64 `json:"event_id"`
+	// Seq orders the parts, starting at 1
+	Seq     int    `json:"seq"`
+	Content string `json:"content"`
+}
+
+// CongestionEpisode describes the current or most recent buffering period
+type CongestionEpisode struct {
+	StartedAt string `json:"started_at"`
+	EndedAt   string `json:"ended_at"`
+	Reason    string `json:"reason"`
+}
+
+// Active reports whether the episode is still in progress
+func (e CongestionEpisode) Active() bool {
+	return e.StartedAt != "" && e.EndedAt == ""
+}
+
+// Duration returns how long the episode lasted, measured up to now if still active
+func (e CongestionEpisode) Duration(now time.Time) time.Duration {
+	start, err := time.Parse(timeLayout, e.StartedAt)
+	if err != nil {
+		return 0
+	}
+	end := now.UTC()
+	if e.EndedAt != "" {
+		if end, err = time.Parse(timeLayout, e.EndedAt); err != nil {
+			return 0
+		}
+	}
+	if d := end.Sub(start); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// timeLayout matches SQLite's datetime('now') format
+const timeLayout = "2006-01-02 15:04:05"
+
 // DB wraps the SQLite database
 type DB struct {
 	db *sql.DB
@@ -65,9 +214,21 @@ func Open(path string) (*DB, error) {
 		return nil, fmt.Errorf("failed to init schema: %w", err)
 	}
 
+	if err := d.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
 	return d, nil
 }
 
+// initSchema creates the version-0 schema on purpose: the tables as first
+// released, before any migration. New and existing databases then take the
+// same path through migrations, so there is one definition of the current
+// shape rather than two that can drift. On a new database the rebuild in
+// migration 2 copies an empty table, once, and migration 9 renames
+// final_output to prompt. express_sends needs no
+// migration of existing data and is created here with IF NOT EXISTS.
 func (d *DB) initSchema() error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS buffered_thoughts (
@@ -102,11 +263,144 @@ func (d *DB) initSchema() error {
 		key TEXT PRIMARY KEY,
 		value TEXT
 	);
+	
+	CREATE TABLE IF NOT EXISTS express_sends (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		agent_id TEXT NOT NULL,
+		priority TEXT NOT NULL,
+		sent_at TEXT NOT NULL DEFAULT (datetime('now'))
+	);
 	`
 	_, err := d.db.Exec(schema)
 	return err
 }
 
+// migrations upgrade the base schema in order; PRAGMA user_version records how many have run
+var migrations = []string{
+	// 1: priority aging
+	`ALTER TABLE buffered_thoughts ADD COLUMN effective_priority TEXT;
+	UPDATE buffered_thoughts SET effective_priority = priority;`,
+
+	// 2: drop the P0/P1/P2 CHECK so configured priority classes can be stored
+	`CREATE TABLE buffered_thoughts_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		agent_id TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		target TEXT DEFAULT '',
+		content TEXT NOT NULL,
+		priority TEXT DEFAULT 'P1',
+		created_at TEXT NOT NULL DEFAULT (datetime('now')),
+		status TEXT DEFAULT 'pending',
+		effective_priority TEXT
+	);
+	INSERT INTO buffered_thoughts_new (id, agent_id, channel, target, content, priority, created_at, status, effective_priority)
+		SELECT id, agent_id, channel, target, content, priority, created_at, status, effective_priority FROM buffered_thoughts;
+	DROP TABLE buffered_thoughts;
+	ALTER TABLE buffered_thoughts_new RENAME TO buffered_thoughts;
+	CREATE INDEX IF NOT EXISTS idx_pending ON buffered_thoughts(agent_id, status) WHERE status = 'pending';`,
+
+	// 3: bulkhead leases
+	`CREATE TABLE leases (
+		id TEXT PRIMARY KEY,
+		agent_id TEXT NOT NULL,
+		resource TEXT NOT NULL,
+		acquired_at TEXT NOT NULL,
+		expires_at TEXT NOT NULL
+	);
+	CREATE INDEX idx_leases_resource ON leases(resource, agent_id);`,
+
+	// 4: multi-round (map-reduce) synthesis
+	`ALTER TABLE synthesis_events ADD COLUMN parent_id INTEGER REFERENCES synthesis_events(id);
+	ALTER TABLE synthesis_events ADD COLUMN kind TEXT NOT NULL DEFAULT 'single';
+	ALTER TABLE synthesis_events ADD COLUMN state TEXT NOT NULL DEFAULT 'done';
+	ALTER TABLE synthesis_events ADD COLUMN output TEXT;
+	ALTER TABLE buffered_thoughts ADD COLUMN event_id INTEGER REFERENCES synthesis_events(id);
+	CREATE INDEX idx_events_parent ON synthesis_events(parent_id);`,
+
+	// 5: channel context supplied to the prompt
+	`ALTER TABLE synthesis_events ADD COLUMN context TEXT;`,
+
+	// 6: destination of each synthesis
+	`ALTER TABLE synthesis_events ADD COLUMN channel TEXT NOT NULL DEFAULT '';
+	ALTER TABLE synthesis_events ADD COLUMN target TEXT NOT NULL DEFAULT '';`,
+
+	// 7: near-duplicate coalescing
+	`ALTER TABLE buffered_thoughts ADD COLUMN coalesced_into INTEGER REFERENCES buffered_thoughts(id);
+	CREATE INDEX idx_coalesced ON buffered_thoughts(coalesced_into) WHERE coalesced_into IS NOT NULL;`,
+
+	// 8: explicit supersession
+	`ALTER TABLE buffered_thoughts ADD COLUMN superseded_by INTEGER REFERENCES buffered_thoughts(id);
+	CREATE INDEX idx_superseded ON buffered_thoughts(superseded_by) WHERE superseded_by IS NOT NULL;`,
+
+	// 9: prompt and model output kept apart, with completion details
+	`ALTER TABLE synthesis_events RENAME COLUMN final_output TO prompt;
+	ALTER TABLE synthesis_events ADD COLUMN model TEXT;
+	ALTER TABLE synthesis_events ADD COLUMN prompt_tokens INTEGER;
+	ALTER TABLE synthesis_events ADD COLUMN output_tokens INTEGER;
+	ALTER TABLE synthesis_events ADD COLUMN delivery TEXT;
+	ALTER TABLE synthesis_events ADD COLUMN completed_at TEXT;`,
+
+	// 10: critical-content coverage of the output
+	`ALTER TABLE synthesis_events ADD COLUMN coverage REAL;`,
+
+	// 11: agents contributing to each synthesis, for cross-agent flushes
+	`CREATE TABLE synthesis_event_agents (
+		event_id INTEGER NOT NULL REFERENCES synthesis_events(id),
+		agent_id TEXT NOT NULL,
+		PRIMARY KEY (event_id, agent_id)
+	);
+	CREATE INDEX idx_event_agents_agent ON synthesis_event_agents(agent_id);
+	INSERT INTO synthesis_event_agents (event_id, agent_id) SELECT id, agent_id FROM synthesis_events;`,
+
+	// 12: thoughts by event, for requeue
+	`CREATE INDEX idx_thoughts_event ON buffered_thoughts(event_id) WHERE event_id IS NOT NULL;`,
+
+	// 13: failed-send attempts and the dead-letter queue
+	`ALTER TABLE buffered_thoughts ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX idx_dead ON buffered_thoughts(agent_id) WHERE status = 'dead';`,
+
+	// 14: when the delivery state was reported
+	`ALTER TABLE synthesis_events ADD COLUMN delivered_at TEXT;
+	UPDATE synthesis_events SET delivered_at = completed_at WHERE delivery IS NOT NULL;`,
+
+	// 15: outputs split into messages that fit their channel
+	`CREATE TABLE synthesis_messages (
+		event_id INTEGER NOT NULL REFERENCES synthesis_events(id),
+		seq INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		PRIMARY KEY (event_id, seq)
+	);`,
+
+	// 16: thoughts cut to fit the token budget
+	`ALTER TABLE synthesis_events ADD COLUMN truncated INTEGER;`,
+}
+
+func (d *DB) migrate() error {
+	var version int
+	if err := d.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close closes the database
 func (d *DB) Close() error {
 	return d.db.Close()
@@ -120,35 +414,61 @@ func (d *DB) JournalMode() (string, error) {
 }
 
 // InsertThought inserts a new buffered thought
-func (d *DB) InsertThought(agentID, channel, target, content, priority string) (int64, error) {
-	// Validate priority
-	switch priority {
-	case "P0", "P1", "P2":
-		// valid
-	default:
-		return 0, fmt.Errorf("invalid priority: %s", priority)
+func (d *DB) InsertThought(agentID, channel, target, content, prio string) (int64, error) {
+	id, _, err := d.InsertSupersedingThought(agentID, channel, target, content, prio, 0)
+	return id, err
+}
+
+// InsertSupersedingThought adds a pending thought and, in the same
+// transaction, marks the agent's pending thought supersedes as replaced by it.
+// A supersedes of zero replaces nothing. It reports whether a thought was
+// superseded; see SupersedeThought.
+func (d *DB) InsertSupersedingThought(agentID, channel, target, content, prio string, supersedes int64) (int64, bool, error) {
+	// Validate priority against the configured classes
+	if _, ok := priority.Current().Lookup(prio); !ok {
+		return 0, false, fmt.Errorf("invalid priority: %s", prio)
 	}
 
-	result, err := d.db.Exec(
-		`INSERT INTO buffered_thoughts (agent_id, channel, target, content, priority) VALUES (?, ?, ?, ?, ?)`,
-		agentID, channel, target, content, priority,
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO buffered_thoughts (agent_id, channel, target, content, priority, effective_priority) VALUES (?, ?, ?, ?, ?, ?)`,
+		agentID, channel, target, content, prio, prio,
 	)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
-	return result.LastInsertId()
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+	n := 0
+	if supersedes != 0 {
+		n, err = execCount(tx, `
+			UPDATE buffered_thoughts SET status = 'superseded', superseded_by = ?
+			WHERE id = ? AND status = 'pending' AND agent_id = ?
+		`, id, supersedes, agentID)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	return id, n > 0, tx.Commit()
 }
 
-// GetPendingThoughts returns pending thoughts for an agent, sorted by priority then time
-func (d *DB) GetPendingThoughts(agentID string) ([]Thought, error) {
-	rows, err := d.db.Query(`
-		SELECT id, agent_id, channel, target, content, priority, created_at, status
-		FROM buffered_thoughts
-		WHERE agent_id = ? AND status = 'pending'
-		ORDER BY 
-			CASE priority WHEN 'P0' THEN 0 WHEN 'P1' THEN 1 WHEN 'P2' THEN 2 END,
-			created_at ASC
-	`, agentID)
+// thoughtColumns is the SELECT list read by queryThoughts
+const thoughtColumns = `id, agent_id, channel, target, content, priority,
+	COALESCE(effective_priority, priority), created_at, status, COALESCE(event_id, 0),
+	COALESCE(coalesced_into, 0),
+	(SELECT COUNT(*) FROM buffered_thoughts c WHERE c.coalesced_into = buffered_thoughts.id),
+	COALESCE(superseded_by, 0), attempts`
+
+// queryThoughts selects thoughts with the given WHERE/ORDER clause
+func (d *DB) queryThoughts(clause string, args ...interface{}) ([]Thought, error) {
+	rows, err := d.db.Query(`SELECT `+thoughtColumns+` FROM buffered_thoughts `+clause, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -157,7 +477,9 @@ func (d *DB) GetPendingThoughts(agentID string) ([]Thought, error) {
 	var thoughts []Thought
 	for rows.Next() {
 		var t Thought
-		if err := rows.Scan(&t.ID, &t.AgentID, &t.Channel, &t.Target, &t.Content, &t.Priority, &t.CreatedAt, &t.Status); err != nil {
+		if err := rows.Scan(&t.ID, &t.AgentID, &t.Channel, &t.Target, &t.Content, &t.Priority,
+			&t.EffectivePriority, &t.CreatedAt, &t.Status, &t.EventID,
+			&t.CoalescedInto, &t.Coalesced, &t.SupersededBy, &t.Attempts); err != nil {
 			return nil, err
 		}
 		thoughts = append(thoughts, t)
@@ -165,6 +487,132 @@ func (d *DB) GetPendingThoughts(agentID string) ([]Thought, error) {
 	return thoughts, rows.Err()
 }
 
+// GetPendingThoughts returns pending thoughts for an agent, sorted by effective priority then time
+func (d *DB) GetPendingThoughts(agentID string) ([]Thought, error) {
+	return d.queryPending(`agent_id = ?`, agentID)
+}
+
+// ThoughtFilter narrows a pending-thought query for a partial flush; zero
+// fields match everything
+type ThoughtFilter struct {
+	IDs []int64
+	// Priority matches the effective priority
+	Priority string
+	// Since matches thoughts buffered at or after this time
+	Since   time.Time
+	Channel string
+}
+
+// IsZero reports whether the filter matches every thought
+func (f ThoughtFilter) IsZero() bool {
+	return len(f.IDs) == 0 && f.Priority == "" && f.Since.IsZero() && f.Channel == ""
+}
+
+// GetFilteredPendingThoughts returns the agent's pending thoughts matching f,
+// sorted by effective priority then time. AllAgents matches every agent.
+func (d *DB) GetFilteredPendingThoughts(agentID string, f ThoughtFilter) ([]Thought, error) {
+	conds := []string{"1 = 1"}
+	var args []interface{}
+	if agentID != AllAgents {
+		conds = append(conds, "agent_id = ?")
+		args = append(args, agentID)
+	}
+	if len(f.IDs) > 0 {
+		conds = append(conds, "id IN (?"+strings.Repeat(", ?", len(f.IDs)-1)+")")
+		for _, id := range f.IDs {
+			args = append(args, id)
+		}
+	}
+	if f.Priority != "" {
+		conds = append(conds, "COALESCE(effective_priority, priority) = ?")
+		args = append(args, f.Priority)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format(timeLayout))
+	}
+	if f.Channel != "" {
+		conds = append(conds, "channel = ?")
+		args = append(args, f.Channel)
+	}
+	return d.queryPending(strings.Join(conds, " AND "), args...)
+}
+
+// queryPending selects pending thoughts matching cond, sorted by effective
+// priority then time
+func (d *DB) queryPending(cond string, args ...interface{}) ([]Thought, error) {
+	thoughts, err := d.queryThoughts(`
+		WHERE `+cond+` AND status = 'pending'
+		ORDER BY created_at ASC, id ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rank comes from the priority registry, so order in Go rather than SQL
+	reg := priority.Current()
+	sort.SliceStable(thoughts, func(i, j int) bool {
+		return reg.Rank(thoughts[i].Effective()) < reg.Rank(thoughts[j].Effective())
+	})
+	return thoughts, nil
+}
+
+// ApplyAging raises the effective priority of pending thoughts by one level per
+// interval waited, never above ceiling. It returns how many thoughts changed.
+func (d *DB) ApplyAging(now time.Time, interval time.Duration, ceiling string) (int, error) {
+	if interval <= 0 {
+		return 0, nil
+	}
+	reg := priority.Current()
+	if _, ok := reg.Lookup(ceiling); !ok {
+		return 0, fmt.Errorf("invalid aging ceiling: %s", ceiling)
+	}
+
+	rows, err := d.db.Query(`
+		SELECT id, priority, COALESCE(effective_priority, priority), created_at
+		FROM buffered_thoughts
+		WHERE status = 'pending'
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	type update struct {
+		id       int64
+		priority string
+	}
+	var updates []update
+	for rows.Next() {
+		var id int64
+		var original, effective, createdAt string
+		if err := rows.Scan(&id, &original, &effective, &createdAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		created, err := time.Parse(timeLayout, createdAt)
+		if err != nil {
+			continue
+		}
+		aged := reg.Promote(original, int(now.Sub(created)/interval), ceiling)
+		// Aging only raises priority; coalescing may already have raised it further
+		if reg.Rank(aged) < reg.Rank(effective) {
+			updates = append(updates, update{id, aged})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, u := range updates {
+		if _, err := d.db.Exec(`UPDATE buffered_thoughts SET effective_priority = ? WHERE id = ?`, u.priority, u.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(updates), nil
+}
+
+
 // GetPendingCount returns count of pending thoughts (all agents if agentID empty)
 func (d *DB) GetPendingCount(agentID string) (int, error) {
 	var count int
@@ -177,27 +625,66 @@ func (d *DB) GetPendingCount(agentID string) (int, error) {
 	return count, err
 }
 
-// GetPendingAgents returns distinct agent IDs with pending thoughts
+// GetPendingAgents returns distinct agent IDs with pending thoughts, ordered by
+// their most urgent pending priority, then by their oldest pending thought
 func (d *DB) GetPendingAgents() ([]string, error) {
-	rows, err := d.db.Query(`SELECT DISTINCT agent_id FROM buffered_thoughts WHERE status = 'pending'`)
+	rows, err := d.db.Query(`
+		SELECT agent_id, COALESCE(effective_priority, priority), MIN(created_at)
+		FROM buffered_thoughts
+		WHERE status = 'pending'
+		GROUP BY agent_id, COALESCE(effective_priority, priority)
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	type urgency struct {
+		rank   int
+		oldest string
+	}
+	reg := priority.Current()
+	byAgent := map[string]*urgency{}
 	var agents []string
 	for rows.Next() {
-		var a string
-		if err := rows.Scan(&a); err != nil {
+		var a, p, oldest string
+		if err := rows.Scan(&a, &p, &oldest); err != nil {
 			return nil, err
 		}
-		agents = append(agents, a)
+		rank := reg.Rank(p)
+		u, ok := byAgent[a]
+		if !ok {
+			byAgent[a] = &urgency{rank: rank, oldest: oldest}
+			agents = append(agents, a)
+			continue
+		}
+		if rank < u.rank {
+			u.rank = rank
+		}
+		if oldest < u.oldest {
+			u.oldest = oldest
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return agents, rows.Err()
+
+	sort.Slice(agents, func(i, j int) bool {
+		ui, uj := byAgent[agents[i]], byAgent[agents[j]]
+		if ui.rank != uj.rank {
+			return ui.rank < uj.rank
+		}
+		if ui.oldest != uj.oldest {
+			return ui.oldest < uj.oldest
+		}
+		return agents[i] < agents[j]
+	})
+	return agents, nil
 }
 
-// MarkSynthesized marks all pending thoughts for an agent as synthesized
-func (d *DB) MarkSynthesized(agentID, output string) (int, error) {
+// MarkSynthesized marks all pending thoughts for an agent as synthesized and
+// logs an event with the prompt generated for them
+func (d *DB) MarkSynthesized(agentID, prompt string) (int, error) {
 	// Get count first
 	count, err := d.GetPendingCount(agentID)
 	if err != nil {
@@ -217,26 +704,254 @@ func (d *DB) MarkSynthesized(agentID, output string) (int, error) {
 	}
 
 	// Log synthesis event
-	_, err = d.db.Exec(
-		`INSERT INTO synthesis_events (agent_id, thoughts_count, final_output) VALUES (?, ?, ?)`,
-		agentID, count, output,
+	result, err := d.db.Exec(
+		`INSERT INTO synthesis_events (agent_id, thoughts_count, prompt) VALUES (?, ?, ?)`,
+		agentID, count, prompt,
 	)
 	if err != nil {
 		return count, err
 	}
+	eventID, err := result.LastInsertId()
+	if err != nil {
+		return count, err
+	}
+	_, err = d.db.Exec(`INSERT INTO synthesis_event_agents (event_id, agent_id) VALUES (?, ?)`, eventID, agentID)
+	return count, err
+}
+
+// CoalesceThoughts collapses the pending thoughts in ids into keepID: they
+// become 'coalesced' with coalesced_into set, as do thoughts previously
+// collapsed into them. effective becomes the kept thought's effective
+// priority. It returns the number of thoughts collapsed.
+func (d *DB) CoalesceThoughts(keepID int64, ids []int64, effective string) (int, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, id := range ids {
+		if id == keepID {
+			continue
+		}
+		result, err := tx.Exec(`
+			UPDATE buffered_thoughts SET status = 'coalesced', coalesced_into = ?
+			WHERE id = ? AND status = 'pending'
+		`, keepID, id)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			continue
+		}
+		count++
+		if _, err := tx.Exec(`UPDATE buffered_thoughts SET coalesced_into = ? WHERE coalesced_into = ?`, keepID, id); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE buffered_thoughts SET effective_priority = ? WHERE id = ?`, effective, keepID); err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// RetractThought withdraws a pending thought. It stays in the database with
+// status 'retracted' for audit. It reports false if the thought is not pending.
+func (d *DB) RetractThought(id int64) (bool, error) {
+	result, err := d.db.Exec(`UPDATE buffered_thoughts SET status = 'retracted' WHERE id = ? AND status = 'pending'`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SupersedeThought marks the pending thought id as replaced by the later
+// thought by, which must belong to the same agent. It stays in the database
+// with status 'superseded' for audit. It reports false if id is not a pending
+// thought of that agent.
+func (d *DB) SupersedeThought(id, by int64) (bool, error) {
+	result, err := d.db.Exec(`
+		UPDATE buffered_thoughts SET status = 'superseded', superseded_by = ?
+		WHERE id = ? AND id != ? AND status = 'pending'
+		AND agent_id = (SELECT agent_id FROM buffered_thoughts WHERE id = ?)
+	`, by, id, by, by)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SupersedeThoughts marks the pending thoughts in ids superseded by thought
+// by, whichever agent wrote them, for strategies that drop outdated thoughts.
+// It returns the number superseded.
+func (d *DB) SupersedeThoughts(ids []int64, by int64) (int, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, id := range ids {
+		n, err := execCount(tx, `
+			UPDATE buffered_thoughts SET status = 'superseded', superseded_by = ?
+			WHERE id = ? AND id != ? AND status = 'pending'
+		`, by, id, by)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, tx.Commit()
+}
+
+// GetSupersededBy returns the thoughts replaced by any of ids, directly or
+// through a chain of supersessions, oldest first
+func (d *DB) GetSupersededBy(ids []int64) ([]Thought, error) {
+	var obsolete []Thought
+	seen := map[int64]bool{}
+	for len(ids) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+		found, err := d.queryThoughts(`WHERE status = 'superseded' AND superseded_by IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		ids = nil
+		for _, t := range found {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				obsolete = append(obsolete, t)
+				ids = append(ids, t.ID)
+			}
+		}
+	}
+	sort.Slice(obsolete, func(i, j int) bool {
+		if obsolete[i].CreatedAt != obsolete[j].CreatedAt {
+			return obsolete[i].CreatedAt < obsolete[j].CreatedAt
+		}
+		return obsolete[i].ID < obsolete[j].ID
+	})
+	return obsolete, nil
+}
+
+// RecordSynthesis marks the given pending thoughts synthesized and logs one
+// completed event for them, returning its ID. The event's AgentID, Prompt,
+// Output, Context, Channel and Target are stored as given. Thoughts
+// buffered after the output was generated are left pending for the next flush.
+// With AgentID set to AllAgents, thoughts of any agent are claimed and the
+// event is recorded against each agent whose thoughts it consumed.
+func (d *DB) RecordSynthesis(e SynthesisEvent, ids []int64) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	e.Kind, e.State = EventSingle, StateDone
+	eventID, err := insertEvent(tx, e)
+	if err != nil {
+		return 0, err
+	}
+	count, err := claimThoughts(tx, e.AgentID, ids, eventID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE synthesis_events SET thoughts_count = ? WHERE id = ?`, count, eventID); err != nil {
+		return 0, err
+	}
+	if err := linkEventAgents(tx, eventID, e.AgentID); err != nil {
+		return 0, err
+	}
+	return eventID, tx.Commit()
+}
+
+// linkEventAgents records the agents an event belongs to: agentID, unless it
+// is AllAgents, and the owners of the thoughts it consumed
+func linkEventAgents(tx *sql.Tx, eventID int64, agentID string) error {
+	if agentID != AllAgents {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO synthesis_event_agents (event_id, agent_id) VALUES (?, ?)`, eventID, agentID); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`
+		INSERT OR IGNORE INTO synthesis_event_agents (event_id, agent_id)
+		SELECT DISTINCT ?, agent_id FROM buffered_thoughts WHERE event_id = ?
+	`, eventID, eventID)
+	return err
+}
+
+// insertEvent adds a synthesis event inside tx and returns its ID
+func insertEvent(tx *sql.Tx, e SynthesisEvent) (int64, error) {
+	var parent interface{}
+	if e.ParentID != 0 {
+		parent = e.ParentID
+	}
+	result, err := tx.Exec(`
+		INSERT INTO synthesis_events (agent_id, thoughts_count, prompt, output, parent_id, kind, state, context, channel, target, truncated)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, NULLIF(?, ''), ?, ?, NULLIF(?, 0))
+	`, e.AgentID, e.ThoughtsCount, e.Prompt, e.Output, parent, e.Kind, e.State, e.Context, e.Channel, e.Target, e.Truncated)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
 
+// claimThoughts marks the agent's pending thoughts among ids synthesized by
+// eventID; AllAgents claims them whoever owns them
+func claimThoughts(tx *sql.Tx, agentID string, ids []int64, eventID int64) (int, error) {
+	count := 0
+	for _, id := range ids {
+		result, err := tx.Exec(`
+			UPDATE buffered_thoughts SET status = 'synthesized', event_id = ?
+			WHERE id = ? AND (agent_id = ? OR ? = ?) AND status = 'pending'
+		`, eventID, id, agentID, agentID, AllAgents)
+		if err != nil {
+			return 0, err
+		}
+		// Near-duplicates collapsed into the thought go with it
+		if _, err := tx.Exec(`UPDATE buffered_thoughts SET event_id = ? WHERE coalesced_into = ?`, eventID, id); err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		count += int(n)
+	}
 	return count, nil
 }
 
-// GetSynthesisEvents returns recent synthesis events for an agent
+// GetSynthesisEvents returns recent synthesis events for an agent, including
+// cross-agent events it contributed to
 func (d *DB) GetSynthesisEvents(agentID string, limit int) ([]SynthesisEvent, error) {
-	rows, err := d.db.Query(`
-		SELECT id, agent_id, thoughts_count, final_output, triggered_at
-		FROM synthesis_events
-		WHERE agent_id = ?
-		ORDER BY triggered_at DESC
+	return d.queryEvents(`
+		WHERE id IN (SELECT event_id FROM synthesis_event_agents WHERE agent_id = ?)
+		ORDER BY triggered_at DESC, id DESC
 		LIMIT ?
 	`, agentID, limit)
+}
+
+// eventColumns is the SELECT list read by queryEvents
+const eventColumns = `id, agent_id, COALESCE(thoughts_count, 0), COALESCE(prompt, ''), triggered_at,
+	COALESCE(parent_id, 0), kind, state, COALESCE(output, ''), COALESCE(context, ''), channel, target,
+	COALESCE(model, ''), COALESCE(prompt_tokens, 0), COALESCE(output_tokens, 0),
+	COALESCE(delivery, ''), COALESCE(delivered_at, ''), COALESCE(completed_at, ''), coverage, COALESCE(truncated, 0),
+	(SELECT COALESCE(group_concat(agent_id, ','), '') FROM synthesis_event_agents a WHERE a.event_id = synthesis_events.id)`
+
+// queryEvents selects synthesis events with the given WHERE/ORDER clause
+func (d *DB) queryEvents(clause string, args ...interface{}) ([]SynthesisEvent, error) {
+	rows, err := d.db.Query(`SELECT `+eventColumns+` FROM synthesis_events `+clause, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -245,18 +960,448 @@ func (d *DB) GetSynthesisEvents(agentID string, limit int) ([]SynthesisEvent, er
 	var events []SynthesisEvent
 	for rows.Next() {
 		var e SynthesisEvent
-		var output sql.NullString
-		if err := rows.Scan(&e.ID, &e.AgentID, &e.ThoughtsCount, &output, &e.TriggeredAt); err != nil {
+		var agents string
+		if err := rows.Scan(&e.ID, &e.AgentID, &e.ThoughtsCount, &e.Prompt, &e.TriggeredAt,
+			&e.ParentID, &e.Kind, &e.State, &e.Output, &e.Context, &e.Channel, &e.Target,
+			&e.Model, &e.PromptTokens, &e.OutputTokens, &e.Delivery, &e.DeliveredAt, &e.CompletedAt, &e.Coverage,
+			&e.Truncated, &agents); err != nil {
 			return nil, err
 		}
-		if output.Valid {
-			e.FinalOutput = output.String
+		if agents != "" {
+			e.Agents = strings.Split(agents, ",")
+			sort.Strings(e.Agents)
 		}
 		events = append(events, e)
 	}
 	return events, rows.Err()
 }
 
+// GetSynthesisEvent returns one synthesis event by ID
+func (d *DB) GetSynthesisEvent(id int64) (SynthesisEvent, bool, error) {
+	events, err := d.queryEvents(`WHERE id = ?`, id)
+	if err != nil || len(events) == 0 {
+		return SynthesisEvent{}, false, err
+	}
+	return events[0], true, nil
+}
+
+// CreateSynthesisPlan starts a multi-round synthesis: one map event per chunk,
+// each claiming its thoughts, all linked to a reduce event that waits on them.
+// plan gives the agent and destination; prompts[i] is the map prompt for
+// chunks[i]. It returns the reduce event ID.
+func (d *DB) CreateSynthesisPlan(plan SynthesisEvent, chunks [][]int64, prompts []string) (int64, error) {
+	if len(chunks) != len(prompts) {
+		return 0, fmt.Errorf("plan has %d chunks but %d prompts", len(chunks), len(prompts))
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	agentID := plan.AgentID
+	reduceID, err := insertEvent(tx, SynthesisEvent{
+		AgentID: agentID,
+		Kind:    EventReduce,
+		State:   StateWaiting,
+		Channel: plan.Channel,
+		Target:  plan.Target,
+	})
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for i, ids := range chunks {
+		mapID, err := insertEvent(tx, SynthesisEvent{
+			AgentID:  agentID,
+			ParentID: reduceID,
+			Kind:     EventMap,
+			State:    StatePending,
+			Prompt:   prompts[i],
+			Channel:  plan.Channel,
+			Target:   plan.Target,
+		})
+		if err != nil {
+			return 0, err
+		}
+		n, err := claimThoughts(tx, agentID, ids, mapID)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`UPDATE synthesis_events SET thoughts_count = ? WHERE id = ?`, n, mapID); err != nil {
+			return 0, err
+		}
+		if err := linkEventAgents(tx, mapID, agentID); err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	if _, err := tx.Exec(`UPDATE synthesis_events SET thoughts_count = ? WHERE id = ?`, total, reduceID); err != nil {
+		return 0, err
+	}
+	if err := linkEventAgents(tx, reduceID, agentID); err != nil {
+		return 0, err
+	}
+	return reduceID, tx.Commit()
+}
+
+// GetOpenPlan returns the agent's unfinished reduce event and its map rounds in order
+func (d *DB) GetOpenPlan(agentID string) (SynthesisEvent, []SynthesisEvent, bool, error) {
+	reduces, err := d.queryEvents(`
+		WHERE agent_id = ? AND kind = ? AND state NOT IN (?, ?)
+		ORDER BY id ASC LIMIT 1
+	`, agentID, EventReduce, StateDone, StateRolledBack)
+	if err != nil || len(reduces) == 0 {
+		return SynthesisEvent{}, nil, false, err
+	}
+	rounds, err := d.GetPlanRounds(reduces[0].ID)
+	if err != nil {
+		return SynthesisEvent{}, nil, false, err
+	}
+	return reduces[0], rounds, true, nil
+}
+
+// GetEventThoughts returns the thoughts a synthesis event consumed, oldest
+// first. For a reduce event that is every thought of its map rounds. Rows
+// coalesced into a consumed thought are included.
+func (d *DB) GetEventThoughts(eventID int64) ([]Thought, error) {
+	return d.queryThoughts(`
+		WHERE event_id = ?
+		OR event_id IN (SELECT id FROM synthesis_events WHERE parent_id = ?)
+		ORDER BY created_at ASC, id ASC
+	`, eventID, eventID)
+}
+
+// Requeued counts what RequeueSynthesis did with an event's thoughts
+type Requeued struct {
+	Pending int `json:"pending"`
+	Dead    int `json:"dead"`
+}
+
+// RequeueSynthesis undoes a flush whose output was never delivered. The
+// thoughts the event consumed, including those of its map rounds and any
+// coalesced into them, return to pending, and the event and its rounds are
+// marked rolled back. Each requeue counts as a failed attempt; a thought
+// reaching maxAttempts goes to the dead-letter queue instead (zero means no
+// limit). It reports false if the event is unknown or already rolled back.
+// Map rounds are requeued through their reduce event. Unless force is set, it
+// refuses an event whose delivery is sent or acked, since requeueing it would
+// send its thoughts a second time.
+func (d *DB) RequeueSynthesis(eventID int64, maxAttempts int, force bool) (Requeued, bool, error) {
+	var r Requeued
+	tx, err := d.db.Begin()
+	if err != nil {
+		return r, false, err
+	}
+	defer tx.Rollback()
+
+	var kind, state, delivery string
+	var parentID int64
+	err = tx.QueryRow(`SELECT kind, state, COALESCE(parent_id, 0), COALESCE(delivery, '') FROM synthesis_events WHERE id = ?`, eventID).
+		Scan(&kind, &state, &parentID, &delivery)
+	if err == sql.ErrNoRows || state == StateRolledBack {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, err
+	}
+	if kind == EventMap {
+		return r, false, fmt.Errorf("synthesis event %d is a map round; requeue its reduce event %d", eventID, parentID)
+	}
+	if !force && (delivery == DeliverySent || delivery == DeliveryAcked) {
+		return r, false, fmt.Errorf("synthesis event %d was already delivered (%s); force the requeue to send its thoughts again", eventID, delivery)
+	}
+
+	consumed := `(event_id = ? OR event_id IN (SELECT id FROM synthesis_events WHERE parent_id = ?))
+		AND status IN ('synthesized', 'coalesced')`
+	// Dead first, so the pending update below only sees the survivors
+	if maxAttempts > 0 {
+		if r.Dead, err = execCount(tx, `
+			UPDATE buffered_thoughts
+			SET status = 'dead', attempts = attempts + 1, event_id = NULL, coalesced_into = NULL
+			WHERE `+consumed+` AND attempts + 1 >= ?
+		`, eventID, eventID, maxAttempts); err != nil {
+			return r, false, err
+		}
+	}
+	if r.Pending, err = execCount(tx, `
+		UPDATE buffered_thoughts
+		SET status = 'pending', attempts = attempts + 1, event_id = NULL, coalesced_into = NULL
+		WHERE `+consumed+`
+	`, eventID, eventID); err != nil {
+		return r, false, err
+	}
+	if _, err := tx.Exec(`UPDATE synthesis_events SET state = ? WHERE id = ? OR parent_id = ?`, StateRolledBack, eventID, eventID); err != nil {
+		return r, false, err
+	}
+	return r, true, tx.Commit()
+}
+
+// execCount runs an UPDATE or DELETE in tx and returns the rows affected
+func execCount(tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// GetDeadThoughts returns the dead-letter queue, oldest first (all agents if agentID empty)
+func (d *DB) GetDeadThoughts(agentID string) ([]Thought, error) {
+	return d.queryThoughts(`
+		WHERE status = 'dead' AND (? = '' OR agent_id = ?)
+		ORDER BY created_at ASC, id ASC
+	`, agentID, agentID)
+}
+
+// GetDeadCount returns the size of the dead-letter queue (all agents if agentID empty)
+func (d *DB) GetDeadCount(agentID string) (int, error) {
+	var count int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM buffered_thoughts WHERE status = 'dead' AND (? = '' OR agent_id = ?)
+	`, agentID, agentID).Scan(&count)
+	return count, err
+}
+
+// RetryDeadThoughts returns dead thoughts to pending with their attempts
+// reset. No ids retries the whole queue. It returns the number retried.
+func (d *DB) RetryDeadThoughts(ids []int64) (int, error) {
+	return d.updateDead(`UPDATE buffered_thoughts SET status = 'pending', attempts = 0`, ids)
+}
+
+// PurgeDeadThoughts deletes dead thoughts. No ids purges the whole queue.
+// Thoughts superseded by or coalesced into a purged thought lose the link in
+// the same transaction, so no row points at a deleted one.
+// It returns the number deleted.
+func (d *DB) PurgeDeadThoughts(ids []int64) (int, error) {
+	where, args := deadClause(ids)
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, column := range []string{"superseded_by", "coalesced_into"} {
+		if _, err := tx.Exec(`UPDATE buffered_thoughts SET `+column+` = NULL
+			WHERE `+column+` IN (SELECT id FROM buffered_thoughts`+where+`)`, args...); err != nil {
+			return 0, err
+		}
+	}
+	n, err := execCount(tx, `DELETE FROM buffered_thoughts`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// updateDead runs stmt against the dead thoughts among ids, or all of them
+func (d *DB) updateDead(stmt string, ids []int64) (int, error) {
+	where, args := deadClause(ids)
+	result, err := d.db.Exec(stmt+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// deadClause is the WHERE clause selecting the dead thoughts among ids, or all of them
+func deadClause(ids []int64) (string, []interface{}) {
+	var args []interface{}
+	where := ` WHERE status = 'dead'`
+	if len(ids) > 0 {
+		where += ` AND id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	return where, args
+}
+
+// GetPlanRounds returns the map events feeding a reduce event, oldest first
+func (d *DB) GetPlanRounds(reduceID int64) ([]SynthesisEvent, error) {
+	return d.queryEvents(`WHERE parent_id = ? ORDER BY id ASC`, reduceID)
+}
+
+// CompleteSynthesisStep stores the model output for a pending step and marks it done
+func (d *DB) CompleteSynthesisStep(id int64, output string) error {
+	result, err := d.db.Exec(`
+		UPDATE synthesis_events SET output = ?, state = ?
+		WHERE id = ? AND state = ?
+	`, output, StateDone, id, StatePending)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("synthesis event %d is not awaiting output", id)
+	}
+	return nil
+}
+
+// CompleteSynthesis records the final output of a one-shot or reduce event,
+// with the model, token counts and delivery state the caller reports, and
+// stamps completed_at. Calling it again updates the record; a new output
+// drops the messages split from the old one. Map rounds and
+// unfinished plans take their output through CompleteSynthesisStep instead.
+func (d *DB) CompleteSynthesis(id int64, c Completion) (SynthesisEvent, error) {
+	e, ok, err := d.GetSynthesisEvent(id)
+	if err != nil {
+		return e, err
+	}
+	if !ok {
+		return e, fmt.Errorf("synthesis event %d not found", id)
+	}
+	switch {
+	case e.Kind == EventMap:
+		return e, fmt.Errorf("synthesis event %d is a map round; submit its output with flush --submit", id)
+	case e.State == StateRolledBack:
+		return e, fmt.Errorf("synthesis event %d was rolled back (requeued); nothing to complete", id)
+	case e.State != StateDone:
+		return e, fmt.Errorf("synthesis event %d is %s; finish the plan with flush --submit first", id, e.State)
+	case c.Output == "" && e.Output == "":
+		return e, fmt.Errorf("synthesis event %d has no output yet", id)
+	case c.Delivery != "" && !ValidDelivery(c.Delivery):
+		return e, invalidDelivery(c.Delivery)
+	case c.PromptTokens < 0 || c.OutputTokens < 0:
+		return e, fmt.Errorf("token counts must be >= 0")
+	}
+
+	_, err = d.db.Exec(`
+		UPDATE synthesis_events SET
+			output = COALESCE(NULLIF(?, ''), output),
+			model = COALESCE(NULLIF(?, ''), model),
+			prompt_tokens = COALESCE(NULLIF(?, 0), prompt_tokens),
+			output_tokens = COALESCE(NULLIF(?, 0), output_tokens),
+			delivery = COALESCE(NULLIF(?, ''), delivery),
+			delivered_at = CASE WHEN ? != '' THEN datetime('now') ELSE delivered_at END,
+			coverage = COALESCE(?, coverage),
+			completed_at = datetime('now')
+		WHERE id = ?
+	`, c.Output, c.Model, c.PromptTokens, c.OutputTokens, c.Delivery, c.Delivery, c.Coverage, id)
+	if err != nil {
+		return e, err
+	}
+	if c.Output != "" && c.Output != e.Output {
+		// Messages split from the old output no longer match it
+		if _, err := d.db.Exec(`DELETE FROM synthesis_messages WHERE event_id = ?`, id); err != nil {
+			return e, err
+		}
+	}
+	e, _, err = d.GetSynthesisEvent(id)
+	return e, err
+}
+
+// SetDelivery records whether a finished one-shot or reduce event's output
+// reached its destination, and stamps delivered_at
+func (d *DB) SetDelivery(id int64, delivery string) (SynthesisEvent, error) {
+	if !ValidDelivery(delivery) {
+		return SynthesisEvent{}, invalidDelivery(delivery)
+	}
+	e, ok, err := d.GetSynthesisEvent(id)
+	if err != nil {
+		return e, err
+	}
+	switch {
+	case !ok:
+		return e, fmt.Errorf("synthesis event %d not found", id)
+	case e.Kind == EventMap:
+		return e, fmt.Errorf("synthesis event %d is a map round; its reduce event carries the delivery", id)
+	case e.State != StateDone:
+		return e, fmt.Errorf("synthesis event %d is %s, not done", id, e.State)
+	}
+	if _, err := d.db.Exec(`
+		UPDATE synthesis_events SET delivery = ?, delivered_at = datetime('now') WHERE id = ?
+	`, delivery, id); err != nil {
+		return e, err
+	}
+	e, _, err = d.GetSynthesisEvent(id)
+	return e, err
+}
+
+// GetUndeliveredEvents returns finished one-shot and reduce events triggered
+// at or before the given time whose output has no delivery state or failed,
+// oldest first
+func (d *DB) GetUndeliveredEvents(before time.Time) ([]SynthesisEvent, error) {
+	return d.queryEvents(`
+		WHERE kind != ? AND state = ? AND (delivery IS NULL OR delivery = ?)
+		AND triggered_at <= ?
+		ORDER BY triggered_at ASC, id ASC
+	`, EventMap, StateDone, DeliveryFailed, before.UTC().Format(timeLayout))
+}
+
+// SetMessages replaces the message sequence of a finished one-shot or reduce
+// event with parts, in order
+func (d *DB) SetMessages(eventID int64, parts []string) ([]Message, error) {
+	e, ok, err := d.GetSynthesisEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("synthesis event %d not found", eventID)
+	case e.Kind == EventMap:
+		return nil, fmt.Errorf("synthesis event %d is a map round; split its reduce event", eventID)
+	case e.State != StateDone:
+		return nil, fmt.Errorf("synthesis event %d is %s, not done", eventID, e.State)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM synthesis_messages WHERE event_id = ?`, eventID); err != nil {
+		return nil, err
+	}
+	for i, content := range parts {
+		if _, err := tx.Exec(`
+			INSERT INTO synthesis_messages (event_id, seq, content) VALUES (?, ?, ?)
+		`, eventID, i+1, content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return d.GetMessages(eventID)
+}
+
+// GetMessages returns the message sequence of an event, in order
+func (d *DB) GetMessages(eventID int64) ([]Message, error) {
+	rows, err := d.db.Query(`
+		SELECT event_id, seq, content FROM synthesis_messages
+		WHERE event_id = ? ORDER BY seq ASC
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.EventID, &m.Seq, &m.Content); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// OpenReduceStep sets the prompt and channel context of a waiting reduce event and marks it pending
+func (d *DB) OpenReduceStep(id int64, prompt, context string) error {
+	_, err := d.db.Exec(`
+		UPDATE synthesis_events SET prompt = ?, context = NULLIF(?, ''), state = ?
+		WHERE id = ? AND kind = ? AND state = ?
+	`, prompt, context, StatePending, id, EventReduce, StateWaiting)
+	return err
+}
+
 // RecordLatency records a latency sample
 func (d *DB) RecordLatency(latencyMs int64) error {
 	_, err := d.db.Exec(`INSERT INTO network_metrics (latency_ms) VALUES (?)`, latencyMs)
@@ -318,6 +1463,11 @@ func (d *DB) setState(key, value string) error {
 	return err
 }
 
+func (d *DB) deleteState(key string) error {
+	_, err := d.db.Exec(`DELETE FROM state WHERE key = ?`, key)
+	return err
+}
+
 func (d *DB) IsHalted() bool {
 	v, _ := d.getState("halted")
 	return v == "true"
@@ -355,3 +1505,361 @@ func (d *DB) GetSimulatedLatency() int64 {
 func (d *DB) SetSimulatedLatency(ms int64) error {
 	return d.setState("simulated_ms", fmt.Sprintf("%d", ms))
 }
+
+// RecordExpressSend logs a message that bypassed buffering via the express lane
+func (d *DB) RecordExpressSend(agentID, priority string) error {
+	_, err := d.db.Exec(`INSERT INTO express_sends (agent_id, priority) VALUES (?, ?)`, agentID, priority)
+	return err
+}
+
+// ClaimExpressSend logs an express send if fewer than limit were made within
+// the last window (all agents). Counting and logging share one write lock, so
+// concurrent gates cannot overrun the limit. It returns the sends counted and
+// whether this one was logged.
+func (d *DB) ClaimExpressSend(agentID, priority string, window time.Duration, limit int) (int, bool, error) {
+	var count int
+	claimed := false
+	err := d.immediate(func(ctx context.Context, conn *sql.Conn) error {
+		err := conn.QueryRowContext(ctx, `
+			SELECT COUNT(*)
+			FROM express_sends
+			WHERE sent_at > datetime('now', '-' || ? || ' seconds')
+		`, int64(window.Seconds())).Scan(&count)
+		if err != nil || count >= limit {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, `INSERT INTO express_sends (agent_id, priority) VALUES (?, ?)`, agentID, priority)
+		claimed = err == nil
+		return err
+	})
+	return count, claimed, err
+}
+
+// CountExpressSends returns express sends within the last window (all agents)
+func (d *DB) CountExpressSends(window time.Duration) (int, error) {
+	var count int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM express_sends
+		WHERE sent_at > datetime('now', '-' || ? || ' seconds')
+	`, int64(window.Seconds())).Scan(&count)
+	return count, err
+}
+
+// CountExpressSendsSince returns express sends at or after the given timestamp
+func (d *DB) CountExpressSendsSince(since string) (int, error) {
+	var count int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM express_sends WHERE sent_at >= ?`, since).Scan(&count)
+	return count, err
+}
+
+// GetCongestionEpisode returns the current or most recent congestion episode
+func (d *DB) GetCongestionEpisode() (CongestionEpisode, error) {
+	var e CongestionEpisode
+	var err error
+	if e.StartedAt, err = d.getState("congestion_started_at"); err != nil {
+		return e, err
+	}
+	if e.EndedAt, err = d.getState("congestion_ended_at"); err != nil {
+		return e, err
+	}
+	if e.Reason, err = d.getState("congestion_reason"); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// TrackCongestion opens a new episode when buffering starts and closes it when buffering stops
+func (d *DB) TrackCongestion(buffering bool, reason string) error {
+	e, err := d.GetCongestionEpisode()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(timeLayout)
+
+	switch {
+	case buffering && !e.Active():
+		if err := d.setState("congestion_started_at", now); err != nil {
+			return err
+		}
+		if err := d.setState("congestion_ended_at", ""); err != nil {
+			return err
+		}
+		return d.setState("congestion_reason", reason)
+	case !buffering && e.Active():
+		return d.setState("congestion_ended_at", now)
+	}
+	return nil
+}
+
+const (
+	debouncePrefix = "debounce:"
+	cooldownPrefix = "cooldown:"
+)
+
+// AgentDeadline is a per-agent time stored in state
+type AgentDeadline struct {
+	AgentID string    `json:"agent_id"`
+	Until   time.Time `json:"until"`
+}
+
+func (d *DB) setAgentTime(prefix, agentID string, t time.Time) error {
+	return d.setState(prefix+agentID, t.UTC().Format(timeLayout))
+}
+
+func (d *DB) getAgentTime(prefix, agentID string) (time.Time, bool, error) {
+	v, err := d.getState(prefix + agentID)
+	if err != nil || v == "" {
+		return time.Time{}, false, err
+	}
+	t, err := time.Parse(timeLayout, v)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t, true, nil
+}
+
+// listAgentTimes returns per-agent times under prefix, earliest first
+func (d *DB) listAgentTimes(prefix string) ([]AgentDeadline, error) {
+	rows, err := d.db.Query(`
+		SELECT substr(key, ?), value FROM state
+		WHERE key LIKE ?
+		ORDER BY value ASC
+	`, len(prefix)+1, prefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AgentDeadline
+	for rows.Next() {
+		var a, v string
+		if err := rows.Scan(&a, &v); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(timeLayout, v)
+		if err != nil {
+			continue
+		}
+		out = append(out, AgentDeadline{AgentID: a, Until: t})
+	}
+	return out, rows.Err()
+}
+
+// OpenDebounceWindow starts a coalescing window for an agent that closes at until
+func (d *DB) OpenDebounceWindow(agentID string, until time.Time) error {
+	return d.setAgentTime(debouncePrefix, agentID, until)
+}
+
+// GetDebounceWindow returns when an agent's open window closes, if one is open
+func (d *DB) GetDebounceWindow(agentID string) (time.Time, bool, error) {
+	return d.getAgentTime(debouncePrefix, agentID)
+}
+
+// CloseDebounceWindow removes an agent's window
+func (d *DB) CloseDebounceWindow(agentID string) error {
+	return d.deleteState(debouncePrefix + agentID)
+}
+
+// ExpiredDebounceWindows returns agents whose window closed at or before now
+func (d *DB) ExpiredDebounceWindows(now time.Time) ([]string, error) {
+	windows, err := d.listAgentTimes(debouncePrefix)
+	if err != nil {
+		return nil, err
+	}
+	var agents []string
+	for _, w := range windows {
+		if !w.Until.After(now) {
+			agents = append(agents, w.AgentID)
+		}
+	}
+	return agents, nil
+}
+
+// SetCooldown buffers an agent's messages until the given time
+func (d *DB) SetCooldown(agentID string, until time.Time) error {
+	return d.setAgentTime(cooldownPrefix, agentID, until)
+}
+
+// GetCooldown returns when an agent's cooldown ends, if it has one
+func (d *DB) GetCooldown(agentID string) (time.Time, bool, error) {
+	return d.getAgentTime(cooldownPrefix, agentID)
+}
+
+// ActiveCooldowns returns agents still cooling down at now
+func (d *DB) ActiveCooldowns(now time.Time) ([]AgentDeadline, error) {
+	all, err := d.listAgentTimes(cooldownPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var active []AgentDeadline
+	for _, c := range all {
+		if c.Until.After(now) {
+			active = append(active, c)
+		}
+	}
+	return active, nil
+}
+
+// LastSynthesisAt returns when the agent's most recent synthesis event was
+// recorded, ignoring events rolled back by requeue
+func (d *DB) LastSynthesisAt(agentID string) (time.Time, bool, error) {
+	var last sql.NullString
+	err := d.db.QueryRow(`
+		SELECT MAX(e.triggered_at) FROM synthesis_events e
+		JOIN synthesis_event_agents a ON a.event_id = e.id
+		WHERE a.agent_id = ? AND e.state != ?
+	`, agentID, StateRolledBack).Scan(&last)
+	if err != nil || !last.Valid {
+		return time.Time{}, false, err
+	}
+	t, err := time.Parse(timeLayout, last.String)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t, true, nil
+}
+
+// Lease is a held slot on a bulkhead resource
+type Lease struct {
+	ID         string `json:"id"`
+	AgentID    string `json:"agent_id"`
+	Resource   string `json:"resource"`
+	AcquiredAt string `json:"acquired_at"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+// LeaseCount is the number of live leases one agent holds on a resource
+type LeaseCount struct {
+	Resource string `json:"resource"`
+	AgentID  string `json:"agent_id"`
+	Count    int    `json:"count"`
+}
+
+// MinLeaseTTL is the shortest lease lifetime; expiry is stored to the second
+const MinLeaseTTL = time.Second
+
+// AcquireLease takes a slot on resource for agentID if fewer than perAgent leases
+// are held by the agent and fewer than global in total; a limit of zero is unlimited.
+// Expired leases are reaped first, so slots held by crashed workers come back after ttl.
+// The returned bool is false when the resource is full.
+func (d *DB) AcquireLease(agentID, resource string, perAgent, global int, ttl time.Duration, now time.Time) (Lease, bool, error) {
+	if ttl < MinLeaseTTL {
+		return Lease{}, false, fmt.Errorf("lease ttl must be at least %s, got %s", MinLeaseTTL, ttl)
+	}
+	var l Lease
+	acquired := false
+	err := d.immediate(func(ctx context.Context, conn *sql.Conn) error {
+		nowStr := now.UTC().Format(timeLayout)
+		if _, err := conn.ExecContext(ctx, `DELETE FROM leases WHERE expires_at <= ?`, nowStr); err != nil {
+			return err
+		}
+
+		var total, mine int
+		err := conn.QueryRowContext(ctx, `
+			SELECT COUNT(*), COALESCE(SUM(agent_id = ?), 0)
+			FROM leases WHERE resource = ?
+		`, agentID, resource).Scan(&total, &mine)
+		if err != nil {
+			return err
+		}
+		if (global > 0 && total >= global) || (perAgent > 0 && mine >= perAgent) {
+			return nil
+		}
+
+		id, err := newLeaseID()
+		if err != nil {
+			return err
+		}
+		l = Lease{
+			ID:         id,
+			AgentID:    agentID,
+			Resource:   resource,
+			AcquiredAt: nowStr,
+			ExpiresAt:  now.Add(ttl).UTC().Format(timeLayout),
+		}
+		_, err = conn.ExecContext(ctx, `
+			INSERT INTO leases (id, agent_id, resource, acquired_at, expires_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, l.ID, l.AgentID, l.Resource, l.AcquiredAt, l.ExpiresAt)
+		acquired = err == nil
+		return err
+	})
+	if err != nil || !acquired {
+		return Lease{}, false, err
+	}
+	return l, true, nil
+}
+
+// immediate runs fn in a transaction that takes the write lock up front, so
+// concurrent callers cannot both act on the same count. fn's error rolls it back.
+func (d *DB) immediate(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA busy_timeout = 5000"); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	if err := fn(ctx, conn); err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return err
+	}
+	return nil
+}
+
+// ReleaseLease frees a lease; it returns false if the lease was already released
+// or had expired by now. Expired leases are left for AcquireLease to reap.
+func (d *DB) ReleaseLease(id string, now time.Time) (bool, error) {
+	result, err := d.db.Exec(`
+		DELETE FROM leases WHERE id = ? AND expires_at > ?
+	`, id, now.UTC().Format(timeLayout))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// LeaseCounts returns live leases grouped by resource and agent
+func (d *DB) LeaseCounts(now time.Time) ([]LeaseCount, error) {
+	rows, err := d.db.Query(`
+		SELECT resource, agent_id, COUNT(*)
+		FROM leases
+		WHERE expires_at > ?
+		GROUP BY resource, agent_id
+		ORDER BY resource, agent_id
+	`, now.UTC().Format(timeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []LeaseCount
+	for rows.Next() {
+		var c LeaseCount
+		if err := rows.Scan(&c.Resource, &c.AgentID, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+func newLeaseID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,12 +1,17 @@
 package db_test
 
 import (
+	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/priority"
 )
 
 // ═══════════════════════════════════════════════════════════════════════════
@@ -64,6 +69,90 @@ func TestOpen(t *testing.T) {
 		}
 	})
 
+	t.Run("migrates database created before aging", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "old.db")
+		raw, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			t.Fatalf("failed to open raw db: %v", err)
+		}
+		_, err = raw.Exec(`
+			CREATE TABLE buffered_thoughts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				agent_id TEXT NOT NULL,
+				channel TEXT NOT NULL,
+				target TEXT DEFAULT '',
+				content TEXT NOT NULL,
+				priority TEXT DEFAULT 'P1' CHECK(priority IN ('P0', 'P1', 'P2')),
+				created_at TEXT NOT NULL DEFAULT (datetime('now')),
+				status TEXT DEFAULT 'pending'
+			);
+			INSERT INTO buffered_thoughts (agent_id, channel, content, priority) VALUES ('main', 'cli', 'Old', 'P2');
+		`)
+		if err != nil {
+			t.Fatalf("failed to create old schema: %v", err)
+		}
+		raw.Close()
+
+		d, err := db.Open(dbPath)
+		if err != nil {
+			t.Fatalf("failed to open old db: %v", err)
+		}
+		defer d.Close()
+
+		thoughts, err := d.GetPendingThoughts("main")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(thoughts) != 1 || thoughts[0].EffectivePriority != "P2" {
+			t.Errorf("expected migrated thought with effective P2, got %+v", thoughts)
+		}
+
+		// The CHECK constraint is gone, so custom classes can be stored
+		reg, _ := priority.New([]priority.Class{{Name: "telemetry", Rank: 0}}, "telemetry")
+		orig := priority.Current()
+		priority.Use(reg)
+		defer priority.Use(orig)
+		if _, err := d.InsertThought("main", "cli", "", "Custom", "telemetry"); err != nil {
+			t.Errorf("expected custom class insert after migration, got %v", err)
+		}
+	})
+
+	t.Run("keeps old prompts when splitting prompt and output", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "old.db")
+		raw, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			t.Fatalf("failed to open raw db: %v", err)
+		}
+		_, err = raw.Exec(`
+			CREATE TABLE synthesis_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				agent_id TEXT NOT NULL,
+				thoughts_count INTEGER,
+				final_output TEXT,
+				triggered_at TEXT NOT NULL DEFAULT (datetime('now'))
+			);
+			INSERT INTO synthesis_events (agent_id, thoughts_count, final_output) VALUES ('main', 2, 'old prompt');
+		`)
+		if err != nil {
+			t.Fatalf("failed to create old schema: %v", err)
+		}
+		raw.Close()
+
+		d, err := db.Open(dbPath)
+		if err != nil {
+			t.Fatalf("failed to open old db: %v", err)
+		}
+		defer d.Close()
+
+		e, ok, _ := d.GetSynthesisEvent(1)
+		if !ok || e.Prompt != "old prompt" || e.Output != "" {
+			t.Errorf("expected the old final_output as the prompt, got %+v", e)
+		}
+		if len(e.Agents) != 1 || e.Agents[0] != "main" {
+			t.Errorf("expected the old event linked to its agent, got %v", e.Agents)
+		}
+	})
+
 	t.Run("opens in-memory database", func(t *testing.T) {
 		d, err := db.Open(":memory:")
 		if err != nil {
@@ -137,6 +226,32 @@ func TestInsertThought(t *testing.T) {
 		}
 	})
 
+	t.Run("accepts configured priority classes", func(t *testing.T) {
+		reg, _ := priority.New([]priority.Class{
+			{Name: "security", Rank: 0},
+			{Name: "telemetry", Rank: 1},
+		}, "telemetry")
+		orig := priority.Current()
+		priority.Use(reg)
+		defer priority.Use(orig)
+
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("main", "slack", "#ops", "Noise", "telemetry")
+		if _, err := d.InsertThought("main", "slack", "#ops", "Key leak", "security"); err != nil {
+			t.Fatalf("failed to insert custom class: %v", err)
+		}
+		if _, err := d.InsertThought("main", "slack", "#ops", "Old", "P0"); err == nil {
+			t.Error("expected error for class outside registry")
+		}
+
+		thoughts, _ := d.GetPendingThoughts("main")
+		if thoughts[0].Priority != "security" {
+			t.Errorf("expected security first, got %s", thoughts[0].Priority)
+		}
+	})
+
 	t.Run("handles empty content", func(t *testing.T) {
 		_, err := d.InsertThought("main", "slack", "#ops", "", "P1")
 		// Either reject or accept - document behavior
@@ -302,6 +417,132 @@ func TestGetPendingThoughts(t *testing.T) {
 	})
 }
 
+func TestGetFilteredPendingThoughts(t *testing.T) {
+	d := openTestDB(t)
+	defer d.Close()
+
+	id1, _ := d.InsertThought("main", "discord", "", "Deploy done", "P1")
+	id2, _ := d.InsertThought("main", "discord", "", "CI red", "P0")
+	id3, _ := d.InsertThought("main", "slack", "", "Standup moved", "P1")
+	id4, _ := d.InsertThought("architect", "discord", "", "Schema merged", "P0")
+
+	ids := func(thoughts []db.Thought) []int64 {
+		var out []int64
+		for _, th := range thoughts {
+			out = append(out, th.ID)
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		agent  string
+		filter db.ThoughtFilter
+		want   []int64
+	}{
+		{"zero filter matches all", "main", db.ThoughtFilter{}, []int64{id2, id1, id3}},
+		{"by IDs", "main", db.ThoughtFilter{IDs: []int64{id1, id3, id4}}, []int64{id1, id3}},
+		{"by effective priority", "main", db.ThoughtFilter{Priority: "P0"}, []int64{id2}},
+		{"by channel", "main", db.ThoughtFilter{Channel: "discord"}, []int64{id2, id1}},
+		{"combined", "main", db.ThoughtFilter{Channel: "discord", Priority: "P1"}, []int64{id1}},
+		{"since in the future", "main", db.ThoughtFilter{Since: time.Now().Add(time.Hour)}, nil},
+		{"since the past", "main", db.ThoughtFilter{Since: time.Now().Add(-time.Hour)}, []int64{id2, id1, id3}},
+		{"all agents for a channel", db.AllAgents, db.ThoughtFilter{Channel: "discord"}, []int64{id2, id4, id1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thoughts, err := d.GetFilteredPendingThoughts(tt.agent, tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := ids(thoughts); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if !(db.ThoughtFilter{}).IsZero() || (db.ThoughtFilter{Priority: "P0"}).IsZero() {
+		t.Error("IsZero should report only the empty filter")
+	}
+}
+
+func TestApplyAging(t *testing.T) {
+	t.Run("disabled with zero interval", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("main", "slack", "#ops", "Low", "P2")
+
+		changed, err := d.ApplyAging(time.Now().Add(24*time.Hour), 0, "P0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if changed != 0 {
+			t.Errorf("expected no changes, got %d", changed)
+		}
+	})
+
+	t.Run("leaves fresh thoughts alone", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("main", "slack", "#ops", "Low", "P2")
+
+		changed, _ := d.ApplyAging(time.Now().UTC(), time.Hour, "P0")
+		if changed != 0 {
+			t.Errorf("expected no changes, got %d", changed)
+		}
+	})
+
+	t.Run("promotes one level per interval", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("main", "slack", "#ops", "Low", "P2")
+
+		d.ApplyAging(time.Now().UTC().Add(90*time.Minute), time.Hour, "P0")
+		thoughts, _ := d.GetPendingThoughts("main")
+		if thoughts[0].Priority != "P2" || thoughts[0].EffectivePriority != "P1" {
+			t.Errorf("expected P2→P1, got %s→%s", thoughts[0].Priority, thoughts[0].EffectivePriority)
+		}
+	})
+
+	t.Run("stops at ceiling", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("main", "slack", "#ops", "Low", "P2")
+
+		d.ApplyAging(time.Now().UTC().Add(10*time.Hour), time.Hour, "P1")
+		thoughts, _ := d.GetPendingThoughts("main")
+		if thoughts[0].EffectivePriority != "P1" {
+			t.Errorf("expected ceiling P1, got %s", thoughts[0].EffectivePriority)
+		}
+	})
+
+	t.Run("aged thoughts sort ahead of newer peers", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("main", "slack", "#ops", "Old low", "P2")
+		d.ApplyAging(time.Now().UTC().Add(2*time.Hour), time.Hour, "P0")
+		d.InsertThought("main", "slack", "#ops", "New normal", "P1")
+
+		thoughts, _ := d.GetPendingThoughts("main")
+		if thoughts[0].Content != "Old low" {
+			t.Errorf("expected aged thought first, got %s", thoughts[0].Content)
+		}
+	})
+
+	t.Run("rejects unknown ceiling", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		if _, err := d.ApplyAging(time.Now(), time.Hour, "P9"); err == nil {
+			t.Error("expected error for unknown ceiling")
+		}
+	})
+}
+
 func TestGetPendingCount(t *testing.T) {
 	t.Run("returns 0 for empty db", func(t *testing.T) {
 		d := openTestDB(t)
@@ -380,6 +621,27 @@ func TestGetPendingAgents(t *testing.T) {
 			t.Errorf("expected 2 agents, got %d", len(agents))
 		}
 	})
+
+	t.Run("orders by most urgent pending priority", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("low", "slack", "#ops", "Chatter", "P2")
+		d.InsertThought("mixed", "slack", "#ops", "Normal", "P1")
+		d.InsertThought("mixed", "slack", "#ops", "Down!", "P0")
+		d.InsertThought("normal", "slack", "#ops", "Update", "P1")
+
+		agents, err := d.GetPendingAgents()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := []string{"mixed", "normal", "low"}
+		for i := range want {
+			if i >= len(agents) || agents[i] != want[i] {
+				t.Fatalf("expected %v, got %v", want, agents)
+			}
+		}
+	})
 }
 
 func TestMarkSynthesized(t *testing.T) {
@@ -449,153 +711,1434 @@ func TestMarkSynthesized(t *testing.T) {
 	})
 }
 
-// ═══════════════════════════════════════════════════════════════════════════
-// NETWORK METRICS TESTS
-// ═══════════════════════════════════════════════════════════════════════════
-
-func TestRecordLatency(t *testing.T) {
-	t.Run("records latency sample", func(t *testing.T) {
+func TestCoalesceThoughts(t *testing.T) {
+	t.Run("collapses into the kept thought", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		err := d.RecordLatency(500)
+		id1, _ := d.InsertThought("main", "cli", "", "Build failed", "P0")
+		id2, _ := d.InsertThought("main", "cli", "", "build failed", "P2")
+		keep, _ := d.InsertThought("main", "cli", "", "Build failed!", "P2")
+
+		n, err := d.CoalesceThoughts(keep, []int64{id1, id2}, "P0")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
+		if n != 2 {
+			t.Errorf("expected 2 collapsed, got %d", n)
+		}
+
+		pending, _ := d.GetPendingThoughts("main")
+		if len(pending) != 1 || pending[0].ID != keep {
+			t.Fatalf("expected only the kept thought pending, got %+v", pending)
+		}
+		if pending[0].Coalesced != 2 || pending[0].Effective() != "P0" {
+			t.Errorf("unexpected kept thought: %+v", pending[0])
+		}
 	})
 
-	t.Run("records multiple samples", func(t *testing.T) {
+	t.Run("re-points earlier collapses", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		for i := 0; i < 100; i++ {
-			d.RecordLatency(int64(i * 10))
+		id1, _ := d.InsertThought("main", "cli", "", "Disk full", "P1")
+		id2, _ := d.InsertThought("main", "cli", "", "Disk full", "P1")
+		d.CoalesceThoughts(id2, []int64{id1}, "P1")
+		id3, _ := d.InsertThought("main", "cli", "", "Disk full", "P1")
+		d.CoalesceThoughts(id3, []int64{id2}, "P1")
+
+		pending, _ := d.GetPendingThoughts("main")
+		if len(pending) != 1 || pending[0].ID != id3 || pending[0].Coalesced != 2 {
+			t.Errorf("expected both earlier thoughts folded into the newest, got %+v", pending)
+		}
+	})
+
+	t.Run("skips thoughts that are no longer pending", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		done, _ := d.InsertThought("main", "cli", "", "Sent already", "P1")
+		d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "output"}, []int64{done})
+		keep, _ := d.InsertThought("main", "cli", "", "Sent already", "P1")
+
+		if n, _ := d.CoalesceThoughts(keep, []int64{done}, "P1"); n != 0 {
+			t.Errorf("expected nothing collapsed, got %d", n)
 		}
 	})
 }
 
-func TestGetAverageLatency(t *testing.T) {
-	t.Run("returns 0 for empty", func(t *testing.T) {
+func TestRetractThought(t *testing.T) {
+	t.Run("removes from pending but keeps the row", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		avg, err := d.GetAverageLatency(1)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+		id, _ := d.InsertThought("main", "cli", "", "Ignore me", "P1")
+		ok, err := d.RetractThought(id)
+		if err != nil || !ok {
+			t.Fatalf("expected retraction, got %v, %v", ok, err)
 		}
-		if avg != 0 {
-			t.Errorf("expected 0, got %d", avg)
+		if count, _ := d.GetPendingCount("main"); count != 0 {
+			t.Errorf("expected no pending thoughts, got %d", count)
+		}
+		if again, _ := d.RetractThought(id); again {
+			t.Error("second retraction should report false")
 		}
 	})
 
-	t.Run("calculates average", func(t *testing.T) {
+	t.Run("reports unknown thoughts", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		d.RecordLatency(100)
-		d.RecordLatency(200)
-		d.RecordLatency(300)
+		if ok, _ := d.RetractThought(42); ok {
+			t.Error("expected false for unknown ID")
+		}
+	})
+}
 
-		avg, err := d.GetAverageLatency(1)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+func TestSupersedeThought(t *testing.T) {
+	t.Run("replaces the earlier thought", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		old, _ := d.InsertThought("main", "cli", "", "Deploy at 5", "P1")
+		newer, _ := d.InsertThought("main", "cli", "", "Deploy at 6", "P1")
+		ok, err := d.SupersedeThought(old, newer)
+		if err != nil || !ok {
+			t.Fatalf("expected supersession, got %v, %v", ok, err)
 		}
-		if avg != 200 {
-			t.Errorf("expected 200, got %d", avg)
+
+		pending, _ := d.GetPendingThoughts("main")
+		if len(pending) != 1 || pending[0].ID != newer {
+			t.Errorf("expected only the newer thought pending, got %+v", pending)
+		}
+		obsolete, _ := d.GetSupersededBy([]int64{newer})
+		if len(obsolete) != 1 || obsolete[0].ID != old || obsolete[0].SupersededBy != newer || obsolete[0].Status != "superseded" {
+			t.Errorf("unexpected obsolete thoughts: %+v", obsolete)
 		}
 	})
-}
 
-func TestGetMaxLatency(t *testing.T) {
-	t.Run("returns 0 for empty", func(t *testing.T) {
+	t.Run("supersedes several thoughts at once", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		max, err := d.GetMaxLatency(1)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+		a, _ := d.InsertThought("main", "cli", "", "Status 1", "P1")
+		b, _ := d.InsertThought("architect", "cli", "", "Status 2", "P1")
+		latest, _ := d.InsertThought("main", "cli", "", "Status 3", "P1")
+		d.RecordSynthesis(db.SynthesisEvent{AgentID: db.AllAgents, Output: "Status 3"}, []int64{latest})
+
+		n, err := d.SupersedeThoughts([]int64{a, b, latest}, latest)
+		if err != nil || n != 2 {
+			t.Fatalf("expected 2 superseded, got %d (%v)", n, err)
 		}
-		if max != 0 {
-			t.Errorf("expected 0, got %d", max)
+		if obsolete, _ := d.GetSupersededBy([]int64{latest}); len(obsolete) != 2 {
+			t.Errorf("expected both older thoughts superseded, got %+v", obsolete)
 		}
 	})
 
-	t.Run("finds max", func(t *testing.T) {
+	t.Run("follows chains", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		d.RecordLatency(100)
-		d.RecordLatency(500)
-		d.RecordLatency(200)
+		a, _ := d.InsertThought("main", "cli", "", "v1", "P1")
+		b, _ := d.InsertThought("main", "cli", "", "v2", "P1")
+		c, _ := d.InsertThought("main", "cli", "", "v3", "P1")
+		d.SupersedeThought(a, b)
+		d.SupersedeThought(b, c)
 
-		max, err := d.GetMaxLatency(1)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+		obsolete, _ := d.GetSupersededBy([]int64{c})
+		if len(obsolete) != 2 || obsolete[0].ID != a || obsolete[1].ID != b {
+			t.Errorf("expected both earlier versions, oldest first, got %+v", obsolete)
 		}
-		if max != 500 {
-			t.Errorf("expected 500, got %d", max)
+	})
+
+	t.Run("refuses another agent's thought", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		other, _ := d.InsertThought("architect", "cli", "", "Not mine", "P1")
+		mine, _ := d.InsertThought("main", "cli", "", "Mine", "P1")
+		if ok, _ := d.SupersedeThought(other, mine); ok {
+			t.Error("should not supersede another agent's thought")
+		}
+		if count, _ := d.GetPendingCount("architect"); count != 1 {
+			t.Error("another agent's thought should stay pending")
 		}
 	})
 }
 
-// ═══════════════════════════════════════════════════════════════════════════
-// STATE TESTS
-// ═══════════════════════════════════════════════════════════════════════════
+func TestInsertSupersedingThought(t *testing.T) {
+	t.Run("inserts and supersedes together", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
 
-func TestState(t *testing.T) {
-	t.Run("gets and sets halt state", func(t *testing.T) {
+		old, _ := d.InsertThought("main", "cli", "", "Deploy at 5pm", "P1")
+		id, ok, err := d.InsertSupersedingThought("main", "cli", "", "Deploy at 6pm", "P1", old)
+		if err != nil || !ok {
+			t.Fatalf("expected thought superseded, got %v (%v)", ok, err)
+		}
+		obsolete, _ := d.GetSupersededBy([]int64{id})
+		if len(obsolete) != 1 || obsolete[0].ID != old {
+			t.Errorf("expected %d superseded by %d, got %+v", old, id, obsolete)
+		}
+	})
+
+	t.Run("inserts without superseding another agent's thought", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		d.SetHalted(true)
-		if !d.IsHalted() {
-			t.Error("expected halted to be true")
+		other, _ := d.InsertThought("architect", "cli", "", "Not mine", "P1")
+		id, ok, err := d.InsertSupersedingThought("main", "cli", "", "Mine", "P1", other)
+		if err != nil || ok || id == 0 {
+			t.Errorf("expected insert only, got id %d superseded %v (%v)", id, ok, err)
+		}
+		if count, _ := d.GetPendingCount("architect"); count != 1 {
+			t.Error("another agent's thought should stay pending")
 		}
+	})
 
-		d.SetHalted(false)
-		if d.IsHalted() {
-			t.Error("expected halted to be false")
+	t.Run("invalid priority inserts nothing", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		old, _ := d.InsertThought("main", "cli", "", "Keep", "P1")
+		if _, _, err := d.InsertSupersedingThought("main", "cli", "", "Bad", "P9", old); err == nil {
+			t.Error("expected error for invalid priority")
+		}
+		if count, _ := d.GetPendingCount("main"); count != 1 {
+			t.Errorf("expected the old thought still pending, got %d", count)
 		}
 	})
+}
 
-	t.Run("gets and sets forced buffering", func(t *testing.T) {
+func TestRecordSynthesis(t *testing.T) {
+	t.Run("marks only the given thoughts", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		d.SetForcedBuffering(true)
-		if !d.IsForcedBuffering() {
-			t.Error("expected forced buffering to be true")
+		id1, _ := d.InsertThought("main", "cli", "", "First", "P1")
+		d.InsertThought("main", "cli", "", "Arrived later", "P1")
+
+		eventID, err := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "output"}, []int64{id1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if eventID == 0 {
+			t.Error("expected event ID")
+		}
+
+		pending, _ := d.GetPendingThoughts("main")
+		if len(pending) != 1 || pending[0].Content != "Arrived later" {
+			t.Errorf("expected later thought to stay pending, got %+v", pending)
+		}
+
+		events, _ := d.GetSynthesisEvents("main", 1)
+		if events[0].ID != eventID || events[0].ThoughtsCount != 1 {
+			t.Errorf("unexpected event: %+v", events[0])
 		}
 	})
 
-	t.Run("gets and sets simulated latency", func(t *testing.T) {
+	t.Run("records the truncated thought", func(t *testing.T) {
 		d := openTestDB(t)
 		defer d.Close()
 
-		d.SetSimulatedLatency(15000)
-		lat := d.GetSimulatedLatency()
-		if lat != 15000 {
-			t.Errorf("expected 15000, got %d", lat)
+		id, _ := d.InsertThought("main", "cli", "", "Long report", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "output", Truncated: id}, []int64{id})
+
+		event, _, _ := d.GetSynthesisEvent(eventID)
+		if event.Truncated != id {
+			t.Errorf("expected thought %d recorded as truncated, got %d", id, event.Truncated)
 		}
 	})
 
-	t.Run("state persists across reopen", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		dbPath := filepath.Join(tmpDir, "state.db")
+	t.Run("records context and destination", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
 
-		d1, _ := db.Open(dbPath)
-		d1.SetHalted(true)
-		d1.SetSimulatedLatency(5000)
-		d1.Close()
+		id, _ := d.InsertThought("main", "discord", "#ops", "First", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{
+			AgentID: "main",
+			Prompt:  "output",
+			Context: "alice: hi",
+			Channel: "discord",
+			Target:  "#ops",
+		}, []int64{id})
 
-		d2, _ := db.Open(dbPath)
-		defer d2.Close()
+		event, _, _ := d.GetSynthesisEvent(eventID)
+		if event.Context != "alice: hi" || event.Channel != "discord" || event.Target != "#ops" || event.Kind != db.EventSingle {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	})
 
-		if !d2.IsHalted() {
-			t.Error("halted state not persisted")
+	t.Run("ignores other agents' thoughts", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		other, _ := d.InsertThought("architect", "cli", "", "Not mine", "P1")
+		d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "output"}, []int64{other})
+
+		if count, _ := d.GetPendingCount("architect"); count != 1 {
+			t.Error("another agent's thought should stay pending")
 		}
-		if d2.GetSimulatedLatency() != 5000 {
-			t.Error("simulated latency not persisted")
+	})
+
+	t.Run("cross-agent event belongs to every contributor", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id1, _ := d.InsertThought("main", "discord", "", "Deploy done", "P1")
+		id2, _ := d.InsertThought("architect", "discord", "", "Schema merged", "P0")
+		d.InsertThought("builder", "slack", "", "Elsewhere", "P1")
+
+		thoughts, err := d.GetFilteredPendingThoughts(db.AllAgents, db.ThoughtFilter{Channel: "discord"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(thoughts) != 2 || thoughts[0].ID != id2 {
+			t.Fatalf("expected both discord thoughts, most urgent first, got %+v", thoughts)
+		}
+
+		eventID, err := d.RecordSynthesis(db.SynthesisEvent{AgentID: db.AllAgents, Prompt: "output", Channel: "discord"}, []int64{id1, id2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		event, _, _ := d.GetSynthesisEvent(eventID)
+		if event.ThoughtsCount != 2 || strings.Join(event.Agents, ",") != "architect,main" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+		for _, agent := range []string{"main", "architect"} {
+			events, _ := d.GetSynthesisEvents(agent, 5)
+			if len(events) != 1 || events[0].ID != eventID {
+				t.Errorf("expected %s to see the event, got %+v", agent, events)
+			}
+			if _, ok, _ := d.LastSynthesisAt(agent); !ok {
+				t.Errorf("expected a last synthesis time for %s", agent)
+			}
+		}
+		if events, _ := d.GetSynthesisEvents("builder", 5); len(events) != 0 {
+			t.Errorf("builder contributed nothing, got %+v", events)
+		}
+	})
+}
+
+func TestSynthesisPlan(t *testing.T) {
+	setup := func(t *testing.T) (*db.DB, [][]int64) {
+		t.Helper()
+		d := openTestDB(t)
+		var ids []int64
+		for i := 0; i < 4; i++ {
+			id, _ := d.InsertThought("main", "cli", "", "Thought", "P1")
+			ids = append(ids, id)
+		}
+		return d, [][]int64{ids[:2], ids[2:]}
+	}
+
+	t.Run("links rounds to a waiting reduce event", func(t *testing.T) {
+		d, chunks := setup(t)
+		defer d.Close()
+
+		reduceID, err := d.CreateSynthesisPlan(db.SynthesisEvent{AgentID: "main"}, chunks, []string{"map 1", "map 2"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		reduce, rounds, ok, err := d.GetOpenPlan("main")
+		if err != nil || !ok {
+			t.Fatalf("expected open plan: ok=%v err=%v", ok, err)
+		}
+		if reduce.ID != reduceID || reduce.Kind != db.EventReduce || reduce.State != db.StateWaiting || reduce.ThoughtsCount != 4 {
+			t.Errorf("unexpected reduce event: %+v", reduce)
+		}
+		if len(rounds) != 2 || rounds[0].ParentID != reduceID || rounds[0].Prompt != "map 1" || rounds[1].State != db.StatePending {
+			t.Errorf("unexpected rounds: %+v", rounds)
+		}
+	})
+
+	t.Run("claims thoughts for their round", func(t *testing.T) {
+		d, chunks := setup(t)
+		defer d.Close()
+
+		d.CreateSynthesisPlan(db.SynthesisEvent{AgentID: "main"}, chunks, []string{"map 1", "map 2"})
+
+		if count, _ := d.GetPendingCount("main"); count != 0 {
+			t.Errorf("expected no pending thoughts, got %d", count)
+		}
+	})
+
+	t.Run("steps complete in turn", func(t *testing.T) {
+		d, chunks := setup(t)
+		defer d.Close()
+
+		reduceID, _ := d.CreateSynthesisPlan(db.SynthesisEvent{AgentID: "main"}, chunks, []string{"map 1", "map 2"})
+		_, rounds, _, _ := d.GetOpenPlan("main")
+
+		if err := d.CompleteSynthesisStep(rounds[0].ID, "summary 1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := d.CompleteSynthesisStep(rounds[0].ID, "again"); err == nil {
+			t.Error("completing a done step should fail")
+		}
+		if err := d.CompleteSynthesisStep(reduceID, "too early"); err == nil {
+			t.Error("waiting reduce step should not accept output")
+		}
+
+		d.CompleteSynthesisStep(rounds[1].ID, "summary 2")
+		if err := d.OpenReduceStep(reduceID, "reduce prompt", ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := d.CompleteSynthesisStep(reduceID, "final"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, _, open, _ := d.GetOpenPlan("main"); open {
+			t.Error("plan should be closed after reduce completes")
+		}
+		e, _, _ := d.GetSynthesisEvent(reduceID)
+		if e.Output != "final" || e.Prompt != "reduce prompt" {
+			t.Errorf("unexpected reduce event: %+v", e)
+		}
+	})
+
+	t.Run("single flush records kind and links thoughts", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Thought", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "output"}, []int64{id})
+
+		e, ok, _ := d.GetSynthesisEvent(eventID)
+		if !ok || e.Kind != db.EventSingle || e.State != db.StateDone {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	})
+}
+
+func TestRequeueSynthesis(t *testing.T) {
+	t.Run("returns exactly the event's thoughts to pending", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id1, _ := d.InsertThought("main", "cli", "", "Build failed", "P0")
+		keep, _ := d.InsertThought("main", "cli", "", "Build failed!", "P0")
+		other, _ := d.InsertThought("main", "cli", "", "Deploy done", "P1")
+		d.CoalesceThoughts(keep, []int64{id1}, "P0")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p"}, []int64{keep})
+		laterID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p"}, []int64{other})
+
+		r, ok, err := d.RequeueSynthesis(eventID, 0, false)
+		if err != nil || !ok || r.Pending != 2 || r.Dead != 0 {
+			t.Fatalf("expected 2 thoughts requeued, got %+v %v %v", r, ok, err)
+		}
+		pending, _ := d.GetPendingThoughts("main")
+		if len(pending) != 2 || pending[0].Coalesced != 0 {
+			t.Errorf("expected the kept thought and its duplicate pending, got %+v", pending)
+		}
+		event, _, _ := d.GetSynthesisEvent(eventID)
+		if event.State != db.StateRolledBack {
+			t.Errorf("expected rolled back event, got %s", event.State)
+		}
+		if later, _, _ := d.GetSynthesisEvent(laterID); later.State != db.StateDone {
+			t.Errorf("later event should be untouched, got %s", later.State)
+		}
+
+		if _, ok, _ := d.RequeueSynthesis(eventID, 0, false); ok {
+			t.Error("expected a second requeue to report false")
+		}
+		if _, ok, _ := d.RequeueSynthesis(99, 0, false); ok {
+			t.Error("expected unknown event to report false")
+		}
+	})
+
+	t.Run("refuses delivered events unless forced", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Deploy done", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p"}, []int64{id})
+		if _, err := d.SetDelivery(eventID, db.DeliveryAcked); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, ok, err := d.RequeueSynthesis(eventID, 0, false); err == nil || ok {
+			t.Errorf("expected delivered event refused, got %v (%v)", ok, err)
+		}
+		if count, _ := d.GetPendingCount("main"); count != 0 {
+			t.Errorf("expected nothing requeued, got %d pending", count)
+		}
+		if r, ok, err := d.RequeueSynthesis(eventID, 0, true); err != nil || !ok || r.Pending != 1 {
+			t.Errorf("expected forced requeue, got %+v %v (%v)", r, ok, err)
+		}
+	})
+
+	t.Run("requeues failed deliveries", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Deploy done", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p"}, []int64{id})
+		d.SetDelivery(eventID, db.DeliveryFailed)
+
+		if _, ok, err := d.RequeueSynthesis(eventID, 0, false); err != nil || !ok {
+			t.Errorf("expected failed delivery requeued, got %v (%v)", ok, err)
+		}
+	})
+
+	t.Run("rolled back events do not count toward the interval", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Deploy done", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p"}, []int64{id})
+		d.RequeueSynthesis(eventID, 0, false)
+
+		if _, ok, _ := d.LastSynthesisAt("main"); ok {
+			t.Error("expected no last synthesis after requeue")
+		}
+	})
+
+	t.Run("abandons a plan through its reduce event", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		var ids []int64
+		for i := 0; i < 4; i++ {
+			id, _ := d.InsertThought("main", "cli", "", "Thought", "P1")
+			ids = append(ids, id)
+		}
+		reduceID, _ := d.CreateSynthesisPlan(db.SynthesisEvent{AgentID: "main"}, [][]int64{ids[:2], ids[2:]}, []string{"map 1", "map 2"})
+		rounds, _ := d.GetPlanRounds(reduceID)
+
+		if _, _, err := d.RequeueSynthesis(rounds[0].ID, 0, false); err == nil {
+			t.Error("expected error requeueing a map round")
+		}
+		r, ok, err := d.RequeueSynthesis(reduceID, 0, false)
+		if err != nil || !ok || r.Pending != 4 {
+			t.Fatalf("expected 4 thoughts requeued, got %+v %v %v", r, ok, err)
+		}
+		if _, _, open, _ := d.GetOpenPlan("main"); open {
+			t.Error("expected no open plan after requeue")
+		}
+		rounds, _ = d.GetPlanRounds(reduceID)
+		if rounds[1].State != db.StateRolledBack {
+			t.Errorf("expected rounds rolled back, got %s", rounds[1].State)
+		}
+	})
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	// fail flushes and requeues the agent's pending thoughts once
+	fail := func(t *testing.T, d *db.DB, maxAttempts int) db.Requeued {
+		t.Helper()
+		pending, _ := d.GetPendingThoughts("main")
+		var ids []int64
+		for _, th := range pending {
+			ids = append(ids, th.ID)
+		}
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p"}, ids)
+		r, _, err := d.RequeueSynthesis(eventID, maxAttempts, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return r
+	}
+
+	t.Run("moves a thought to dead after max attempts", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Poison", "P1")
+		if r := fail(t, d, 2); r.Pending != 1 || r.Dead != 0 {
+			t.Errorf("expected first failure requeued, got %+v", r)
+		}
+		if r := fail(t, d, 2); r.Pending != 0 || r.Dead != 1 {
+			t.Errorf("expected second failure dead, got %+v", r)
+		}
+
+		dead, _ := d.GetDeadThoughts("")
+		if len(dead) != 1 || dead[0].ID != id || dead[0].Attempts != 2 || dead[0].Status != "dead" {
+			t.Errorf("unexpected dead-letter queue: %+v", dead)
+		}
+		if count, _ := d.GetPendingCount("main"); count != 0 {
+			t.Errorf("dead thought should not be pending, got %d", count)
+		}
+		if count, _ := d.GetDeadCount("main"); count != 1 {
+			t.Errorf("expected dead count 1, got %d", count)
+		}
+		if count, _ := d.GetDeadCount("architect"); count != 0 {
+			t.Errorf("expected no dead thoughts for architect, got %d", count)
+		}
+	})
+
+	t.Run("zero max attempts never gives up", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("main", "cli", "", "Stubborn", "P1")
+		for i := 0; i < 5; i++ {
+			fail(t, d, 0)
+		}
+		if count, _ := d.GetDeadCount(""); count != 0 {
+			t.Errorf("expected no dead thoughts, got %d", count)
+		}
+	})
+
+	t.Run("retry resets attempts and purge deletes", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id1, _ := d.InsertThought("main", "cli", "", "First", "P1")
+		id2, _ := d.InsertThought("main", "cli", "", "Second", "P1")
+		fail(t, d, 1)
+
+		if n, err := d.RetryDeadThoughts([]int64{id1, 99}); err != nil || n != 1 {
+			t.Errorf("expected 1 retried, got %d (%v)", n, err)
+		}
+		pending, _ := d.GetPendingThoughts("main")
+		if len(pending) != 1 || pending[0].ID != id1 || pending[0].Attempts != 0 {
+			t.Errorf("expected retried thought pending with attempts reset, got %+v", pending)
+		}
+
+		// Purge only touches dead thoughts
+		if n, err := d.PurgeDeadThoughts(nil); err != nil || n != 1 {
+			t.Errorf("expected 1 purged, got %d (%v)", n, err)
+		}
+		if dead, _ := d.GetDeadThoughts(""); len(dead) != 0 {
+			t.Errorf("expected purged thought %d deleted, got %+v", id2, dead)
+		}
+		if count, _ := d.GetPendingCount("main"); count != 1 {
+			t.Errorf("expected pending thought kept, got %d", count)
+		}
+	})
+
+	t.Run("purge unlinks thoughts that point at the purged ones", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		old, _ := d.InsertThought("main", "cli", "", "Deploy at 5pm", "P1")
+		newer, _, _ := d.InsertSupersedingThought("main", "cli", "", "Deploy at 6pm", "P1", old)
+		fail(t, d, 1)
+
+		if n, err := d.PurgeDeadThoughts([]int64{newer}); err != nil || n != 1 {
+			t.Fatalf("expected 1 purged, got %d (%v)", n, err)
+		}
+		if obsolete, _ := d.GetSupersededBy([]int64{newer}); len(obsolete) != 0 {
+			t.Errorf("expected no thought left pointing at %d, got %+v", newer, obsolete)
+		}
+	})
+}
+
+func TestGetEventThoughts(t *testing.T) {
+	t.Run("returns the thoughts an event consumed", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id1, _ := d.InsertThought("main", "cli", "", "One", "P1")
+		dup, _ := d.InsertThought("main", "cli", "", "one", "P1")
+		d.InsertThought("main", "cli", "", "Left pending", "P1")
+		d.CoalesceThoughts(id1, []int64{dup}, "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main"}, []int64{id1})
+
+		thoughts, err := d.GetEventThoughts(eventID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(thoughts) != 2 || thoughts[0].ID != id1 || thoughts[1].CoalescedInto != id1 {
+			t.Errorf("expected the thought and its near-duplicate, got %+v", thoughts)
+		}
+	})
+
+	t.Run("collects map rounds for a reduce event", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id1, _ := d.InsertThought("main", "cli", "", "One", "P1")
+		id2, _ := d.InsertThought("main", "cli", "", "Two", "P1")
+		reduceID, _ := d.CreateSynthesisPlan(db.SynthesisEvent{AgentID: "main"}, [][]int64{{id1}, {id2}}, []string{"map 1", "map 2"})
+
+		if thoughts, _ := d.GetEventThoughts(reduceID); len(thoughts) != 2 {
+			t.Errorf("expected both rounds' thoughts, got %+v", thoughts)
+		}
+	})
+}
+
+func TestCompleteSynthesis(t *testing.T) {
+	t.Run("records output apart from the prompt", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Thought", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "the prompt"}, []int64{id})
+
+		e, err := d.CompleteSynthesis(eventID, db.Completion{
+			Output:       "the message",
+			Model:        "model-a",
+			PromptTokens: 120,
+			OutputTokens: 12,
+			Delivery:     db.DeliverySent,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e.Prompt != "the prompt" || e.Output != "the message" || e.Model != "model-a" ||
+			e.PromptTokens != 120 || e.OutputTokens != 12 || e.Delivery != db.DeliverySent || e.CompletedAt == "" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	})
+
+	t.Run("updates only what is given", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Thought", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "the prompt"}, []int64{id})
+		d.CompleteSynthesis(eventID, db.Completion{Output: "the message", Model: "model-a"})
+
+		e, err := d.CompleteSynthesis(eventID, db.Completion{Delivery: db.DeliveryFailed})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e.Output != "the message" || e.Model != "model-a" || e.Delivery != db.DeliveryFailed || e.DeliveredAt == "" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Thought", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "the prompt"}, []int64{id})
+
+		if _, err := d.CompleteSynthesis(99, db.Completion{Output: "x"}); err == nil {
+			t.Error("expected error for unknown event")
+		}
+		if _, err := d.CompleteSynthesis(eventID, db.Completion{Model: "model-a"}); err == nil {
+			t.Error("expected error without any output")
+		}
+		if _, err := d.CompleteSynthesis(eventID, db.Completion{Output: "x", Delivery: "lost"}); err == nil {
+			t.Error("expected error for unknown delivery state")
+		}
+	})
+
+	t.Run("stores the coverage score", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "Thought", "P0")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "the prompt"}, []int64{id})
+		if e, _, _ := d.GetSynthesisEvent(eventID); e.Coverage != nil {
+			t.Errorf("expected no coverage before completion, got %v", *e.Coverage)
+		}
+
+		score := 0.75
+		e, _ := d.CompleteSynthesis(eventID, db.Completion{Output: "x", Coverage: &score})
+		if e.Coverage == nil || *e.Coverage != 0.75 {
+			t.Errorf("expected coverage 0.75, got %v", e.Coverage)
+		}
+	})
+
+	t.Run("refuses map rounds and open plans", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id1, _ := d.InsertThought("main", "cli", "", "One", "P1")
+		id2, _ := d.InsertThought("main", "cli", "", "Two", "P1")
+		reduceID, _ := d.CreateSynthesisPlan(db.SynthesisEvent{AgentID: "main"}, [][]int64{{id1}, {id2}}, []string{"map 1", "map 2"})
+		rounds, _ := d.GetPlanRounds(reduceID)
+
+		if _, err := d.CompleteSynthesis(rounds[0].ID, db.Completion{Output: "x"}); err == nil {
+			t.Error("expected error for a map round")
+		}
+		if _, err := d.CompleteSynthesis(reduceID, db.Completion{Output: "x"}); err == nil || !strings.Contains(err.Error(), "flush --submit") {
+			t.Errorf("expected --submit hint for an unfinished plan, got %v", err)
+		}
+	})
+
+	t.Run("refuses rolled back events without the submit hint", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		id, _ := d.InsertThought("main", "cli", "", "One", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p"}, []int64{id})
+		d.RequeueSynthesis(eventID, 0, false)
+
+		_, err := d.CompleteSynthesis(eventID, db.Completion{Output: "x"})
+		if err == nil || !strings.Contains(err.Error(), "rolled back") || strings.Contains(err.Error(), "--submit") {
+			t.Errorf("expected rolled back error, got %v", err)
+		}
+	})
+}
+
+func TestDelivery(t *testing.T) {
+	setup := func(t *testing.T) (*db.DB, int64) {
+		t.Helper()
+		d := openTestDB(t)
+		id, _ := d.InsertThought("main", "discord", "#ops", "Thought", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p", Channel: "discord", Target: "#ops"}, []int64{id})
+		return d, eventID
+	}
+	soon := time.Now().Add(time.Minute)
+
+	t.Run("new events are undelivered", func(t *testing.T) {
+		d, eventID := setup(t)
+		defer d.Close()
+
+		events, err := d.GetUndeliveredEvents(soon)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(events) != 1 || events[0].ID != eventID {
+			t.Errorf("expected the event undelivered, got %+v", events)
+		}
+		if events, _ := d.GetUndeliveredEvents(time.Now().Add(-time.Hour)); len(events) != 0 {
+			t.Errorf("expected nothing older than an hour, got %+v", events)
+		}
+	})
+
+	t.Run("tracks each delivery state", func(t *testing.T) {
+		d, eventID := setup(t)
+		defer d.Close()
+
+		for _, tt := range []struct {
+			delivery    string
+			undelivered bool
+		}{
+			{db.DeliveryFailed, true},
+			{db.DeliverySent, false},
+			{db.DeliveryAcked, false},
+		} {
+			e, err := d.SetDelivery(eventID, tt.delivery)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if e.Delivery != tt.delivery || e.DeliveredAt == "" {
+				t.Errorf("unexpected event: %+v", e)
+			}
+			events, _ := d.GetUndeliveredEvents(soon)
+			if (len(events) == 1) != tt.undelivered {
+				t.Errorf("%s: expected undelivered=%v, got %+v", tt.delivery, tt.undelivered, events)
+			}
+		}
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		d, eventID := setup(t)
+		defer d.Close()
+
+		if _, err := d.SetDelivery(eventID, "lost"); err == nil {
+			t.Error("expected error for unknown delivery state")
+		}
+		if _, err := d.SetDelivery(99, db.DeliverySent); err == nil {
+			t.Error("expected error for unknown event")
+		}
+		d.RequeueSynthesis(eventID, 0, false)
+		if _, err := d.SetDelivery(eventID, db.DeliverySent); err == nil {
+			t.Error("expected error for a rolled back event")
+		}
+		if events, _ := d.GetUndeliveredEvents(soon); len(events) != 0 {
+			t.Errorf("rolled back events are not undelivered, got %+v", events)
+		}
+	})
+}
+
+func TestMessages(t *testing.T) {
+	setup := func(t *testing.T) (*db.DB, int64) {
+		t.Helper()
+		d := openTestDB(t)
+		id, _ := d.InsertThought("main", "sms", "", "Thought", "P1")
+		eventID, _ := d.RecordSynthesis(db.SynthesisEvent{AgentID: "main", Prompt: "p", Channel: "sms"}, []int64{id})
+		d.CompleteSynthesis(eventID, db.Completion{Output: "One. Two."})
+		return d, eventID
+	}
+
+	t.Run("stores parts in order", func(t *testing.T) {
+		d, eventID := setup(t)
+		defer d.Close()
+
+		messages, err := d.SetMessages(eventID, []string{"One.", "Two."})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(messages) != 2 || messages[0].Seq != 1 || messages[1].Content != "Two." || messages[1].EventID != eventID {
+			t.Errorf("unexpected messages: %+v", messages)
+		}
+	})
+
+	t.Run("replaces an earlier split", func(t *testing.T) {
+		d, eventID := setup(t)
+		defer d.Close()
+
+		d.SetMessages(eventID, []string{"One.", "Two."})
+		d.SetMessages(eventID, []string{"One. Two."})
+		if messages, _ := d.GetMessages(eventID); len(messages) != 1 || messages[0].Seq != 1 {
+			t.Errorf("expected one message, got %+v", messages)
+		}
+	})
+
+	t.Run("new output drops stale messages", func(t *testing.T) {
+		d, eventID := setup(t)
+		defer d.Close()
+
+		d.SetMessages(eventID, []string{"One.", "Two."})
+		d.CompleteSynthesis(eventID, db.Completion{Model: "m"})
+		if messages, _ := d.GetMessages(eventID); len(messages) != 2 {
+			t.Errorf("unchanged output should keep messages, got %+v", messages)
+		}
+		d.CompleteSynthesis(eventID, db.Completion{Output: "Three."})
+		if messages, _ := d.GetMessages(eventID); len(messages) != 0 {
+			t.Errorf("expected messages dropped, got %+v", messages)
+		}
+	})
+
+	t.Run("rejects unknown and unfinished events", func(t *testing.T) {
+		d, eventID := setup(t)
+		defer d.Close()
+
+		if _, err := d.SetMessages(99, []string{"x"}); err == nil {
+			t.Error("expected error for unknown event")
+		}
+		d.RequeueSynthesis(eventID, 0, false)
+		if _, err := d.SetMessages(eventID, []string{"x"}); err == nil {
+			t.Error("expected error for a rolled back event")
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// NETWORK METRICS TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestRecordLatency(t *testing.T) {
+	t.Run("records latency sample", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		err := d.RecordLatency(500)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("records multiple samples", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		for i := 0; i < 100; i++ {
+			d.RecordLatency(int64(i * 10))
+		}
+	})
+}
+
+func TestGetAverageLatency(t *testing.T) {
+	t.Run("returns 0 for empty", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		avg, err := d.GetAverageLatency(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if avg != 0 {
+			t.Errorf("expected 0, got %d", avg)
+		}
+	})
+
+	t.Run("calculates average", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.RecordLatency(100)
+		d.RecordLatency(200)
+		d.RecordLatency(300)
+
+		avg, err := d.GetAverageLatency(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if avg != 200 {
+			t.Errorf("expected 200, got %d", avg)
+		}
+	})
+}
+
+func TestGetMaxLatency(t *testing.T) {
+	t.Run("returns 0 for empty", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		max, err := d.GetMaxLatency(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if max != 0 {
+			t.Errorf("expected 0, got %d", max)
+		}
+	})
+
+	t.Run("finds max", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.RecordLatency(100)
+		d.RecordLatency(500)
+		d.RecordLatency(200)
+
+		max, err := d.GetMaxLatency(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if max != 500 {
+			t.Errorf("expected 500, got %d", max)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// STATE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestState(t *testing.T) {
+	t.Run("gets and sets halt state", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.SetHalted(true)
+		if !d.IsHalted() {
+			t.Error("expected halted to be true")
+		}
+
+		d.SetHalted(false)
+		if d.IsHalted() {
+			t.Error("expected halted to be false")
+		}
+	})
+
+	t.Run("gets and sets forced buffering", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.SetForcedBuffering(true)
+		if !d.IsForcedBuffering() {
+			t.Error("expected forced buffering to be true")
+		}
+	})
+
+	t.Run("gets and sets simulated latency", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.SetSimulatedLatency(15000)
+		lat := d.GetSimulatedLatency()
+		if lat != 15000 {
+			t.Errorf("expected 15000, got %d", lat)
+		}
+	})
+
+	t.Run("state persists across reopen", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "state.db")
+
+		d1, _ := db.Open(dbPath)
+		d1.SetHalted(true)
+		d1.SetSimulatedLatency(5000)
+		d1.Close()
+
+		d2, _ := db.Open(dbPath)
+		defer d2.Close()
+
+		if !d2.IsHalted() {
+			t.Error("halted state not persisted")
+		}
+		if d2.GetSimulatedLatency() != 5000 {
+			t.Error("simulated latency not persisted")
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// EXPRESS LANE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestExpressSends(t *testing.T) {
+	t.Run("counts sends in window", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.RecordExpressSend("main", "P0")
+		d.RecordExpressSend("architect", "P0")
+
+		count, err := d.CountExpressSends(time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != 2 {
+			t.Errorf("expected 2, got %d", count)
+		}
+	})
+
+	t.Run("counts sends since episode start", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.RecordExpressSend("main", "P0")
+
+		count, err := d.CountExpressSendsSince("2999-01-01 00:00:00")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("expected 0 sends after future timestamp, got %d", count)
+		}
+	})
+
+	t.Run("claims stop at the limit", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		for i, want := range []bool{true, true, false} {
+			n, claimed, err := d.ClaimExpressSend("main", "P0", time.Minute, 2)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claimed != want || n != i {
+				t.Errorf("claim %d: expected claimed=%v after %d sends, got %v after %d", i+1, want, i, claimed, n)
+			}
+		}
+	})
+
+	t.Run("concurrent claims do not overrun the limit", func(t *testing.T) {
+		d, err := db.Open(filepath.Join(t.TempDir(), "express.db"))
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		defer d.Close()
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		claims := 0
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, claimed, err := d.ClaimExpressSend("main", "P0", time.Minute, 3)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if claimed {
+					mu.Lock()
+					claims++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if count, _ := d.CountExpressSends(time.Minute); claims != 3 || count != 3 {
+			t.Errorf("expected 3 claims and sends, got %d claims and %d sends", claims, count)
+		}
+	})
+}
+
+func TestTrackCongestion(t *testing.T) {
+	t.Run("no episode on fresh db", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		e, err := d.GetCongestionEpisode()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e.Active() || e.StartedAt != "" {
+			t.Error("expected no episode")
+		}
+	})
+
+	t.Run("opens episode when buffering starts", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.TrackCongestion(true, "latency 8000ms > 5000ms")
+
+		e, _ := d.GetCongestionEpisode()
+		if !e.Active() {
+			t.Error("expected active episode")
+		}
+		if e.Reason != "latency 8000ms > 5000ms" {
+			t.Errorf("unexpected reason: %s", e.Reason)
+		}
+	})
+
+	t.Run("keeps original start while buffering continues", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.TrackCongestion(true, "first")
+		first, _ := d.GetCongestionEpisode()
+		d.TrackCongestion(true, "second")
+		second, _ := d.GetCongestionEpisode()
+
+		if first.StartedAt != second.StartedAt || second.Reason != "first" {
+			t.Error("ongoing episode should not be restarted")
+		}
+	})
+
+	t.Run("closes episode when healthy", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.TrackCongestion(true, "manual override")
+		d.TrackCongestion(false, "healthy")
+
+		e, _ := d.GetCongestionEpisode()
+		if e.Active() {
+			t.Error("expected episode to be closed")
+		}
+		if e.EndedAt == "" {
+			t.Error("expected end time to be recorded")
+		}
+	})
+}
+
+func TestCongestionEpisodeDuration(t *testing.T) {
+	now := time.Date(2026, 2, 7, 12, 10, 0, 0, time.UTC)
+
+	t.Run("closed episode uses end time", func(t *testing.T) {
+		e := db.CongestionEpisode{StartedAt: "2026-02-07 12:00:00", EndedAt: "2026-02-07 12:05:00"}
+		if got := e.Duration(now); got != 5*time.Minute {
+			t.Errorf("expected 5m, got %s", got)
+		}
+	})
+
+	t.Run("active episode runs to now", func(t *testing.T) {
+		e := db.CongestionEpisode{StartedAt: "2026-02-07 12:00:00"}
+		if got := e.Duration(now); got != 10*time.Minute {
+			t.Errorf("expected 10m, got %s", got)
+		}
+	})
+
+	t.Run("zero without an episode", func(t *testing.T) {
+		if got := (db.CongestionEpisode{}).Duration(now); got != 0 {
+			t.Errorf("expected 0, got %s", got)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// DEBOUNCE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestDebounceWindows(t *testing.T) {
+	t.Run("no window by default", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		_, open, err := d.GetDebounceWindow("main")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if open {
+			t.Error("expected no open window")
+		}
+	})
+
+	t.Run("opens and closes window", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		until := time.Now().Add(5 * time.Second)
+		d.OpenDebounceWindow("main", until)
+
+		got, open, _ := d.GetDebounceWindow("main")
+		if !open || got.Unix() != until.Unix() {
+			t.Errorf("expected window until %v, got %v (open=%v)", until, got, open)
+		}
+
+		d.CloseDebounceWindow("main")
+		if _, open, _ := d.GetDebounceWindow("main"); open {
+			t.Error("expected window closed")
+		}
+	})
+
+	t.Run("lists only expired windows", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		now := time.Now()
+		d.OpenDebounceWindow("done", now.Add(-time.Second))
+		d.OpenDebounceWindow("waiting", now.Add(time.Minute))
+
+		agents, err := d.ExpiredDebounceWindows(now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(agents) != 1 || agents[0] != "done" {
+			t.Errorf("expected [done], got %v", agents)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// COOLDOWN TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestCooldown(t *testing.T) {
+	t.Run("sets and gets cooldown", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		until := time.Now().Add(time.Minute)
+		d.SetCooldown("main", until)
+
+		got, ok, err := d.GetCooldown("main")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok || got.Unix() != until.Unix() {
+			t.Errorf("expected cooldown until %v, got %v", until, got)
+		}
+	})
+
+	t.Run("lists only active cooldowns", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		now := time.Now()
+		d.SetCooldown("main", now.Add(time.Minute))
+		d.SetCooldown("architect", now.Add(-time.Minute))
+
+		active, err := d.ActiveCooldowns(now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(active) != 1 || active[0].AgentID != "main" {
+			t.Errorf("expected only main, got %v", active)
+		}
+	})
+}
+
+func TestLastSynthesisAt(t *testing.T) {
+	t.Run("none before first synthesis", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		if _, ok, _ := d.LastSynthesisAt("main"); ok {
+			t.Error("expected no synthesis time")
+		}
+	})
+
+	t.Run("records synthesis time", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.InsertThought("main", "slack", "#ops", "One", "P1")
+		d.MarkSynthesized("main", "output")
+
+		last, ok, err := d.LastSynthesisAt("main")
+		if err != nil || !ok {
+			t.Fatalf("expected synthesis time, got ok=%v err=%v", ok, err)
+		}
+		if time.Since(last) > time.Minute {
+			t.Errorf("synthesis time too old: %v", last)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// LEASE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestLeases(t *testing.T) {
+	now := time.Now()
+
+	t.Run("acquires up to the per-agent limit", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		for i := 0; i < 2; i++ {
+			if _, ok, err := d.AcquireLease("main", "llm", 2, 0, time.Minute, now); err != nil || !ok {
+				t.Fatalf("acquire %d: ok=%v err=%v", i, ok, err)
+			}
+		}
+		if _, ok, _ := d.AcquireLease("main", "llm", 2, 0, time.Minute, now); ok {
+			t.Error("expected third acquire to be busy")
+		}
+		if _, ok, _ := d.AcquireLease("other", "llm", 2, 0, time.Minute, now); !ok {
+			t.Error("another agent should not be limited by main's leases")
+		}
+	})
+
+	t.Run("enforces the global limit across agents", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.AcquireLease("a", "llm", 0, 2, time.Minute, now)
+		d.AcquireLease("b", "llm", 0, 2, time.Minute, now)
+		if _, ok, _ := d.AcquireLease("c", "llm", 0, 2, time.Minute, now); ok {
+			t.Error("expected global limit to block")
+		}
+		if _, ok, _ := d.AcquireLease("c", "search", 0, 2, time.Minute, now); !ok {
+			t.Error("limits should be per resource")
+		}
+	})
+
+	t.Run("release frees the slot", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		l, _, _ := d.AcquireLease("main", "llm", 1, 0, time.Minute, now)
+		released, err := d.ReleaseLease(l.ID, now)
+		if err != nil || !released {
+			t.Fatalf("release: released=%v err=%v", released, err)
+		}
+		if _, ok, _ := d.AcquireLease("main", "llm", 1, 0, time.Minute, now); !ok {
+			t.Error("expected slot to be free after release")
+		}
+		if released, _ := d.ReleaseLease(l.ID, now); released {
+			t.Error("second release should report nothing released")
+		}
+	})
+
+	t.Run("release of an expired lease reports nothing released", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		l, _, _ := d.AcquireLease("main", "llm", 1, 0, time.Minute, now)
+		if released, err := d.ReleaseLease(l.ID, now.Add(2*time.Minute)); err != nil || released {
+			t.Errorf("expected expired lease not released, got released=%v err=%v", released, err)
+		}
+	})
+
+	t.Run("rejects sub-second ttl", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		if _, _, err := d.AcquireLease("main", "llm", 1, 0, 500*time.Millisecond, now); err == nil {
+			t.Error("expected error for sub-second ttl")
+		}
+	})
+
+	t.Run("expired leases are reaped", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.AcquireLease("main", "llm", 1, 0, time.Minute, now)
+		if _, ok, _ := d.AcquireLease("main", "llm", 1, 0, time.Minute, now.Add(2*time.Minute)); !ok {
+			t.Error("expected expired lease to free its slot")
+		}
+	})
+
+	t.Run("counts live leases", func(t *testing.T) {
+		d := openTestDB(t)
+		defer d.Close()
+
+		d.AcquireLease("main", "llm", 0, 0, time.Minute, now)
+		d.AcquireLease("main", "llm", 0, 0, time.Minute, now)
+		d.AcquireLease("other", "llm", 0, 0, time.Second, now)
+
+		counts, err := d.LeaseCounts(now.Add(10 * time.Second))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(counts) != 1 || counts[0].AgentID != "main" || counts[0].Count != 2 {
+			t.Errorf("unexpected counts: %+v", counts)
 		}
 	})
 }
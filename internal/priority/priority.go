@@ -0,0 +1,157 @@
+package priority
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Class describes one priority level
+type Class struct {
+	Name     string `json:"name"`
+	Rank     int    `json:"rank"`     // lower ranks are more urgent
+	Tag      string `json:"tag"`      // shown next to the thought in synthesis prompts
+	Express  bool   `json:"express"`  // may bypass buffering via the express lane
+	Critical bool   `json:"critical"` // counted in the prompt's "preserve" note
+}
+
+// Registry is an ordered set of priority classes
+type Registry struct {
+	classes []Class
+	byName  map[string]Class
+	def     string
+}
+
+// New builds a registry from classes; defaultName is used when no priority is given
+func New(classes []Class, defaultName string) (*Registry, error) {
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("at least one priority class is required")
+	}
+
+	r := &Registry{
+		classes: make([]Class, len(classes)),
+		byName:  make(map[string]Class, len(classes)),
+		def:     defaultName,
+	}
+	copy(r.classes, classes)
+	sort.SliceStable(r.classes, func(i, j int) bool {
+		return r.classes[i].Rank < r.classes[j].Rank
+	})
+
+	ranks := make(map[int]string, len(classes))
+	for _, c := range r.classes {
+		if strings.TrimSpace(c.Name) == "" {
+			return nil, fmt.Errorf("priority class name cannot be empty")
+		}
+		if _, dup := r.byName[c.Name]; dup {
+			return nil, fmt.Errorf("duplicate priority class: %s", c.Name)
+		}
+		if other, dup := ranks[c.Rank]; dup {
+			return nil, fmt.Errorf("priority classes %s and %s share rank %d", other, c.Name, c.Rank)
+		}
+		r.byName[c.Name] = c
+		ranks[c.Rank] = c.Name
+	}
+
+	if _, ok := r.byName[defaultName]; !ok {
+		return nil, fmt.Errorf("default priority %q is not a defined class", defaultName)
+	}
+	return r, nil
+}
+
+// Default returns the built-in P0/P1/P2 registry
+func Default() *Registry {
+	r, _ := New([]Class{
+		{Name: "P0", Rank: 0, Tag: "[CRITICAL]", Express: true, Critical: true},
+		{Name: "P1", Rank: 1},
+		{Name: "P2", Rank: 2, Tag: "[low]"},
+	}, "P1")
+	return r
+}
+
+// Lookup returns the class with the given name
+func (r *Registry) Lookup(name string) (Class, bool) {
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+// Classes returns all classes ordered by rank
+func (r *Registry) Classes() []Class {
+	out := make([]Class, len(r.classes))
+	copy(out, r.classes)
+	return out
+}
+
+// Names returns class names ordered by rank
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.classes))
+	for i, c := range r.classes {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// DefaultName returns the class used when no priority is given
+func (r *Registry) DefaultName() string {
+	return r.def
+}
+
+// Rank returns the rank of name; unknown names rank with the default class
+func (r *Registry) Rank(name string) int {
+	if c, ok := r.byName[name]; ok {
+		return c.Rank
+	}
+	return r.byName[r.def].Rank
+}
+
+// Validate normalizes an empty priority to the default and rejects unknown names
+func (r *Registry) Validate(name string) (string, error) {
+	if name == "" {
+		return r.def, nil
+	}
+	if _, ok := r.byName[name]; !ok {
+		return "", fmt.Errorf("invalid priority: %s (must be one of %s)", name, strings.Join(r.Names(), ", "))
+	}
+	return name, nil
+}
+
+// Promote moves name up by steps classes, never past ceiling.
+// Classes already at or above the ceiling are returned unchanged.
+func (r *Registry) Promote(name string, steps int, ceiling string) string {
+	idx := r.index(name)
+	top := r.index(ceiling)
+	if idx < 0 || top < 0 || idx <= top || steps <= 0 {
+		return name
+	}
+	idx -= steps
+	if idx < top {
+		idx = top
+	}
+	return r.classes[idx].Name
+}
+
+func (r *Registry) index(name string) int {
+	for i, c := range r.classes {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+var current atomic.Pointer[Registry]
+
+func init() {
+	current.Store(Default())
+}
+
+// Current returns the process-wide registry consulted by validation, ordering and prompts
+func Current() *Registry {
+	return current.Load()
+}
+
+// Use replaces the process-wide registry
+func Use(r *Registry) {
+	current.Store(r)
+}
@@ -0,0 +1,149 @@
+package priority_test
+
+import (
+	"testing"
+
+	"github.com/rickhallett/antibeaver/internal/priority"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// REGISTRY TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestNew(t *testing.T) {
+	t.Run("orders classes by rank", func(t *testing.T) {
+		r, err := priority.New([]priority.Class{
+			{Name: "telemetry", Rank: 9},
+			{Name: "security", Rank: 0},
+			{Name: "user-facing", Rank: 3},
+		}, "user-facing")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names := r.Names()
+		if names[0] != "security" || names[2] != "telemetry" {
+			t.Errorf("unexpected order: %v", names)
+		}
+	})
+
+	t.Run("rejects empty registry", func(t *testing.T) {
+		if _, err := priority.New(nil, "P1"); err == nil {
+			t.Error("expected error for no classes")
+		}
+	})
+
+	t.Run("rejects duplicate names", func(t *testing.T) {
+		_, err := priority.New([]priority.Class{{Name: "a", Rank: 0}, {Name: "a", Rank: 1}}, "a")
+		if err == nil {
+			t.Error("expected error for duplicate name")
+		}
+	})
+
+	t.Run("rejects shared rank", func(t *testing.T) {
+		_, err := priority.New([]priority.Class{{Name: "a", Rank: 0}, {Name: "b", Rank: 0}}, "a")
+		if err == nil {
+			t.Error("expected error for shared rank")
+		}
+	})
+
+	t.Run("rejects unknown default", func(t *testing.T) {
+		_, err := priority.New([]priority.Class{{Name: "a", Rank: 0}}, "b")
+		if err == nil {
+			t.Error("expected error for unknown default")
+		}
+	})
+}
+
+func TestDefault(t *testing.T) {
+	r := priority.Default()
+
+	t.Run("has P0 P1 P2", func(t *testing.T) {
+		for _, name := range []string{"P0", "P1", "P2"} {
+			if _, ok := r.Lookup(name); !ok {
+				t.Errorf("missing %s", name)
+			}
+		}
+	})
+
+	t.Run("P0 is critical and express", func(t *testing.T) {
+		c, _ := r.Lookup("P0")
+		if !c.Critical || !c.Express || c.Tag != "[CRITICAL]" {
+			t.Errorf("unexpected P0 class: %+v", c)
+		}
+	})
+
+	t.Run("P2 is tagged low", func(t *testing.T) {
+		c, _ := r.Lookup("P2")
+		if c.Tag != "[low]" || c.Express {
+			t.Errorf("unexpected P2 class: %+v", c)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	r := priority.Default()
+
+	t.Run("defaults empty", func(t *testing.T) {
+		p, err := r.Validate("")
+		if err != nil || p != "P1" {
+			t.Errorf("expected P1, got %q (%v)", p, err)
+		}
+	})
+
+	t.Run("rejects unknown", func(t *testing.T) {
+		if _, err := r.Validate("P9"); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestRank(t *testing.T) {
+	r := priority.Default()
+
+	if r.Rank("P0") >= r.Rank("P2") {
+		t.Error("P0 should outrank P2")
+	}
+	if r.Rank("unknown") != r.Rank("P1") {
+		t.Error("unknown should rank with default")
+	}
+}
+
+func TestPromote(t *testing.T) {
+	r := priority.Default()
+
+	t.Run("promotes by steps", func(t *testing.T) {
+		if got := r.Promote("P2", 1, "P0"); got != "P1" {
+			t.Errorf("expected P1, got %s", got)
+		}
+	})
+
+	t.Run("stops at ceiling", func(t *testing.T) {
+		if got := r.Promote("P2", 5, "P1"); got != "P1" {
+			t.Errorf("expected P1, got %s", got)
+		}
+	})
+
+	t.Run("leaves classes above ceiling alone", func(t *testing.T) {
+		if got := r.Promote("P0", 3, "P1"); got != "P0" {
+			t.Errorf("expected P0, got %s", got)
+		}
+	})
+
+	t.Run("zero steps is a no-op", func(t *testing.T) {
+		if got := r.Promote("P2", 0, "P0"); got != "P2" {
+			t.Errorf("expected P2, got %s", got)
+		}
+	})
+}
+
+func TestUse(t *testing.T) {
+	orig := priority.Current()
+	defer priority.Use(orig)
+
+	r, _ := priority.New([]priority.Class{{Name: "only", Rank: 0}}, "only")
+	priority.Use(r)
+
+	if priority.Current().DefaultName() != "only" {
+		t.Error("expected custom registry to be current")
+	}
+}
@@ -0,0 +1,75 @@
+package recovery
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Policy controls how agents are admitted to flush after the network recovers
+type Policy struct {
+	MaxConcurrent int           // upper bound on agents flushed in one wave
+	InitialWave   int           // slow-start size of the first wave; doubles each wave
+	Spacing       time.Duration // base gap between waves
+	Jitter        time.Duration // random extra delay added to each gap
+}
+
+// DefaultPolicy returns a conservative ramp: 1, 2, 4 agents per wave, 5s apart
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxConcurrent: 4,
+		InitialWave:   1,
+		Spacing:       5 * time.Second,
+		Jitter:        2 * time.Second,
+	}
+}
+
+// Wave is a group of agents flushed together after Delay
+type Wave struct {
+	Agents []string
+	Delay  time.Duration // wait before this wave, relative to the previous one
+}
+
+// Plan splits agents, already ordered by urgency, into waves.
+// The first wave starts immediately; later waves wait Spacing plus up to Jitter.
+func Plan(agents []string, p Policy, rng *rand.Rand) []Wave {
+	if len(agents) == 0 {
+		return nil
+	}
+
+	max := p.MaxConcurrent
+	if max < 1 {
+		max = 1
+	}
+	size := p.InitialWave
+	if size < 1 {
+		size = 1
+	}
+
+	var waves []Wave
+	for start := 0; start < len(agents); {
+		if size > max {
+			size = max
+		}
+		end := start + size
+		if end > len(agents) {
+			end = len(agents)
+		}
+
+		var delay time.Duration
+		if len(waves) > 0 {
+			delay = p.Spacing + jitter(p.Jitter, rng)
+		}
+		waves = append(waves, Wave{Agents: agents[start:end], Delay: delay})
+
+		start = end
+		size *= 2
+	}
+	return waves
+}
+
+func jitter(max time.Duration, rng *rand.Rand) time.Duration {
+	if max <= 0 || rng == nil {
+		return 0
+	}
+	return time.Duration(rng.Int63n(int64(max)))
+}
@@ -0,0 +1,98 @@
+package recovery_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/recovery"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// PLAN TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestPlan(t *testing.T) {
+	agents := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	t.Run("empty for no agents", func(t *testing.T) {
+		if waves := recovery.Plan(nil, recovery.DefaultPolicy(), nil); len(waves) != 0 {
+			t.Errorf("expected no waves, got %d", len(waves))
+		}
+	})
+
+	t.Run("slow start doubles up to max", func(t *testing.T) {
+		p := recovery.Policy{MaxConcurrent: 3, InitialWave: 1}
+		waves := recovery.Plan(agents, p, nil)
+
+		sizes := []int{}
+		for _, w := range waves {
+			sizes = append(sizes, len(w.Agents))
+		}
+		want := []int{1, 2, 3, 2}
+		if len(sizes) != len(want) {
+			t.Fatalf("expected sizes %v, got %v", want, sizes)
+		}
+		for i := range want {
+			if sizes[i] != want[i] {
+				t.Fatalf("expected sizes %v, got %v", want, sizes)
+			}
+		}
+	})
+
+	t.Run("one at a time", func(t *testing.T) {
+		p := recovery.Policy{MaxConcurrent: 1, InitialWave: 1}
+		waves := recovery.Plan(agents, p, nil)
+
+		if len(waves) != len(agents) {
+			t.Errorf("expected %d waves, got %d", len(agents), len(waves))
+		}
+	})
+
+	t.Run("preserves urgency order", func(t *testing.T) {
+		waves := recovery.Plan(agents, recovery.DefaultPolicy(), nil)
+
+		if waves[0].Agents[0] != "a" || waves[1].Agents[0] != "b" {
+			t.Error("agents should be admitted in the given order")
+		}
+	})
+
+	t.Run("first wave starts immediately", func(t *testing.T) {
+		p := recovery.Policy{MaxConcurrent: 1, Spacing: time.Second, Jitter: time.Second}
+		waves := recovery.Plan(agents, p, rand.New(rand.NewSource(1)))
+
+		if waves[0].Delay != 0 {
+			t.Errorf("expected no delay before first wave, got %s", waves[0].Delay)
+		}
+	})
+
+	t.Run("later waves wait spacing plus bounded jitter", func(t *testing.T) {
+		p := recovery.Policy{MaxConcurrent: 1, Spacing: time.Second, Jitter: 500 * time.Millisecond}
+		waves := recovery.Plan(agents, p, rand.New(rand.NewSource(1)))
+
+		jittered := false
+		for _, w := range waves[1:] {
+			if w.Delay < time.Second || w.Delay >= 1500*time.Millisecond {
+				t.Errorf("delay %s outside [1s, 1.5s)", w.Delay)
+			}
+			if w.Delay != time.Second {
+				jittered = true
+			}
+		}
+		if !jittered {
+			t.Error("expected some jitter")
+		}
+	})
+
+	t.Run("zero policy still makes progress", func(t *testing.T) {
+		waves := recovery.Plan(agents, recovery.Policy{}, nil)
+
+		total := 0
+		for _, w := range waves {
+			total += len(w.Agents)
+		}
+		if total != len(agents) {
+			t.Errorf("expected all %d agents planned, got %d", len(agents), total)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package synthesis
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/rickhallett/antibeaver/internal/tokens"
+)
+
+const (
+	// thoughtOverheadTokens covers the index, timestamp, tag and delimiters around each thought
+	thoughtOverheadTokens = 24
+	// minTruncateTokens is the smallest useful slice of a thought; below it the thought is omitted
+	minTruncateTokens = 24
+	truncationMarker  = " […truncated]"
+	// contextTrimMarker replaces the start of a transcript cut to fit its budget
+	contextTrimMarker = "[…earlier messages trimmed]\n"
+)
+
+// FitBudget keeps thoughts, most urgent first, while the rendered prompt stays
+// within maxTokens. The first thought that does not fit is truncated with a
+// marker if enough budget remains; the rest are counted in Omitted.
+// A maxTokens of zero or less disables the budget.
+func FitBudget(tmpl *template.Template, data PromptData, maxTokens int) PromptData {
+	if maxTokens <= 0 || len(data.Thoughts) == 0 {
+		return data
+	}
+	all := data.Thoughts
+
+	// Price the fixed text with a worst-case omission note
+	probe := data
+	probe.Thoughts = nil
+	probe.Omitted = countByPriority(all)
+	probe.OmittedCount = len(all)
+	base, _ := RenderPrompt(tmpl, probe)
+	used := tokens.Estimate(base)
+
+	var kept []PromptThought
+	next := 0
+	for ; next < len(all); next++ {
+		t := all[next]
+		cost := tokens.Estimate(t.Content) + thoughtOverheadTokens
+		if used+cost <= maxTokens {
+			kept = append(kept, t)
+			used += cost
+			continue
+		}
+		remaining := maxTokens - used - thoughtOverheadTokens - tokens.Estimate(truncationMarker)
+		if remaining >= minTruncateTokens {
+			t.Content = tokens.Truncate(t.Content, remaining) + truncationMarker
+			t.Quoted = escapeContent(t.Content)
+			t.Truncated = true
+			kept = append(kept, t)
+			next++
+		}
+		break
+	}
+
+	for i := range kept {
+		kept[i].Index = i + 1
+	}
+	data.Thoughts = kept
+	data.Omitted = countByPriority(all[next:])
+	data.OmittedCount = len(all) - next
+	return data
+}
+
+// countByPriority tallies thoughts per effective priority in the order first seen
+func countByPriority(thoughts []PromptThought) []PriorityCount {
+	var counts []PriorityCount
+	index := map[string]int{}
+	for _, t := range thoughts {
+		i, ok := index[t.Priority]
+		if !ok {
+			i = len(counts)
+			index[t.Priority] = i
+			counts = append(counts, PriorityCount{Priority: t.Priority})
+		}
+		counts[i].Count++
+	}
+	return counts
+}
+
+// TrimContext prepares a channel transcript for a prompt. It passes it through
+// Sanitize and keeps the most recent maxTokens, marking the cut.
+// A maxTokens of zero or less keeps the whole transcript.
+func TrimContext(transcript string, maxTokens int) string {
+	transcript = strings.TrimSpace(Sanitize(transcript))
+	if maxTokens <= 0 || tokens.Estimate(transcript) <= maxTokens {
+		return transcript
+	}
+	budget := maxTokens - tokens.Estimate(contextTrimMarker)
+	if budget <= 0 {
+		return ""
+	}
+	return contextTrimMarker + tokens.Tail(transcript, budget)
+}
@@ -0,0 +1,128 @@
+package synthesis
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/priority"
+)
+
+// DefaultCoalesceThreshold is the word-set Jaccard similarity at which two
+// thoughts count as near-duplicates
+const DefaultCoalesceThreshold = 0.8
+
+// Cluster is a set of near-duplicate thoughts collapsed into one
+type Cluster struct {
+	// Keep is the newest member, carrying the most urgent member's priority
+	Keep db.Thought
+	// Collapsed are the other members, oldest first
+	Collapsed []db.Thought
+}
+
+// IDs returns the IDs of the collapsed members
+func (c Cluster) IDs() []int64 {
+	ids := make([]int64, len(c.Collapsed))
+	for i, t := range c.Collapsed {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+// Coalesce clusters near-duplicate thoughts: two thoughts are similar when the
+// Jaccard similarity of their normalized word sets is at least threshold, and
+// similarity is chained, oldest first. Each cluster keeps its newest member,
+// raised to the most urgent priority in the cluster, with Coalesced counting
+// everything folded into it. It returns the surviving thoughts in input order
+// and the clusters that collapsed anything. A threshold of zero or less, or
+// above 1, disables coalescing.
+func Coalesce(thoughts []db.Thought, threshold float64) ([]db.Thought, []Cluster) {
+	if threshold <= 0 || threshold > 1 || len(thoughts) < 2 {
+		return thoughts, nil
+	}
+
+	ordered := chronological(thoughts)
+	words := make([]map[string]bool, len(ordered))
+	for i, t := range ordered {
+		words[i] = wordSet(t.Content)
+	}
+	// cluster[i] is the index of the first member of thought i's cluster
+	cluster := make([]int, len(ordered))
+	for i := range ordered {
+		cluster[i] = i
+		for j := 0; j < i; j++ {
+			if jaccard(words[i], words[j]) >= threshold {
+				cluster[i] = cluster[j]
+				break
+			}
+		}
+	}
+
+	members := map[int][]db.Thought{}
+	var roots []int
+	for i, t := range ordered {
+		root := cluster[i]
+		if len(members[root]) == 0 {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], t)
+	}
+
+	reg := priority.Current()
+	var clusters []Cluster
+	dropped := map[int64]bool{}
+	kept := map[int64]db.Thought{}
+	for _, root := range roots {
+		m := members[root]
+		if len(m) == 1 {
+			continue
+		}
+		c := Cluster{Keep: m[len(m)-1], Collapsed: m[:len(m)-1]}
+		for _, t := range c.Collapsed {
+			if reg.Rank(t.Effective()) < reg.Rank(c.Keep.Effective()) {
+				c.Keep.EffectivePriority = t.Effective()
+			}
+			c.Keep.Coalesced += t.Coalesced + 1
+			dropped[t.ID] = true
+		}
+		kept[c.Keep.ID] = c.Keep
+		clusters = append(clusters, c)
+	}
+
+	var out []db.Thought
+	for _, t := range thoughts {
+		if dropped[t.ID] {
+			continue
+		}
+		if k, ok := kept[t.ID]; ok {
+			t = k
+		}
+		out = append(out, t)
+	}
+	return out, clusters
+}
+
+// wordSet lowercases s and splits it into a set of letter-and-digit words
+func wordSet(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		set[w] = true
+	}
+	return set
+}
+
+// jaccard returns |a ∩ b| / |a ∪ b|; two empty sets are not similar
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
@@ -0,0 +1,79 @@
+package synthesis_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// COALESCING TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestCoalesce(t *testing.T) {
+	thoughts := []db.Thought{
+		{ID: 1, Content: "Build failed on main", Priority: "P2", CreatedAt: "2026-02-07 12:00:00"},
+		{ID: 2, Content: "Deploy finished", Priority: "P1", CreatedAt: "2026-02-07 12:01:00"},
+		{ID: 3, Content: "build FAILED on main!", Priority: "P0", CreatedAt: "2026-02-07 12:02:00"},
+		{ID: 4, Content: "Build failed on main.", Priority: "P2", CreatedAt: "2026-02-07 12:03:00"},
+	}
+
+	t.Run("keeps the newest member", func(t *testing.T) {
+		kept, clusters := synthesis.Coalesce(thoughts, synthesis.DefaultCoalesceThreshold)
+		if len(kept) != 2 || kept[0].ID != 2 || kept[1].ID != 4 {
+			t.Fatalf("unexpected survivors: %+v", kept)
+		}
+		if len(clusters) != 1 || clusters[0].Keep.ID != 4 {
+			t.Fatalf("unexpected clusters: %+v", clusters)
+		}
+		if ids := clusters[0].IDs(); len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+			t.Errorf("unexpected collapsed IDs: %v", ids)
+		}
+	})
+
+	t.Run("takes the most urgent priority", func(t *testing.T) {
+		kept, _ := synthesis.Coalesce(thoughts, synthesis.DefaultCoalesceThreshold)
+		if kept[1].Effective() != "P0" {
+			t.Errorf("expected P0, got %s", kept[1].Effective())
+		}
+	})
+
+	t.Run("counts collapsed thoughts", func(t *testing.T) {
+		prior := []db.Thought{
+			{ID: 1, Content: "Disk almost full", Priority: "P1", CreatedAt: "2026-02-07 12:00:00", Coalesced: 2},
+			{ID: 2, Content: "disk almost full", Priority: "P1", CreatedAt: "2026-02-07 12:01:00"},
+		}
+		kept, _ := synthesis.Coalesce(prior, synthesis.DefaultCoalesceThreshold)
+		if len(kept) != 1 || kept[0].Coalesced != 3 {
+			t.Errorf("expected one survivor standing for 4, got %+v", kept)
+		}
+	})
+
+	t.Run("different details are not merged", func(t *testing.T) {
+		distinct := []db.Thought{
+			{ID: 1, Content: "PR 41 merged", Priority: "P1", CreatedAt: "2026-02-07 12:00:00"},
+			{ID: 2, Content: "PR 42 merged", Priority: "P1", CreatedAt: "2026-02-07 12:01:00"},
+		}
+		if kept, clusters := synthesis.Coalesce(distinct, synthesis.DefaultCoalesceThreshold); len(kept) != 2 || clusters != nil {
+			t.Errorf("expected nothing merged, got %+v", kept)
+		}
+	})
+
+	t.Run("zero threshold disables", func(t *testing.T) {
+		if kept, clusters := synthesis.Coalesce(thoughts, 0); len(kept) != 4 || clusters != nil {
+			t.Errorf("expected no coalescing, got %d survivors", len(kept))
+		}
+	})
+
+	t.Run("prompt annotates the survivor", func(t *testing.T) {
+		kept, _ := synthesis.Coalesce(thoughts, synthesis.DefaultCoalesceThreshold)
+		if prompt := synthesis.GeneratePrompt(kept); !strings.Contains(prompt, "(×3 similar)") {
+			t.Errorf("expected similarity note:\n%s", prompt)
+		}
+		if digest := synthesis.Digest(kept); !strings.Contains(digest, "(×3 similar)") {
+			t.Errorf("expected similarity note in digest:\n%s", digest)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package synthesis
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/priority"
+)
+
+// DefaultMinCoverage is the coverage score below which an output is rejected
+// when the caller asks for strict checking
+const DefaultMinCoverage = 0.8
+
+var (
+	urlPattern    = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+	numberPattern = regexp.MustCompile(`\d+(?:[.:/-]\d+)*`)
+)
+
+// stopWords are common words that carry no content worth checking for,
+// including priority markers the output has no reason to repeat
+var stopWords = map[string]bool{
+	"about": true, "after": true, "again": true, "also": true, "been": true,
+	"before": true, "being": true, "could": true, "critical": true, "does": true,
+	"from": true, "have": true, "into": true, "just": true, "more": true,
+	"most": true, "only": true, "other": true, "over": true, "should": true,
+	"since": true, "some": true, "still": true, "such": true, "than": true,
+	"that": true, "their": true, "them": true, "then": true, "there": true,
+	"they": true, "this": true, "under": true, "urgent": true, "very": true,
+	"were": true, "what": true, "when": true, "where": true, "which": true,
+	"while": true, "will": true, "with": true, "would": true, "your": true,
+}
+
+// stemRunes is how many leading runes two words must share to count as the
+// same term, so "deploy" matches "deployed" and "deployment"
+const stemRunes = 5
+
+// CoverageReport is how much of the critical thoughts' key content survived
+// into an output
+type CoverageReport struct {
+	// Score is the fraction of key items found, 1 when there were none
+	Score float64 `json:"score"`
+	// Checked is the number of critical thoughts examined
+	Checked int `json:"checked"`
+	Items   int `json:"items"`
+	Found   int `json:"found"`
+	// Missing lists, per thought, the key items the output lacks
+	Missing []MissingItems `json:"missing,omitempty"`
+}
+
+// MissingItems are the key items of one thought absent from the output
+type MissingItems struct {
+	ThoughtID int64    `json:"thought_id"`
+	Items     []string `json:"items"`
+}
+
+// CheckCoverage checks that output preserves the thoughts in critical classes
+// (P0 by default). Each thought's key items are its URLs and numbers, which
+// must appear verbatim, and its content words, which match on a shared stem.
+// Matching ignores case. Thoughts in other classes are ignored.
+func CheckCoverage(thoughts []db.Thought, output string) CoverageReport {
+	reg := priority.Current()
+	lower := strings.ToLower(output)
+	numbers := map[string]bool{}
+	for _, n := range numberPattern.FindAllString(urlPattern.ReplaceAllString(output, " "), -1) {
+		numbers[n] = true
+	}
+	stems := map[string]bool{}
+	for w := range wordSet(output) {
+		stems[stem(w)] = true
+	}
+
+	var r CoverageReport
+	for _, t := range thoughts {
+		if class, ok := reg.Lookup(t.Effective()); !ok || !class.Critical {
+			continue
+		}
+		r.Checked++
+		urls, nums, terms := keyItems(t.Content)
+		var missing []string
+		for _, u := range urls {
+			if !strings.Contains(lower, strings.ToLower(u)) {
+				missing = append(missing, u)
+			}
+		}
+		for _, n := range nums {
+			if !numbers[n] {
+				missing = append(missing, n)
+			}
+		}
+		for _, w := range terms {
+			if !stems[stem(w)] {
+				missing = append(missing, w)
+			}
+		}
+		items := len(urls) + len(nums) + len(terms)
+		r.Items += items
+		r.Found += items - len(missing)
+		if len(missing) > 0 {
+			r.Missing = append(r.Missing, MissingItems{ThoughtID: t.ID, Items: missing})
+		}
+	}
+	r.Score = 1
+	if r.Items > 0 {
+		r.Score = float64(r.Found) / float64(r.Items)
+	}
+	return r
+}
+
+// keyItems extracts the URLs, numbers and content words of s, each in order
+// of first appearance without repeats
+func keyItems(s string) (urls, numbers, terms []string) {
+	seen := map[string]bool{}
+	add := func(list []string, item string) []string {
+		if seen[item] {
+			return list
+		}
+		seen[item] = true
+		return append(list, item)
+	}
+	for _, u := range urlPattern.FindAllString(s, -1) {
+		urls = add(urls, strings.TrimRight(u, ".,;:!?"))
+	}
+	rest := urlPattern.ReplaceAllString(s, " ")
+	for _, n := range numberPattern.FindAllString(rest, -1) {
+		numbers = add(numbers, n)
+	}
+	for _, w := range strings.FieldsFunc(strings.ToLower(rest), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if len([]rune(w)) >= 4 && !stopWords[w] {
+			terms = add(terms, w)
+		}
+	}
+	return urls, numbers, terms
+}
+
+// stem cuts w to its first stemRunes runes
+func stem(w string) string {
+	if r := []rune(w); len(r) > stemRunes {
+		return string(r[:stemRunes])
+	}
+	return w
+}
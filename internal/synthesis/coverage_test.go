@@ -0,0 +1,61 @@
+package synthesis_test
+
+import (
+	"testing"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// COVERAGE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestCheckCoverage(t *testing.T) {
+	thoughts := []db.Thought{
+		{ID: 1, Content: "CRITICAL: Payments API down since 14:05, see https://status.example.com/incidents/42.", Priority: "P0"},
+		{ID: 2, Content: "Lunch menu posted", Priority: "P1"},
+	}
+
+	t.Run("full coverage", func(t *testing.T) {
+		r := synthesis.CheckCoverage(thoughts, "The payments API has been down since 14:05 (https://status.example.com/incidents/42).")
+		if r.Score != 1 || r.Checked != 1 || r.Missing != nil {
+			t.Errorf("expected full coverage, got %+v", r)
+		}
+	})
+
+	t.Run("reports missing items", func(t *testing.T) {
+		r := synthesis.CheckCoverage(thoughts, "Payments are down, we are looking into it.")
+		if r.Score >= 1 || len(r.Missing) != 1 || r.Missing[0].ThoughtID != 1 {
+			t.Fatalf("expected partial coverage of thought 1, got %+v", r)
+		}
+		missing := map[string]bool{}
+		for _, item := range r.Missing[0].Items {
+			missing[item] = true
+		}
+		if !missing["14:05"] || !missing["https://status.example.com/incidents/42"] || missing["payments"] || missing["down"] {
+			t.Errorf("unexpected missing items: %v", r.Missing[0].Items)
+		}
+	})
+
+	t.Run("numbers must match exactly", func(t *testing.T) {
+		r := synthesis.CheckCoverage(thoughts, "Payments API down since 14:50, see https://status.example.com/incidents/42")
+		if len(r.Missing) != 1 || r.Missing[0].Items[0] != "14:05" {
+			t.Errorf("expected 14:05 missing, got %+v", r.Missing)
+		}
+	})
+
+	t.Run("ignores non-critical thoughts", func(t *testing.T) {
+		r := synthesis.CheckCoverage(thoughts[1:], "Nothing to report")
+		if r.Score != 1 || r.Checked != 0 {
+			t.Errorf("expected nothing checked, got %+v", r)
+		}
+	})
+
+	t.Run("uses effective priority", func(t *testing.T) {
+		aged := []db.Thought{{ID: 3, Content: "Rotate the signing keys", Priority: "P2", EffectivePriority: "P0"}}
+		if r := synthesis.CheckCoverage(aged, "All quiet"); r.Checked != 1 || r.Score != 0 {
+			t.Errorf("expected aged thought checked, got %+v", r)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package synthesis
+
+import (
+	"strings"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+)
+
+// Group is the buffered thoughts bound for one destination
+type Group struct {
+	Channel  string
+	Target   string
+	Thoughts []db.Thought
+}
+
+// GroupByDestination splits thoughts by channel and target, so each
+// destination gets its own synthesis. Thoughts keep their input order within
+// a group; groups are ordered by their most urgent thought, then by time.
+func GroupByDestination(thoughts []db.Thought) []Group {
+	type key struct{ channel, target string }
+	index := map[key]int{}
+	var groups []Group
+	for _, t := range sortByPriority(thoughts) {
+		k := key{t.Channel, t.Target}
+		if _, ok := index[k]; !ok {
+			index[k] = len(groups)
+			groups = append(groups, Group{Channel: t.Channel, Target: t.Target})
+		}
+	}
+	for _, t := range thoughts {
+		i := index[key{t.Channel, t.Target}]
+		groups[i].Thoughts = append(groups[i].Thoughts, t)
+	}
+	return groups
+}
+
+// destination returns the channel and target shared by every thought, if any
+func destination(thoughts []db.Thought) (channel, target string) {
+	if len(thoughts) == 0 {
+		return "", ""
+	}
+	channel, target = thoughts[0].Channel, thoughts[0].Target
+	for _, t := range thoughts[1:] {
+		if t.Channel != channel || t.Target != target {
+			return "", ""
+		}
+	}
+	return label(channel), label(target)
+}
+
+// label makes a channel or target name safe to show inline in a prompt:
+// one line, no bold markers
+func label(s string) string {
+	s = strings.ReplaceAll(strip(s), "**", "")
+	return strings.Join(strings.Fields(s), " ")
+}
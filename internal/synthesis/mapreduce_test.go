@@ -0,0 +1,86 @@
+package synthesis_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// MAP-REDUCE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestChunk(t *testing.T) {
+	thoughts := make([]db.Thought, 7)
+	for i := range thoughts {
+		thoughts[i] = db.Thought{ID: int64(i + 1), Content: strings.Repeat("x", 400), Priority: "P1"}
+	}
+
+	t.Run("splits by count", func(t *testing.T) {
+		chunks := synthesis.Chunk(thoughts, 3, 0)
+		if len(chunks) != 3 || len(chunks[0]) != 3 || len(chunks[2]) != 1 {
+			t.Errorf("unexpected chunk sizes: %d", len(chunks))
+		}
+	})
+
+	t.Run("splits by tokens", func(t *testing.T) {
+		// Each thought is ~112 tokens with overhead
+		chunks := synthesis.Chunk(thoughts, 100, 250)
+		for _, c := range chunks {
+			if len(c) > 2 {
+				t.Errorf("chunk of %d exceeds token budget", len(c))
+			}
+		}
+	})
+
+	t.Run("preserves order", func(t *testing.T) {
+		chunks := synthesis.Chunk(thoughts, 2, 0)
+		if chunks[0][0].ID != 1 || chunks[3][0].ID != 7 {
+			t.Error("chunks should keep input order")
+		}
+	})
+
+	t.Run("oversized thought gets its own chunk", func(t *testing.T) {
+		chunks := synthesis.Chunk(thoughts[:2], 10, 50)
+		if len(chunks) != 2 {
+			t.Errorf("expected 2 chunks, got %d", len(chunks))
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		if chunks := synthesis.Chunk(nil, 3, 0); len(chunks) != 0 {
+			t.Error("expected no chunks")
+		}
+	})
+}
+
+func TestMapReducePrompts(t *testing.T) {
+	t.Run("map prompt names the part", func(t *testing.T) {
+		chunk := []db.Thought{{ID: 1, Content: "Disk full", Priority: "P0", CreatedAt: "2026-02-07 12:00:00"}}
+		prompt := synthesis.MapPrompt("main", chunk, 2, 3, 120)
+
+		for _, want := range []string{"PART 2 OF 3", "drafted 120 messages", "holds 1 of them", "Disk full", "[CRITICAL]"} {
+			if !strings.Contains(prompt, want) {
+				t.Errorf("expected %q in map prompt:\n%s", want, prompt)
+			}
+		}
+	})
+
+	t.Run("reduce prompt includes every part", func(t *testing.T) {
+		data := synthesis.ReduceData{Total: 120, Parts: 2}
+		for i := 1; i <= 2; i++ {
+			data.Partials = append(data.Partials, synthesis.Partial{Part: i, Output: fmt.Sprintf("summary %d", i)})
+		}
+		prompt := synthesis.ReducePrompt(data)
+
+		if !strings.Contains(prompt, "### Part 1\n\n<part-") || !strings.Contains(prompt, ">\nsummary 2\n</part-") {
+			t.Errorf("expected both parts:\n%s", prompt)
+		}
+		if !strings.Contains(prompt, "ONE coherent message") {
+			t.Error("expected final synthesis task")
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package synthesis
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mention rules for Profile.Mentions
+const (
+	MentionsAll   = "all"   // any @mention, the default
+	MentionsUsers = "users" // people may be mentioned, but not @everyone or @here
+	MentionsNone  = "none"  // no @mentions at all
+)
+
+// ValidMentions reports whether s is a mention rule; empty means MentionsAll
+func ValidMentions(s string) bool {
+	return s == "" || s == MentionsAll || s == MentionsUsers || s == MentionsNone
+}
+
+// Profile is what a destination channel accepts. The zero Profile states no
+// limits, and the prompt says nothing about them.
+type Profile struct {
+	// MaxLength is the longest message the channel takes, in characters; zero is unlimited
+	MaxLength int
+	// PlainText reports that the channel does not render markdown
+	PlainText bool
+	// Mentions is a mention rule such as MentionsNone; empty allows all
+	Mentions string
+}
+
+var (
+	// sentenceEnd matches the gap after a sentence or line
+	sentenceEnd = regexp.MustCompile(`[.!?…]+["'”’)\]]*\s+|\n\s*`)
+	wordEnd     = regexp.MustCompile(`\s+`)
+)
+
+// Split breaks text into messages of at most maxLength characters, in order.
+// It breaks between sentences or lines where it can, between words when a
+// sentence is too long, and mid-word only when a word is. Text that already
+// fits, or a maxLength of zero, gives a single message.
+func Split(text string, maxLength int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if maxLength <= 0 || utf8.RuneCountInString(text) <= maxLength {
+		return []string{text}
+	}
+
+	var parts []string
+	current := ""
+	// fits appends piece to the current message if the result stays in bounds
+	fits := func(piece string) bool {
+		if utf8.RuneCountInString(strings.TrimSpace(current+piece)) > maxLength {
+			return false
+		}
+		current += piece
+		return true
+	}
+	flush := func() {
+		if s := strings.TrimSpace(current); s != "" {
+			parts = append(parts, s)
+		}
+		current = ""
+	}
+	for _, sentence := range splitAfter(text, sentenceEnd) {
+		if fits(sentence) {
+			continue
+		}
+		flush()
+		if fits(sentence) {
+			continue
+		}
+		for _, word := range splitAfter(sentence, wordEnd) {
+			if fits(word) {
+				continue
+			}
+			flush()
+			for !fits(word) {
+				r := []rune(strings.TrimSpace(word))
+				parts = append(parts, string(r[:maxLength]))
+				word = string(r[maxLength:])
+			}
+		}
+	}
+	flush()
+	return parts
+}
+
+// splitAfter cuts s after each match of sep, keeping the separators
+func splitAfter(s string, sep *regexp.Regexp) []string {
+	var pieces []string
+	start := 0
+	for _, m := range sep.FindAllStringIndex(s, -1) {
+		pieces = append(pieces, s[start:m[1]])
+		start = m[1]
+	}
+	if start < len(s) {
+		pieces = append(pieces, s[start:])
+	}
+	return pieces
+}
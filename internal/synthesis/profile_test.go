@@ -0,0 +1,141 @@
+package synthesis_test
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// SPLIT TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestSplit(t *testing.T) {
+	t.Run("short text is one message", func(t *testing.T) {
+		got := synthesis.Split("  Deploy done.  ", 2000)
+		if len(got) != 1 || got[0] != "Deploy done." {
+			t.Errorf("expected one trimmed message, got %q", got)
+		}
+	})
+
+	t.Run("zero limit never splits", func(t *testing.T) {
+		text := strings.Repeat("word ", 1000)
+		if got := synthesis.Split(text, 0); len(got) != 1 {
+			t.Errorf("expected one message, got %d", len(got))
+		}
+	})
+
+	t.Run("empty text has no messages", func(t *testing.T) {
+		if got := synthesis.Split(" \n ", 10); got != nil {
+			t.Errorf("expected nil, got %q", got)
+		}
+	})
+
+	t.Run("breaks at sentence boundaries", func(t *testing.T) {
+		got := synthesis.Split("Deploy finished. Checks passed! Is rollback needed? No.", 35)
+		want := []string{"Deploy finished. Checks passed!", "Is rollback needed? No."}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("breaks at lines", func(t *testing.T) {
+		got := synthesis.Split("Status:\n- api up\n- db up\n- cache warming", 20)
+		want := []string{"Status:\n- api up", "- db up", "- cache warming"}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("long sentence breaks between words", func(t *testing.T) {
+		got := synthesis.Split("the quick brown fox jumps over the lazy dog", 15)
+		want := []string{"the quick brown", "fox jumps over", "the lazy dog"}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("cuts a word longer than the limit", func(t *testing.T) {
+		got := synthesis.Split("see https://ci.example/run/42", 10)
+		want := []string{"see", "https://ci", ".example/r", "un/42"}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("counts characters, not bytes", func(t *testing.T) {
+		text := "Caída del servicio. Añadida réplica."
+		got := synthesis.Split(text, 20)
+		if len(got) != 2 || got[0] != "Caída del servicio." {
+			t.Errorf("unexpected split %q", got)
+		}
+	})
+
+	t.Run("every message fits and nothing is lost", func(t *testing.T) {
+		text := strings.Repeat("Incident resolved after failover to the standby region. ", 60)
+		got := synthesis.Split(text, 160)
+		for i, m := range got {
+			if n := utf8.RuneCountInString(m); n > 160 || n == 0 {
+				t.Errorf("message %d has %d characters", i+1, n)
+			}
+		}
+		if strings.Join(got, " ") != strings.TrimSpace(text) {
+			t.Error("messages do not rejoin into the original text")
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// PROFILE PROMPT TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestProfilePrompt(t *testing.T) {
+	thoughts := []db.Thought{
+		{ID: 1, Content: "Deploy done", Priority: "P1", CreatedAt: "2026-02-07 12:00:00"},
+	}
+
+	t.Run("states the channel limits", func(t *testing.T) {
+		data := synthesis.NewPromptData("main", thoughts)
+		data.Profile = synthesis.Profile{MaxLength: 160, PlainText: true, Mentions: synthesis.MentionsNone}
+		out, err := synthesis.RenderPrompt(nil, data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, want := range []string{"under 160 characters", "plain text", "Do not @mention anyone"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("expected %q in prompt:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("zero profile adds nothing", func(t *testing.T) {
+		out, _ := synthesis.RenderPrompt(nil, synthesis.NewPromptData("main", thoughts))
+		if strings.Contains(out, "characters") || strings.Contains(out, "@") {
+			t.Errorf("expected no channel rules:\n%s", out)
+		}
+	})
+
+	t.Run("prompt strategy passes the profile", func(t *testing.T) {
+		s, _ := synthesis.Strategy("prompt")
+		out, err := s.Synthesize(synthesis.Input{AgentID: "main", Thoughts: thoughts, Profile: synthesis.Profile{Mentions: synthesis.MentionsUsers}})
+		if err != nil || !strings.Contains(out.Text, "@everyone or @here") {
+			t.Errorf("expected mention rule in prompt, got %q (%v)", out.Text, err)
+		}
+	})
+
+	t.Run("reduce prompt states the limit", func(t *testing.T) {
+		out := synthesis.ReducePrompt(synthesis.ReduceData{
+			AgentID:  "main",
+			Total:    2,
+			Parts:    1,
+			Partials: []synthesis.Partial{{Part: 1, Output: "Deploy done"}},
+			Profile:  synthesis.Profile{MaxLength: 2000},
+		})
+		if !strings.Contains(out, "under 2000 characters") {
+			t.Errorf("expected limit in reduce prompt:\n%s", out)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package synthesis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ansiSequence matches CSI and OSC terminal escape sequences
+	ansiSequence = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)?)`)
+	// boundaryTag matches the data delimiters used by the built-in templates
+	boundaryTag = regexp.MustCompile(`(?i)<\s*/?\s*(?:thought|channel-state|part)(?:-[0-9a-z]*)?(?:\s[^>]*)?>`)
+	// markdownLine matches lines that would read as headings, rules or fences
+	markdownLine = regexp.MustCompile(`(?m)^([ \t]*)(#|>|` + "```" + `|~~~|[-=*_]{3,}[ \t]*$)`)
+)
+
+// invisible reports whether r is a zero-width or bidirectional control
+// character that could hide or reorder text
+func invisible(r rune) bool {
+	switch {
+	case r >= 0x200B && r <= 0x200F, r >= 0x202A && r <= 0x202E,
+		r >= 0x2060 && r <= 0x2064, r >= 0x2066 && r <= 0x2069, r == 0xFEFF:
+		return true
+	}
+	return false
+}
+
+// Sanitize neutralizes untrusted text before it is embedded in a prompt.
+// It removes terminal escape sequences, control and invisible characters and
+// anything shaped like a data delimiter, and escapes markdown headings, rules,
+// fences and bold markers so the text cannot pose as prompt structure.
+func Sanitize(s string) string {
+	s = markdownLine.ReplaceAllString(strip(s), `$1\$2`)
+	return strings.ReplaceAll(s, "**", `\*\*`)
+}
+
+// strip removes escape sequences, control and invisible characters and
+// delimiter lookalikes, normalizing line endings to \n
+func strip(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	s = ansiSequence.ReplaceAllString(s, "")
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || invisible(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return boundaryTag.ReplaceAllString(s, "")
+}
+
+// boundaryNonce derives the delimiter nonce from everything the delimiters
+// enclose. Text cannot contain a hash of itself, so it cannot close its own
+// section early.
+func boundaryNonce(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// withNonce fills in data.Nonce if the caller did not set one
+func (data PromptData) withNonce() PromptData {
+	if data.Nonce != "" {
+		return data
+	}
+	parts := []string{data.Context}
+	for _, t := range data.Thoughts {
+		parts = append(parts, t.Content)
+	}
+	for _, t := range data.Obsolete {
+		parts = append(parts, t.Content)
+	}
+	data.Nonce = boundaryNonce(parts...)
+	return data
+}
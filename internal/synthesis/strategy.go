@@ -0,0 +1,322 @@
+package synthesis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/priority"
+)
+
+// DefaultStrategy is the synthesizer used when none is configured
+const DefaultStrategy = "prompt"
+
+// Input is what a synthesizer works from
+type Input struct {
+	AgentID  string
+	Thoughts []db.Thought
+	// Template renders the prompt strategy; nil uses DefaultTemplate
+	Template *template.Template
+	// Trigger is why the flush happened, TriggerRecovery when empty
+	Trigger string
+	// Reason and CongestionDuration describe the buffering episode being recovered from
+	Reason             string
+	CongestionDuration time.Duration
+	// MaxTokens caps the prompt strategy's output; zero is unlimited
+	MaxTokens int
+	// Context is the recent channel transcript the prompt is reviewed against;
+	// see TrimContext
+	Context string
+	// Obsolete lists superseded thoughts the prompt strategy should name as
+	// already obsolete; other strategies ignore it
+	Obsolete []db.Thought
+	// Profile is what the destination channel accepts; the prompt strategy
+	// states its limits
+	Profile Profile
+}
+
+// Message is one entry in a chat-completion message array
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Result is a synthesizer's output
+type Result struct {
+	Text string
+	// Messages splits Text into chat messages; nil means Text is a single user message
+	Messages []Message
+	// Consumed lists the thoughts the output accounts for; nil means all of them.
+	// Thoughts left out stay pending for a later flush.
+	Consumed []int64
+	// Discarded lists thoughts left out on purpose because the output makes
+	// them obsolete. They are recorded as superseded by the first consumed
+	// thought instead of staying pending.
+	Discarded []int64
+	// Truncated is the consumed thought whose content was cut to fit the
+	// token budget, or zero
+	Truncated int64
+	// Final reports that Text is the message itself rather than a prompt for a model
+	Final bool
+}
+
+// ChatMessages returns the result as chat-completion messages
+func (r Result) ChatMessages() []Message {
+	if r.Messages != nil {
+		return r.Messages
+	}
+	return []Message{{Role: "user", Content: r.Text}}
+}
+
+// Chat API request shapes accepted by ShapeMessages
+const (
+	ShapeOpenAI    = "openai"    // a message array with a "system" role message
+	ShapeAnthropic = "anthropic" // a top-level system field beside the messages
+)
+
+// AnthropicMessages is the body shape of the Anthropic Messages API, where the
+// system instruction is not a message
+type AnthropicMessages struct {
+	System   string    `json:"system,omitempty"`
+	Messages []Message `json:"messages"`
+}
+
+// ShapeMessages arranges chat messages the way the named API expects:
+// ShapeOpenAI returns them unchanged, ShapeAnthropic returns an
+// AnthropicMessages with the system messages joined into System
+func ShapeMessages(messages []Message, shape string) (interface{}, error) {
+	switch shape {
+	case ShapeOpenAI, "":
+		if messages == nil {
+			messages = []Message{}
+		}
+		return messages, nil
+	case ShapeAnthropic:
+		body := AnthropicMessages{Messages: []Message{}}
+		var system []string
+		for _, m := range messages {
+			if m.Role == "system" {
+				system = append(system, m.Content)
+				continue
+			}
+			body.Messages = append(body.Messages, m)
+		}
+		body.System = strings.Join(system, "\n\n")
+		return body, nil
+	}
+	return nil, fmt.Errorf("unknown message shape %q (must be %s or %s)", shape, ShapeOpenAI, ShapeAnthropic)
+}
+
+// Synthesizer turns an agent's buffered thoughts into one output.
+// Unless the Result says otherwise, every input thought counts as consumed;
+// a strategy that leaves thoughts out reports Consumed, and Discarded for
+// those it drops for good.
+type Synthesizer interface {
+	Synthesize(in Input) (Result, error)
+}
+
+// SynthesizerFunc adapts a plain function to the Synthesizer interface
+type SynthesizerFunc func(in Input) (Result, error)
+
+// Synthesize calls f(in)
+func (f SynthesizerFunc) Synthesize(in Input) (Result, error) {
+	return f(in)
+}
+
+// textOnly adapts a strategy that produces plain text
+func textOnly(f func(in Input) string) Synthesizer {
+	return SynthesizerFunc(func(in Input) (Result, error) {
+		return Result{Text: f(in), Final: true}, nil
+	})
+}
+
+var strategies = map[string]Synthesizer{
+	// prompt asks an LLM to merge the thoughts into one message
+	"prompt": SynthesizerFunc(func(in Input) (Result, error) {
+		if len(in.Thoughts) == 0 {
+			return Result{Text: GeneratePrompt(nil)}, nil
+		}
+		data := NewPromptData(in.AgentID, in.Thoughts)
+		if in.Trigger != "" {
+			data.Trigger = in.Trigger
+		}
+		data.Reason = in.Reason
+		data.CongestionDuration = in.CongestionDuration
+		data.Context = in.Context
+		data.Obsolete = ObsoleteThoughts(in.Obsolete)
+		data.Profile = in.Profile
+		data = FitBudget(in.Template, data, in.MaxTokens)
+		text, err := RenderPrompt(in.Template, data)
+		if err != nil {
+			return Result{}, err
+		}
+		messages, err := RenderMessages(in.Template, data)
+		if err != nil {
+			return Result{}, err
+		}
+		result := Result{Text: text, Messages: messages}
+		for _, t := range data.Thoughts {
+			if t.Truncated {
+				result.Truncated = t.ID
+			}
+		}
+		if data.OmittedCount > 0 {
+			result.Consumed = make([]int64, len(data.Thoughts))
+			for i, t := range data.Thoughts {
+				result.Consumed[i] = t.ID
+			}
+		}
+		return result, nil
+	}),
+	// latest-only sends the most recent thought; the older ones are superseded by it
+	"latest-only": SynthesizerFunc(func(in Input) (Result, error) {
+		c := chronological(in.Thoughts)
+		if len(c) == 0 {
+			return Result{Final: true}, nil
+		}
+		latest := c[len(c)-1]
+		return Result{
+			Text:      latest.Content,
+			Consumed:  []int64{latest.ID},
+			Discarded: thoughtIDs(c[:len(c)-1]),
+			Final:     true,
+		}, nil
+	}),
+	// concatenate sends every thought verbatim, oldest first
+	"concatenate": textOnly(func(in Input) string {
+		return joinContent(chronological(in.Thoughts))
+	}),
+	// digest summarises the thoughts as bullets without an LLM
+	"digest": textOnly(func(in Input) string {
+		return Digest(in.Thoughts)
+	}),
+	// priority-only sends the thoughts of the most urgent class present, oldest
+	// first; the rest stay pending
+	"priority-only": SynthesizerFunc(func(in Input) (Result, error) {
+		sorted := sortByPriority(in.Thoughts)
+		if len(sorted) == 0 {
+			return Result{Final: true}, nil
+		}
+		top := sorted[0].Effective()
+		var kept []db.Thought
+		for _, t := range sorted {
+			if t.Effective() == top {
+				kept = append(kept, t)
+			}
+		}
+		kept = chronological(kept)
+		return Result{Text: joinContent(kept), Consumed: thoughtIDs(kept), Final: true}, nil
+	}),
+}
+
+// Strategy returns the named synthesizer; empty selects DefaultStrategy
+func Strategy(name string) (Synthesizer, error) {
+	if name == "" {
+		name = DefaultStrategy
+	}
+	s, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown synthesis strategy %q (must be one of %s)", name, strings.Join(StrategyNames(), ", "))
+	}
+	return s, nil
+}
+
+// StrategyNames lists the built-in strategies alphabetically
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// digestLineLimit caps each digest bullet, in runes
+const digestLineLimit = 120
+
+// Digest renders a deterministic bullet summary: a count per priority class,
+// then one line per thought, most urgent first
+func Digest(thoughts []db.Thought) string {
+	if len(thoughts) == 0 {
+		return ""
+	}
+	reg := priority.Current()
+	sorted := sortByPriority(thoughts)
+
+	counts := map[string]int{}
+	var order []string
+	for _, t := range sorted {
+		p := t.Effective()
+		if counts[p] == 0 {
+			order = append(order, p)
+		}
+		counts[p]++
+	}
+	var parts []string
+	for _, p := range order {
+		parts = append(parts, fmt.Sprintf("%s: %d", p, counts[p]))
+	}
+
+	word := "thoughts"
+	if len(thoughts) == 1 {
+		word = "thought"
+	}
+	lines := []string{fmt.Sprintf("Digest of %d buffered %s (%s)", len(thoughts), word, strings.Join(parts, ", "))}
+	for _, t := range sorted {
+		tag := "[" + t.Effective() + "]"
+		if class, ok := reg.Lookup(t.Effective()); ok && class.Tag != "" {
+			tag = class.Tag
+		}
+		line := fmt.Sprintf("- %s %s", tag, summarizeLine(t.Content))
+		if t.Coalesced > 0 {
+			line += fmt.Sprintf(" (×%d similar)", t.Coalesced+1)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// summarizeLine returns the first line of s, cut to digestLineLimit runes
+func summarizeLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = strings.TrimSpace(s[:i]) + " …"
+	}
+	if r := []rune(s); len(r) > digestLineLimit {
+		s = string(r[:digestLineLimit-1]) + "…"
+	}
+	return s
+}
+
+// chronological returns a copy of thoughts ordered oldest first
+func chronological(thoughts []db.Thought) []db.Thought {
+	sorted := make([]db.Thought, len(thoughts))
+	copy(sorted, thoughts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].CreatedAt != sorted[j].CreatedAt {
+			return sorted[i].CreatedAt < sorted[j].CreatedAt
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// thoughtIDs returns the IDs of thoughts, in order
+func thoughtIDs(thoughts []db.Thought) []int64 {
+	ids := make([]int64, len(thoughts))
+	for i, t := range thoughts {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+func joinContent(thoughts []db.Thought) string {
+	parts := make([]string, len(thoughts))
+	for i, t := range thoughts {
+		parts[i] = t.Content
+	}
+	return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,142 @@
+package synthesis_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// STRATEGY TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestStrategy(t *testing.T) {
+	thoughts := []db.Thought{
+		{ID: 1, Content: "Low chatter", Priority: "P2", CreatedAt: "2026-02-07 12:00:00"},
+		{ID: 2, Content: "Deploy failed", Priority: "P0", CreatedAt: "2026-02-07 12:01:00"},
+		{ID: 3, Content: "Rollback done", Priority: "P0", CreatedAt: "2026-02-07 12:02:00"},
+		{ID: 4, Content: "Latest status", Priority: "P1", CreatedAt: "2026-02-07 12:03:00"},
+	}
+	run := func(t *testing.T, name string) string {
+		t.Helper()
+		s, err := synthesis.Strategy(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		out, err := s.Synthesize(synthesis.Input{AgentID: "main", Thoughts: thoughts})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out.Text
+	}
+
+	t.Run("empty name selects prompt", func(t *testing.T) {
+		out := run(t, "")
+		if out != synthesis.GeneratePrompt(thoughts) {
+			t.Error("expected default strategy to match GeneratePrompt")
+		}
+	})
+
+	t.Run("rejects unknown name", func(t *testing.T) {
+		if _, err := synthesis.Strategy("magic"); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("latest-only keeps newest thought", func(t *testing.T) {
+		if out := run(t, "latest-only"); out != "Latest status" {
+			t.Errorf("expected latest thought, got %q", out)
+		}
+	})
+
+	t.Run("latest-only supersedes the older thoughts", func(t *testing.T) {
+		s, _ := synthesis.Strategy("latest-only")
+		out, _ := s.Synthesize(synthesis.Input{AgentID: "main", Thoughts: thoughts})
+		if len(out.Consumed) != 1 || out.Consumed[0] != 4 || len(out.Discarded) != 3 {
+			t.Errorf("expected thought 4 consumed and the rest discarded, got %v / %v", out.Consumed, out.Discarded)
+		}
+	})
+
+	t.Run("concatenate joins oldest first", func(t *testing.T) {
+		out := run(t, "concatenate")
+		if out != "Low chatter\n\nDeploy failed\n\nRollback done\n\nLatest status" {
+			t.Errorf("unexpected output: %q", out)
+		}
+	})
+
+	t.Run("priority-only keeps most urgent class", func(t *testing.T) {
+		out := run(t, "priority-only")
+		if out != "Deploy failed\n\nRollback done" {
+			t.Errorf("unexpected output: %q", out)
+		}
+	})
+
+	t.Run("priority-only leaves other classes pending", func(t *testing.T) {
+		s, _ := synthesis.Strategy("priority-only")
+		out, _ := s.Synthesize(synthesis.Input{AgentID: "main", Thoughts: thoughts})
+		if len(out.Consumed) != 2 || out.Consumed[0] != 2 || out.Consumed[1] != 3 || out.Discarded != nil {
+			t.Errorf("expected only the P0 thoughts consumed, got %v / %v", out.Consumed, out.Discarded)
+		}
+	})
+
+	t.Run("digest summarises by priority", func(t *testing.T) {
+		out := run(t, "digest")
+		lines := strings.Split(out, "\n")
+		if lines[0] != "Digest of 4 buffered thoughts (P0: 2, P1: 1, P2: 1)" {
+			t.Errorf("unexpected header: %q", lines[0])
+		}
+		if lines[1] != "- [CRITICAL] Deploy failed" || lines[4] != "- [low] Low chatter" {
+			t.Errorf("unexpected bullets: %q", lines[1:])
+		}
+	})
+
+	t.Run("digest is deterministic", func(t *testing.T) {
+		if run(t, "digest") != run(t, "digest") {
+			t.Error("digest output changed between runs")
+		}
+	})
+
+	t.Run("digest cuts long and multiline thoughts", func(t *testing.T) {
+		long := []db.Thought{
+			{ID: 1, Content: strings.Repeat("x", 500), Priority: "P1"},
+			{ID: 2, Content: "first line\nsecond line", Priority: "P1"},
+		}
+		out := synthesis.Digest(long)
+		for _, line := range strings.Split(out, "\n")[1:] {
+			if len([]rune(line)) > 130 {
+				t.Errorf("bullet too long: %d runes", len([]rune(line)))
+			}
+			if strings.Contains(line, "second line") {
+				t.Error("expected only the first line")
+			}
+		}
+	})
+
+	t.Run("prompt lists obsolete thoughts separately", func(t *testing.T) {
+		s, _ := synthesis.Strategy("prompt")
+		obsolete := []db.Thought{{ID: 9, Content: "Deploy at 5pm", Priority: "P1", CreatedAt: "2026-02-07 11:00:00"}}
+		res, err := s.Synthesize(synthesis.Input{Thoughts: thoughts, Obsolete: obsolete})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		i := strings.Index(res.Text, "**ALREADY OBSOLETE**")
+		if i < 0 || !strings.Contains(res.Text[i:], "Deploy at 5pm") {
+			t.Errorf("expected obsolete section:\n%s", res.Text)
+		}
+		if strings.Contains(res.Text[:i], "Deploy at 5pm") {
+			t.Error("obsolete thought should not be listed with the pending ones")
+		}
+		if plain := run(t, "prompt"); strings.Contains(plain, "ALREADY OBSOLETE") {
+			t.Error("section should only appear when there are obsolete thoughts")
+		}
+	})
+
+	t.Run("names are listed", func(t *testing.T) {
+		names := strings.Join(synthesis.StrategyNames(), ",")
+		if names != "concatenate,digest,latest-only,priority-only,prompt" {
+			t.Errorf("unexpected names: %s", names)
+		}
+	})
+}
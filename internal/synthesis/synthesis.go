@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/priority"
 )
 
 // State represents the current system state for buffering decisions
@@ -16,6 +18,10 @@ type State struct {
 	ForcedBuffering bool
 	SimulatedMs     int64
 	Halted          bool
+	// Debounce is the agent's coalescing window; zero disables it
+	Debounce time.Duration
+	// Cooldown is the time left in the agent's post-flush cooldown
+	Cooldown time.Duration
 }
 
 // BufferResult represents the result of a buffering decision
@@ -70,78 +76,88 @@ func ShouldBuffer(state State) BufferResult {
 	}
 }
 
-// GeneratePrompt creates a synthesis prompt from buffered thoughts
-func GeneratePrompt(thoughts []db.Thought) string {
-	if len(thoughts) == 0 {
-		return "**SYSTEM: No buffered thoughts to synthesize.**"
-	}
+// ExpressPolicy controls which traffic may bypass buffering during congestion
+type ExpressPolicy struct {
+	Enabled bool
+	Limit   int // max express sends per rate window
+}
 
-	// Sort by priority (P0 < P1 < P2) then by time
-	sorted := make([]db.Thought, len(thoughts))
-	copy(sorted, thoughts)
-	sort.Slice(sorted, func(i, j int) bool {
-		pi := priorityOrder(sorted[i].Priority)
-		pj := priorityOrder(sorted[j].Priority)
-		if pi != pj {
-			return pi < pj
-		}
-		return sorted[i].CreatedAt < sorted[j].CreatedAt
-	})
+// GateResult represents the decision for a single outgoing message
+type GateResult struct {
+	Pass      bool
+	Express   bool
+	Debounced bool
+	Cooldown  bool
+	Reason    string
+}
 
-	var lines []string
-	for i, t := range sorted {
-		tag := ""
-		switch t.Priority {
-		case "P0":
-			tag = " [CRITICAL]"
-		case "P2":
-			tag = " [low]"
+// Gate decides whether a message of the given priority may be sent now.
+// expressSent is the number of express-lane sends in the current rate window.
+func Gate(state State, prio string, expressSent int, policy ExpressPolicy) GateResult {
+	result := ShouldBuffer(state)
+	class, _ := priority.Current().Lookup(prio)
+
+	// A cooling-down agent is buffered like a congested one, whatever the latency
+	cooldown := !result.Buffering && state.Cooldown > 0
+	if cooldown {
+		result = BufferResult{
+			Buffering: true,
+			Reason:    fmt.Sprintf("cooldown %s", state.Cooldown.Round(time.Second)),
+			LatencyMs: result.LatencyMs,
 		}
-		escaped := escapeContent(t.Content)
-		lines = append(lines, fmt.Sprintf("%d. [%s]%s \"%s\"", i+1, t.CreatedAt, tag, escaped))
 	}
 
-	// Count P0 thoughts
-	p0Count := 0
-	for _, t := range sorted {
-		if t.Priority == "P0" {
-			p0Count++
+	if !result.Buffering {
+		// Coalesce bursts even when healthy; express classes never wait
+		if state.Debounce > 0 && !class.Express {
+			return GateResult{Debounced: true, Reason: fmt.Sprintf("debounce %s", state.Debounce)}
 		}
+		return GateResult{Pass: true, Reason: result.Reason}
 	}
 
-	criticalNote := ""
-	if p0Count > 0 {
-		criticalNote = fmt.Sprintf("\n\n**Note:** %d CRITICAL thought(s) — preserve unless clearly obsolete.", p0Count)
+	// An explicit halt stops everything, express lane included
+	if state.Halted || !class.Express || !policy.Enabled {
+		return GateResult{Cooldown: cooldown, Reason: result.Reason}
 	}
 
-	messageWord := "messages"
-	if len(thoughts) == 1 {
-		messageWord = "message"
+	if expressSent >= policy.Limit {
+		return GateResult{
+			Cooldown: cooldown,
+			Reason:   fmt.Sprintf("%s; express lane rate limited (%d/%d)", result.Reason, expressSent, policy.Limit),
+		}
 	}
 
-	return fmt.Sprintf(`**SYSTEM: NETWORK RECOVERED**
-
-While congested, you drafted %d %s:
-
-%s%s
-
-**TASK:** Review against current channel state.
-- Discard obsolete/superseded thoughts
-- Synthesize remaining into ONE coherent message
-- Do not apologize or mention delays`, len(thoughts), messageWord, strings.Join(lines, "\n"), criticalNote)
+	return GateResult{
+		Pass:    true,
+		Express: true,
+		Reason:  fmt.Sprintf("express lane (%s)", result.Reason),
+	}
 }
 
-func priorityOrder(p string) int {
-	switch p {
-	case "P0":
-		return 0
-	case "P1":
-		return 1
-	case "P2":
-		return 2
-	default:
-		return 1
+// GeneratePrompt creates a synthesis prompt from buffered thoughts using DefaultTemplate
+func GeneratePrompt(thoughts []db.Thought) string {
+	if len(thoughts) == 0 {
+		return "**SYSTEM: No buffered thoughts to synthesize.**"
 	}
+	// The built-in template is checked at init and cannot fail on valid data
+	prompt, _ := RenderPrompt(nil, NewPromptData("", thoughts))
+	return prompt
+}
+
+// sortByPriority returns a copy of thoughts ordered by effective priority rank, then by time
+func sortByPriority(thoughts []db.Thought) []db.Thought {
+	reg := priority.Current()
+	sorted := make([]db.Thought, len(thoughts))
+	copy(sorted, thoughts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		pi := reg.Rank(sorted[i].Effective())
+		pj := reg.Rank(sorted[j].Effective())
+		if pi != pj {
+			return pi < pj
+		}
+		return sorted[i].CreatedAt < sorted[j].CreatedAt
+	})
+	return sorted
 }
 
 func escapeContent(s string) string {
@@ -151,17 +167,9 @@ func escapeContent(s string) string {
 	return s
 }
 
-// ValidatePriority validates and normalizes priority
+// ValidatePriority validates and normalizes priority against the configured classes
 func ValidatePriority(p string) (string, error) {
-	if p == "" {
-		return "P1", nil
-	}
-	switch p {
-	case "P0", "P1", "P2":
-		return p, nil
-	default:
-		return "", fmt.Errorf("invalid priority: %s (must be P0, P1, or P2)", p)
-	}
+	return priority.Current().Validate(p)
 }
 
 // ValidateThought validates thought content
@@ -3,8 +3,10 @@ package synthesis_test
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/priority"
 	"github.com/rickhallett/antibeaver/internal/synthesis"
 )
 
@@ -95,6 +97,29 @@ func TestGeneratePrompt(t *testing.T) {
 		}
 	})
 
+	t.Run("tags by effective priority", func(t *testing.T) {
+		thoughts := []db.Thought{
+			{ID: 1, Content: "Aged", Priority: "P2", EffectivePriority: "P1", CreatedAt: "2026-02-07T12:00:00Z"},
+		}
+		prompt := synthesis.GeneratePrompt(thoughts)
+
+		if strings.Contains(prompt, "[low]") {
+			t.Error("aged P2 should no longer be tagged low")
+		}
+	})
+
+	t.Run("sorts by effective priority", func(t *testing.T) {
+		thoughts := []db.Thought{
+			{ID: 1, Content: "Fresh", Priority: "P1", CreatedAt: "2026-02-07T12:05:00Z"},
+			{ID: 2, Content: "Aged", Priority: "P2", EffectivePriority: "P0", CreatedAt: "2026-02-07T12:00:00Z"},
+		}
+		prompt := synthesis.GeneratePrompt(thoughts)
+
+		if strings.Index(prompt, "Aged") > strings.Index(prompt, "Fresh") {
+			t.Error("aged thought should sort first")
+		}
+	})
+
 	t.Run("no tag for P1", func(t *testing.T) {
 		thoughts := []db.Thought{
 			{ID: 1, Content: "Normal thought", Priority: "P1", CreatedAt: "2026-02-07T12:00:00Z"},
@@ -184,6 +209,25 @@ func TestGeneratePrompt(t *testing.T) {
 		}
 	})
 
+	t.Run("uses tags from priority registry", func(t *testing.T) {
+		useSecurityRegistry(t)
+		thoughts := []db.Thought{
+			{ID: 1, Content: "Chatter", Priority: "telemetry", CreatedAt: "2026-02-07T12:00:00Z"},
+			{ID: 2, Content: "Leaked key", Priority: "security", CreatedAt: "2026-02-07T12:01:00Z"},
+		}
+		prompt := synthesis.GeneratePrompt(thoughts)
+
+		if !strings.Contains(prompt, "[SECURITY]") || !strings.Contains(prompt, "[telemetry]") {
+			t.Error("expected custom tags")
+		}
+		if strings.Index(prompt, "Leaked key") > strings.Index(prompt, "Chatter") {
+			t.Error("security should sort before telemetry")
+		}
+		if !strings.Contains(prompt, "1 CRITICAL") {
+			t.Error("expected critical note for security class")
+		}
+	})
+
 	t.Run("handles very long content", func(t *testing.T) {
 		longContent := strings.Repeat("a", 10000)
 		thoughts := []db.Thought{
@@ -348,6 +392,140 @@ func TestShouldBuffer(t *testing.T) {
 	})
 }
 
+// ═══════════════════════════════════════════════════════════════════════════
+// GATE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestGate(t *testing.T) {
+	congested := synthesis.State{MaxLatency: 8000, Threshold: 5000}
+	policy := synthesis.ExpressPolicy{Enabled: true, Limit: 2}
+
+	t.Run("passes everything when healthy", func(t *testing.T) {
+		state := synthesis.State{MaxLatency: 100, Threshold: 5000}
+		result := synthesis.Gate(state, "P2", 0, policy)
+
+		if !result.Pass || result.Express {
+			t.Error("healthy traffic should pass without express")
+		}
+	})
+
+	t.Run("buffers P1 when congested", func(t *testing.T) {
+		result := synthesis.Gate(congested, "P1", 0, policy)
+
+		if result.Pass {
+			t.Error("P1 should be buffered under congestion")
+		}
+	})
+
+	t.Run("passes P0 through express lane when congested", func(t *testing.T) {
+		result := synthesis.Gate(congested, "P0", 0, policy)
+
+		if !result.Pass || !result.Express {
+			t.Error("P0 should use express lane under congestion")
+		}
+		if !strings.Contains(result.Reason, "express") {
+			t.Errorf("expected reason to mention express, got '%s'", result.Reason)
+		}
+	})
+
+	t.Run("buffers P0 once rate limit reached", func(t *testing.T) {
+		result := synthesis.Gate(congested, "P0", 2, policy)
+
+		if result.Pass {
+			t.Error("P0 should be buffered once express limit reached")
+		}
+		if !strings.Contains(result.Reason, "rate limited") {
+			t.Errorf("expected rate limit reason, got '%s'", result.Reason)
+		}
+	})
+
+	t.Run("buffers P0 when halted", func(t *testing.T) {
+		state := synthesis.State{Threshold: 5000, Halted: true}
+		result := synthesis.Gate(state, "P0", 0, policy)
+
+		if result.Pass {
+			t.Error("halt should stop the express lane")
+		}
+	})
+
+	t.Run("buffers P0 when express disabled", func(t *testing.T) {
+		result := synthesis.Gate(congested, "P0", 0, synthesis.ExpressPolicy{Limit: 5})
+
+		if result.Pass {
+			t.Error("disabled express lane should buffer P0")
+		}
+	})
+
+	t.Run("express eligibility comes from priority class", func(t *testing.T) {
+		useSecurityRegistry(t)
+
+		if !synthesis.Gate(congested, "security", 0, policy).Express {
+			t.Error("security class should be express eligible")
+		}
+		if synthesis.Gate(congested, "telemetry", 0, policy).Pass {
+			t.Error("telemetry class should be buffered")
+		}
+	})
+
+	t.Run("debounces when healthy", func(t *testing.T) {
+		state := synthesis.State{MaxLatency: 100, Threshold: 5000, Debounce: 3 * time.Second}
+		result := synthesis.Gate(state, "P1", 0, policy)
+
+		if result.Pass || !result.Debounced {
+			t.Error("healthy traffic should be debounced when a window is configured")
+		}
+	})
+
+	t.Run("express classes skip debounce", func(t *testing.T) {
+		state := synthesis.State{MaxLatency: 100, Threshold: 5000, Debounce: 3 * time.Second}
+		result := synthesis.Gate(state, "P0", 0, policy)
+
+		if !result.Pass || result.Express {
+			t.Error("P0 should pass immediately without using the express lane")
+		}
+	})
+
+	t.Run("congestion buffering is not debounce", func(t *testing.T) {
+		state := congested
+		state.Debounce = 3 * time.Second
+		result := synthesis.Gate(state, "P1", 0, policy)
+
+		if result.Pass || result.Debounced {
+			t.Error("congested traffic is buffered, not debounced")
+		}
+	})
+
+	t.Run("buffers during cooldown while healthy", func(t *testing.T) {
+		state := synthesis.State{MaxLatency: 100, Threshold: 5000, Cooldown: 12 * time.Second}
+		result := synthesis.Gate(state, "P1", 0, policy)
+
+		if result.Pass || !result.Cooldown {
+			t.Error("cooling-down agent should be buffered")
+		}
+		if !strings.Contains(result.Reason, "cooldown 12s") {
+			t.Errorf("expected cooldown reason, got '%s'", result.Reason)
+		}
+	})
+
+	t.Run("express lane applies during cooldown", func(t *testing.T) {
+		state := synthesis.State{MaxLatency: 100, Threshold: 5000, Cooldown: 12 * time.Second}
+		result := synthesis.Gate(state, "P0", 0, policy)
+
+		if !result.Express {
+			t.Error("P0 should use express lane during cooldown")
+		}
+	})
+
+	t.Run("express allowed under manual override", func(t *testing.T) {
+		state := synthesis.State{Threshold: 5000, ForcedBuffering: true}
+		result := synthesis.Gate(state, "P0", 0, policy)
+
+		if !result.Express {
+			t.Error("forced buffering is not a halt; P0 should pass")
+		}
+	})
+}
+
 // ═══════════════════════════════════════════════════════════════════════════
 // VALIDATION TESTS
 // ═══════════════════════════════════════════════════════════════════════════
@@ -394,6 +572,22 @@ func TestValidatePriority(t *testing.T) {
 			t.Error("should reject lowercase")
 		}
 	})
+
+	t.Run("accepts configured classes", func(t *testing.T) {
+		useSecurityRegistry(t)
+
+		p, err := synthesis.ValidatePriority("security")
+		if err != nil || p != "security" {
+			t.Error("should accept configured class")
+		}
+		p, _ = synthesis.ValidatePriority("")
+		if p != "user-facing" {
+			t.Errorf("expected configured default, got %s", p)
+		}
+		if _, err := synthesis.ValidatePriority("P0"); err == nil {
+			t.Error("should reject classes not in registry")
+		}
+	})
 }
 
 func TestValidateThought(t *testing.T) {
@@ -465,3 +659,22 @@ func TestValidateLatency(t *testing.T) {
 		}
 	})
 }
+
+// ═══════════════════════════════════════════════════════════════════════════
+// HELPER
+// ═══════════════════════════════════════════════════════════════════════════
+
+func useSecurityRegistry(t *testing.T) {
+	t.Helper()
+	reg, err := priority.New([]priority.Class{
+		{Name: "security", Rank: 0, Tag: "[SECURITY]", Express: true, Critical: true},
+		{Name: "user-facing", Rank: 1},
+		{Name: "telemetry", Rank: 2, Tag: "[telemetry]"},
+	}, "user-facing")
+	if err != nil {
+		t.Fatalf("failed to build registry: %v", err)
+	}
+	orig := priority.Current()
+	priority.Use(reg)
+	t.Cleanup(func() { priority.Use(orig) })
+}
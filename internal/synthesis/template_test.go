@@ -0,0 +1,168 @@
+package synthesis_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickhallett/antibeaver/internal/db"
+	"github.com/rickhallett/antibeaver/internal/synthesis"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// TEMPLATE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestParseTemplate(t *testing.T) {
+	t.Run("accepts template fields", func(t *testing.T) {
+		_, err := synthesis.ParseTemplate("ok", `{{.Count}} {{.P0Count}} {{.Reason}} {{.CongestionDuration}}{{range .Thoughts}}{{.Tag}} {{.Content}}{{end}}`)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("rejects bad syntax", func(t *testing.T) {
+		if _, err := synthesis.ParseTemplate("bad", `{{if .Count}}`); err == nil {
+			t.Error("expected parse error")
+		}
+	})
+
+	t.Run("rejects unknown field", func(t *testing.T) {
+		_, err := synthesis.ParseTemplate("bad", `{{.Nope}}`)
+		if err == nil || !strings.Contains(err.Error(), "Nope") {
+			t.Errorf("expected error naming the field, got %v", err)
+		}
+	})
+
+	t.Run("requires system and user blocks together", func(t *testing.T) {
+		if _, err := synthesis.ParseTemplate("half", `{{define "system"}}hi{{end}}{{.Count}}`); err == nil {
+			t.Error("expected error for lone system block")
+		}
+	})
+
+	t.Run("default template parses", func(t *testing.T) {
+		if _, err := synthesis.ParseTemplate("default", synthesis.DefaultTemplate); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestRenderPrompt(t *testing.T) {
+	thoughts := []db.Thought{
+		{ID: 1, Content: "Hola", Priority: "P2", CreatedAt: "2026-02-07 12:00:00"},
+		{ID: 2, Content: "Caída", Priority: "P0", CreatedAt: "2026-02-07 12:01:00"},
+	}
+
+	t.Run("custom template receives episode data", func(t *testing.T) {
+		tmpl, err := synthesis.ParseTemplate("es", `Red recuperada tras {{.CongestionDuration}} ({{.Reason}}). {{.Count}} mensajes, {{.P0Count}} críticos:{{range .Thoughts}} {{.Index}}={{.Content}}{{end}}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data := synthesis.NewPromptData("main", thoughts)
+		data.Reason = "manual override"
+		data.CongestionDuration = 90 * time.Second
+
+		out, err := synthesis.RenderPrompt(tmpl, data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := "Red recuperada tras 1m30s (manual override). 2 mensajes, 1 críticos: 1=Caída 2=Hola"
+		if out != want {
+			t.Errorf("expected %q, got %q", want, out)
+		}
+	})
+
+	t.Run("debounce flush is not worded as a recovery", func(t *testing.T) {
+		data := synthesis.NewPromptData("main", thoughts)
+		data.Trigger = synthesis.TriggerDebounce
+		out, err := synthesis.RenderPrompt(nil, data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(out, "NETWORK RECOVERED") || strings.Contains(out, "While congested") {
+			t.Errorf("debounce prompt mentions recovery:\n%s", out)
+		}
+		if !strings.Contains(out, "While your messages were held back, you drafted 2 messages") {
+			t.Errorf("expected debounce lead-in:\n%s", out)
+		}
+	})
+
+	t.Run("nil template is the default", func(t *testing.T) {
+		out, err := synthesis.RenderPrompt(nil, synthesis.NewPromptData("", thoughts))
+		if err != nil || out != synthesis.GeneratePrompt(thoughts) {
+			t.Errorf("expected default prompt, got %q (%v)", out, err)
+		}
+	})
+
+	t.Run("default messages split instruction from thoughts", func(t *testing.T) {
+		msgs, err := synthesis.RenderMessages(nil, synthesis.NewPromptData("", thoughts))
+		if err != nil || len(msgs) != 2 {
+			t.Fatalf("expected two messages, got %v (%v)", msgs, err)
+		}
+		if msgs[0].Role != "system" || !strings.Contains(msgs[0].Content, "NETWORK RECOVERED") || strings.Contains(msgs[0].Content, "Caída") {
+			t.Errorf("unexpected system message: %q", msgs[0].Content)
+		}
+		if msgs[1].Role != "user" || !strings.Contains(msgs[1].Content, "Caída") || strings.Contains(msgs[1].Content, "TASK") {
+			t.Errorf("unexpected user message: %q", msgs[1].Content)
+		}
+	})
+
+	t.Run("template without blocks has no messages", func(t *testing.T) {
+		tmpl, _ := synthesis.ParseTemplate("plain", `{{.Count}}`)
+		msgs, err := synthesis.RenderMessages(tmpl, synthesis.NewPromptData("", thoughts))
+		if err != nil || msgs != nil {
+			t.Errorf("expected no messages, got %v (%v)", msgs, err)
+		}
+		if got := (synthesis.Result{Text: "2"}).ChatMessages(); len(got) != 1 || got[0].Role != "user" {
+			t.Errorf("expected single user message fallback, got %v", got)
+		}
+	})
+
+	t.Run("shapes messages for chat APIs", func(t *testing.T) {
+		msgs, _ := synthesis.RenderMessages(nil, synthesis.NewPromptData("", thoughts))
+
+		openai, err := synthesis.ShapeMessages(msgs, synthesis.ShapeOpenAI)
+		if got, ok := openai.([]synthesis.Message); err != nil || !ok || len(got) != 2 {
+			t.Errorf("expected the message array unchanged, got %v (%v)", openai, err)
+		}
+		shaped, err := synthesis.ShapeMessages(msgs, synthesis.ShapeAnthropic)
+		body, ok := shaped.(synthesis.AnthropicMessages)
+		if err != nil || !ok {
+			t.Fatalf("expected an Anthropic body, got %v (%v)", shaped, err)
+		}
+		if body.System != msgs[0].Content || len(body.Messages) != 1 || body.Messages[0].Role != "user" {
+			t.Errorf("expected system lifted out of the messages, got %+v", body)
+		}
+		if _, err := synthesis.ShapeMessages(msgs, "gemini"); err == nil {
+			t.Error("expected error for unknown shape")
+		}
+	})
+
+	t.Run("attributes thoughts from several agents", func(t *testing.T) {
+		data := synthesis.NewPromptData(db.AllAgents, []db.Thought{
+			{ID: 1, AgentID: "main", Content: "Deploy done", Priority: "P1"},
+			{ID: 2, AgentID: "architect", Content: "Schema merged", Priority: "P1"},
+		})
+		if strings.Join(data.Agents, ",") != "architect,main" || data.Thoughts[1].Agent != "architect" {
+			t.Errorf("unexpected attribution: %v %+v", data.Agents, data.Thoughts)
+		}
+		out, _ := synthesis.RenderPrompt(nil, data)
+		if !strings.Contains(out, "agents architect, main drafted 2 messages") || !strings.Contains(out, "] from main") {
+			t.Errorf("expected agent attribution in prompt:\n%s", out)
+		}
+
+		single := synthesis.NewPromptData("main", thoughts)
+		if single.Agents != nil || single.Thoughts[0].Agent != "" {
+			t.Errorf("single-agent prompt should not attribute, got %+v", single)
+		}
+	})
+
+	t.Run("prompt strategy uses input template", func(t *testing.T) {
+		tmpl, _ := synthesis.ParseTemplate("short", `{{.AgentID}}: {{.Count}}`)
+		s, _ := synthesis.Strategy("prompt")
+		out, err := s.Synthesize(synthesis.Input{AgentID: "architect", Thoughts: thoughts, Template: tmpl})
+		if err != nil || out.Text != "architect: 2" {
+			t.Errorf("unexpected output %q (%v)", out.Text, err)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package tokens
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// charsPerToken is the usual ratio for English text across common LLM tokenizers
+const charsPerToken = 4
+
+// Estimate returns an approximate token count for s.
+// It errs high for short strings so budgets are not overrun.
+func Estimate(s string) int {
+	n := utf8.RuneCountInString(s)
+	if n == 0 {
+		return 0
+	}
+	return (n + charsPerToken - 1) / charsPerToken
+}
+
+// Truncate cuts s to roughly maxTokens, preferring a word boundary.
+// It returns s unchanged if it already fits.
+func Truncate(s string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	limit := maxTokens * charsPerToken
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	cut := string([]rune(s)[:limit])
+	// Back up to the last space unless that throws away most of the text
+	if i := strings.LastIndexAny(cut, " \n\t"); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " \n\t")
+}
+
+// Tail keeps roughly the last maxTokens of s, preferring a line boundary.
+// It returns s unchanged if it already fits.
+func Tail(s string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	limit := maxTokens * charsPerToken
+	r := []rune(s)
+	if len(r) <= limit {
+		return s
+	}
+	cut := string(r[len(r)-limit:])
+	// Skip ahead to the next line start unless that throws away most of the text
+	if i := strings.IndexByte(cut, '\n'); i >= 0 && i < len(cut)/2 {
+		cut = cut[i+1:]
+	}
+	return strings.TrimLeft(cut, " \n\t")
+}
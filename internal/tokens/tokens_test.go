@@ -0,0 +1,94 @@
+package tokens_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rickhallett/antibeaver/internal/tokens"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════
+// ESTIMATE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestEstimate(t *testing.T) {
+	t.Run("empty is zero", func(t *testing.T) {
+		if got := tokens.Estimate(""); got != 0 {
+			t.Errorf("expected 0, got %d", got)
+		}
+	})
+
+	t.Run("rounds up", func(t *testing.T) {
+		if got := tokens.Estimate("hello"); got != 2 {
+			t.Errorf("expected 2, got %d", got)
+		}
+	})
+
+	t.Run("counts runes not bytes", func(t *testing.T) {
+		if got := tokens.Estimate("ñññññññ"); got != 2 {
+			t.Errorf("expected 2, got %d", got)
+		}
+	})
+
+	t.Run("scales with length", func(t *testing.T) {
+		if got := tokens.Estimate(strings.Repeat("a", 4000)); got != 1000 {
+			t.Errorf("expected 1000, got %d", got)
+		}
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	t.Run("leaves short text alone", func(t *testing.T) {
+		if got := tokens.Truncate("short", 10); got != "short" {
+			t.Errorf("unexpected %q", got)
+		}
+	})
+
+	t.Run("fits the budget", func(t *testing.T) {
+		got := tokens.Truncate(strings.Repeat("word ", 200), 10)
+		if tokens.Estimate(got) > 10 {
+			t.Errorf("truncated text still %d tokens", tokens.Estimate(got))
+		}
+	})
+
+	t.Run("prefers word boundary", func(t *testing.T) {
+		got := tokens.Truncate("alpha beta gamma delta", 4)
+		if got != "alpha beta" {
+			t.Errorf("expected word boundary, got %q", got)
+		}
+	})
+
+	t.Run("zero budget is empty", func(t *testing.T) {
+		if got := tokens.Truncate("anything", 0); got != "" {
+			t.Errorf("expected empty, got %q", got)
+		}
+	})
+}
+
+func TestTail(t *testing.T) {
+	t.Run("leaves short text alone", func(t *testing.T) {
+		if got := tokens.Tail("short", 10); got != "short" {
+			t.Errorf("unexpected %q", got)
+		}
+	})
+
+	t.Run("keeps the end within budget", func(t *testing.T) {
+		got := tokens.Tail(strings.Repeat("old line\n", 50)+"newest", 10)
+		if !strings.HasSuffix(got, "newest") || tokens.Estimate(got) > 10 {
+			t.Errorf("unexpected tail %q", got)
+		}
+	})
+
+	t.Run("prefers line boundary", func(t *testing.T) {
+		got := tokens.Tail("alice: first\nbob: second\ncarol: third", 5)
+		if got != "carol: third" {
+			t.Errorf("expected whole last line, got %q", got)
+		}
+	})
+
+	t.Run("zero budget is empty", func(t *testing.T) {
+		if got := tokens.Tail("anything", 0); got != "" {
+			t.Errorf("expected empty, got %q", got)
+		}
+	})
+}
@@ -3,11 +3,14 @@ package tests
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // ═══════════════════════════════════════════════════════════════════════════
@@ -144,6 +147,37 @@ func TestBufferCommand(t *testing.T) {
 		}
 	})
 
+	t.Run("accepts configured priority class", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{
+			"aging": {"ceiling": "user-facing"},
+			"priorities": {"default": "user-facing", "classes": [
+				{"name": "security", "rank": 0, "express": true},
+				{"name": "user-facing", "rank": 1}
+			]}
+		}`), 0644)
+
+		cmd := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "buffer", "--json", "--priority", "security", "Key leaked")
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("command failed: %v", err)
+		}
+
+		var result map[string]interface{}
+		json.Unmarshal(stdout.Bytes(), &result)
+		if result["priority"] != "security" {
+			t.Errorf("expected security priority, got %v", result["priority"])
+		}
+
+		if err := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "buffer", "--priority", "P0", "Old class").Run(); err == nil {
+			t.Error("expected P0 to be rejected when not configured")
+		}
+	})
+
 	t.Run("returns json output", func(t *testing.T) {
 		stdout, _, err := runCLI(t, "buffer", "--json", "JSON test")
 		if err != nil {
@@ -295,6 +329,1574 @@ func TestFlushCommand(t *testing.T) {
 			t.Errorf("expected 0 pending after flush all, got %v", pending)
 		}
 	})
+
+	t.Run("strategy flag selects synthesizer", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Older").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Newer").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--strategy", "latest-only").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		if !strings.Contains(string(out), "Newer") || strings.Contains(string(out), "Older") {
+			t.Errorf("expected only the latest thought, got %s", out)
+		}
+	})
+
+	t.Run("dropped thoughts are not lost", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		pending := func() interface{} {
+			out, _ := exec.Command(binaryPath, "--db", dbPath, "--json", "status").Output()
+			var status map[string]interface{}
+			json.Unmarshal(out, &status)
+			return status["pending"]
+		}
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P2", "Chatter").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "Outage").Run()
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "flush", "--strategy", "priority-only").Output()
+		if !strings.Contains(string(out), "1 deferred") || pending() != float64(1) {
+			t.Errorf("expected the P2 thought to stay pending, got %v:\n%s", pending(), out)
+		}
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Newer").Run()
+		out, _ = exec.Command(binaryPath, "--db", dbPath, "--json", "flush", "--strategy", "latest-only").Output()
+		if !strings.Contains(string(out), `"discarded": 1`) || pending() != float64(0) {
+			t.Errorf("expected the older thought superseded, got %v:\n%s", pending(), out)
+		}
+	})
+
+	t.Run("strategy configurable per agent", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"agents": {"main": {"strategy": "digest"}}}`), 0644)
+
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "buffer", "Thought").Run()
+
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "flush").Output()
+		if !strings.Contains(string(out), "Digest of 1 buffered thought") {
+			t.Errorf("expected digest output, got %s", out)
+		}
+	})
+
+	t.Run("rejects unknown strategy", func(t *testing.T) {
+		if _, _, err := runCLI(t, "flush", "--strategy", "magic"); err == nil {
+			t.Error("expected error for unknown strategy")
+		}
+	})
+
+	t.Run("uses configured prompt template", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(filepath.Join(tmpDir, "es.tmpl"), []byte(`RED RECUPERADA ({{.Reason}}): {{.Count}} mensajes`), 0644)
+		os.WriteFile(configPath, []byte(`{"agents": {"main": {"prompt_template": "es.tmpl"}}}`), 0644)
+
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "force").Run()
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "gate", "Hola").Run()
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "resume").Run()
+
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "flush").Output()
+		if !strings.Contains(string(out), "RED RECUPERADA (manual override): 1 mensajes") {
+			t.Errorf("expected templated prompt, got %s", out)
+		}
+	})
+
+	t.Run("format messages emits chat messages", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "Deploy failed").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--format", "messages").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var messages []map[string]string
+		if err := json.Unmarshal(out, &messages); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		if len(messages) != 2 || messages[0]["role"] != "system" || messages[1]["role"] != "user" {
+			t.Fatalf("unexpected messages: %v", messages)
+		}
+		if strings.Contains(messages[0]["content"], "Deploy failed") || !strings.Contains(messages[1]["content"], "Deploy failed") {
+			t.Error("thoughts belong in the user message only")
+		}
+	})
+
+	t.Run("format messages in anthropic shape", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "Deploy failed").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--format", "messages", "--shape", "anthropic").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var body struct {
+			System   string              `json:"system"`
+			Messages []map[string]string `json:"messages"`
+		}
+		if err := json.Unmarshal(out, &body); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		if !strings.Contains(body.System, "TASK") || len(body.Messages) != 1 || body.Messages[0]["role"] != "user" {
+			t.Errorf("expected top-level system and one user message, got %+v", body)
+		}
+	})
+
+	t.Run("shape needs format messages", func(t *testing.T) {
+		if _, stderr, err := runCLI(t, "flush", "--shape", "anthropic"); err == nil || !strings.Contains(stderr, "--format messages") {
+			t.Errorf("expected --shape error, got %v: %s", err, stderr)
+		}
+		if _, _, err := runCLI(t, "flush", "--format", "messages", "--shape", "gemini"); err == nil {
+			t.Error("expected error for unknown shape")
+		}
+	})
+
+	t.Run("format json includes event ID and thoughts", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "First").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Second").Run()
+
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "flush", "--format", "json").Output()
+		var result map[string]interface{}
+		if err := json.Unmarshal(out, &result); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		if result["event_id"] == nil || result["event_id"].(float64) < 1 {
+			t.Errorf("expected event ID, got %v", result["event_id"])
+		}
+		if thoughts := result["thoughts"].([]interface{}); len(thoughts) != 2 {
+			t.Errorf("expected 2 thoughts, got %d", len(thoughts))
+		}
+		if !strings.Contains(result["prompt"].(string), "NETWORK RECOVERED") {
+			t.Error("expected prompt")
+		}
+	})
+
+	t.Run("max tokens defers what does not fit", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		long := strings.Repeat("details ", 300)
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P2", "Chatter "+long).Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "Outage "+long).Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--max-tokens", "700").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		if !strings.Contains(string(out), "Outage") || strings.Contains(string(out), "Chatter") {
+			t.Error("expected only the P0 thought in the prompt")
+		}
+		if !strings.Contains(string(out), "**Omitted:** 1") {
+			t.Errorf("expected omission note, got %s", out)
+		}
+
+		status, _ := exec.Command(binaryPath, "--db", dbPath, "status", "--json").Output()
+		var result map[string]interface{}
+		json.Unmarshal(status, &result)
+		if result["pending"] != float64(1) {
+			t.Errorf("expected deferred thought to stay pending, got %v", result["pending"])
+		}
+	})
+
+	t.Run("flush reports the truncated thought", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		long := strings.Repeat("details ", 600)
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "Outage "+long).Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P1", "Also "+long).Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--max-tokens", "400", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(out, &doc); err != nil || doc["truncated"] != float64(1) {
+			t.Fatalf("expected thought 1 reported truncated, got %s", out)
+		}
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "Again "+long).Run()
+		out, _ = exec.Command(binaryPath, "--db", dbPath, "flush", "--max-tokens", "400").Output()
+		if !strings.Contains(string(out), "#3 cut short to fit the token budget") {
+			t.Errorf("expected truncation warning, got %s", out)
+		}
+	})
+
+	t.Run("context is embedded and reported", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		contextPath := filepath.Join(tmpDir, "channel.txt")
+		os.WriteFile(contextPath, []byte("alice: rollback finished\nbob: thanks\n"), 0644)
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy failed").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--context", contextPath).Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		if !strings.Contains(string(out), ">\nalice: rollback finished\nbob: thanks\n</channel-state-") {
+			t.Errorf("expected channel state section, got %s", out)
+		}
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Retrying").Run()
+		out, _ = exec.Command(binaryPath, "--db", dbPath, "flush", "--context", contextPath, "--format", "json").Output()
+		var result map[string]interface{}
+		json.Unmarshal(out, &result)
+		if result["context"] != "alice: rollback finished\nbob: thanks" {
+			t.Errorf("expected recorded context, got %v", result["context"])
+		}
+	})
+
+	t.Run("context reads stdin", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy failed").Run()
+
+		cmd := exec.Command(binaryPath, "--db", dbPath, "flush", "--context", "-")
+		cmd.Stdin = strings.NewReader("carol: all clear")
+		out, err := cmd.Output()
+		if err != nil || !strings.Contains(string(out), "carol: all clear") {
+			t.Errorf("expected stdin context, got %s (%v)", out, err)
+		}
+	})
+
+	t.Run("missing context file fails", func(t *testing.T) {
+		if _, _, err := runCLI(t, "flush", "--context", "/nonexistent/channel.txt"); err == nil {
+			t.Error("expected error for missing context file")
+		}
+	})
+
+	t.Run("groups by channel and target", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--channel", "discord", "--target", "#ops", "Ops one").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--channel", "discord", "--target", "#general", "General").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--channel", "discord", "--target", "#ops", "Ops two").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var docs []map[string]interface{}
+		if err := json.Unmarshal(out, &docs); err != nil {
+			t.Fatalf("expected one JSON array: %v\n%s", err, out)
+		}
+		seen := map[string]float64{}
+		var events []float64
+		for _, doc := range docs {
+			prompt := doc["prompt"].(string)
+			target := doc["target"].(string)
+			seen[target] = float64(len(doc["thoughts"].([]interface{})))
+			events = append(events, doc["event_id"].(float64))
+			if target == "#ops" && strings.Contains(prompt, "General") {
+				t.Error("destinations should not share a prompt")
+			}
+		}
+		if seen["#ops"] != 2 || seen["#general"] != 1 {
+			t.Errorf("unexpected groups: %v", seen)
+		}
+		if len(events) != 2 || events[0] == events[1] {
+			t.Errorf("expected one event per destination, got %v", events)
+		}
+	})
+
+	t.Run("coalesces near-duplicates", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "CI is red on main").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "ci is RED on main").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "CI is red on main!").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(out, &doc); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		if !strings.Contains(doc["prompt"].(string), "(×3 similar)") {
+			t.Errorf("expected similarity note:\n%s", doc["prompt"])
+		}
+		thoughts := doc["thoughts"].([]interface{})
+		if len(thoughts) != 2 {
+			t.Fatalf("expected 2 thoughts after coalescing, got %d", len(thoughts))
+		}
+		for _, th := range thoughts {
+			m := th.(map[string]interface{})
+			if strings.HasPrefix(m["content"].(string), "CI is red") && m["effective_priority"] != "P0" {
+				t.Errorf("expected kept thought promoted to P0, got %v", m)
+			}
+		}
+
+		status, _ := exec.Command(binaryPath, "--db", dbPath, "--json", "status").Output()
+		var st map[string]interface{}
+		json.Unmarshal(status, &st)
+		if st["pending"] != float64(0) {
+			t.Errorf("expected nothing left pending, got %v", st["pending"])
+		}
+	})
+
+	t.Run("supersedes and retracts", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy window is 5pm").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Lunch order placed").Run()
+		out, err := exec.Command(binaryPath, "--db", dbPath, "--json", "buffer", "--supersedes", "1", "Deploy window moved to 6pm").Output()
+		if err != nil {
+			t.Fatalf("buffer failed: %v", err)
+		}
+		var buffered map[string]interface{}
+		json.Unmarshal(out, &buffered)
+		if buffered["superseded"] != true {
+			t.Errorf("expected supersession, got %s", out)
+		}
+
+		out, err = exec.Command(binaryPath, "--db", dbPath, "--json", "retract", "2").Output()
+		if err != nil {
+			t.Fatalf("retract failed: %v", err)
+		}
+		if !strings.Contains(string(out), `"retracted":true`) {
+			t.Errorf("expected retraction, got %s", out)
+		}
+
+		out, err = exec.Command(binaryPath, "--db", dbPath, "flush", "--obsolete", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(out, &doc); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		if n := len(doc["thoughts"].([]interface{})); n != 1 {
+			t.Errorf("expected only the replacement to be flushed, got %d", n)
+		}
+		prompt := doc["prompt"].(string)
+		i := strings.Index(prompt, "ALREADY OBSOLETE")
+		if i < 0 || !strings.Contains(prompt[i:], "5pm") || strings.Contains(prompt, "Lunch") {
+			t.Errorf("unexpected prompt:\n%s", prompt)
+		}
+	})
+
+	t.Run("cross-agent flush for a shared channel", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--agent", "main", "--channel", "discord", "Deploy finished").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--agent", "architect", "--channel", "discord", "Schema migration merged").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--agent", "main", "--channel", "slack", "Standup moved").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--channel", "discord", "--cross-agent", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(out, &doc); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		if n := len(doc["thoughts"].([]interface{})); n != 2 {
+			t.Errorf("expected both agents' discord thoughts, got %d", n)
+		}
+		if agents := fmt.Sprint(doc["agents"]); agents != "[architect main]" {
+			t.Errorf("expected contributing agents, got %s", agents)
+		}
+		prompt := doc["prompt"].(string)
+		if !strings.Contains(prompt, "from architect") || strings.Contains(prompt, "Standup") {
+			t.Errorf("unexpected prompt:\n%s", prompt)
+		}
+
+		// The slack thought is still main's to flush
+		out, _ = exec.Command(binaryPath, "--db", dbPath, "--json", "status").Output()
+		var st map[string]interface{}
+		json.Unmarshal(out, &st)
+		if st["pending"] != float64(1) {
+			t.Errorf("expected one thought left pending, got %v", st["pending"])
+		}
+	})
+
+	t.Run("cross-agent requires a channel", func(t *testing.T) {
+		if _, _, err := runCLI(t, "flush", "--cross-agent"); err == nil {
+			t.Error("expected error for --cross-agent without --channel")
+		}
+		if _, _, err := runCLI(t, "flush", "--cross-agent", "--channel", "discord", "--all"); err == nil {
+			t.Error("expected error for --cross-agent with --all")
+		}
+	})
+
+	t.Run("dry run changes nothing", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "CI is red on main").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "ci is RED on main").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--dry-run", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("dry run failed: %v", err)
+		}
+		var preview map[string]interface{}
+		if err := json.Unmarshal(out, &preview); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		if preview["dry_run"] != true || preview["event_id"] != nil {
+			t.Errorf("expected a dry run without event, got %v", preview)
+		}
+		if consumed := preview["consumed"].([]interface{}); len(consumed) != 3 {
+			t.Errorf("expected all three IDs, duplicate included, got %v", consumed)
+		}
+
+		list, _ := exec.Command(binaryPath, "--db", dbPath, "--json", "list").Output()
+		if n := strings.Count(string(list), `"status": "pending"`); n != 3 {
+			t.Errorf("expected all thoughts still pending, got %d:\n%s", n, list)
+		}
+
+		// The real flush prints the same prompt
+		out, _ = exec.Command(binaryPath, "--db", dbPath, "flush", "--format", "json").Output()
+		var doc map[string]interface{}
+		json.Unmarshal(out, &doc)
+		if doc["prompt"] != preview["prompt"] {
+			t.Errorf("expected the previewed prompt, got:\n%s", doc["prompt"])
+		}
+	})
+
+	t.Run("filters a partial flush", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "Production is down").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Lunch order placed").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--channel", "slack", "Standup moved").Run()
+
+		flushed := func(args ...string) []string {
+			t.Helper()
+			args = append([]string{"--db", dbPath, "flush", "--format", "json"}, args...)
+			out, err := exec.Command(binaryPath, args...).Output()
+			if err != nil {
+				t.Fatalf("flush %v failed: %v", args, err)
+			}
+			var doc map[string]interface{}
+			json.Unmarshal(out, &doc)
+			var contents []string
+			for _, th := range doc["thoughts"].([]interface{}) {
+				contents = append(contents, th.(map[string]interface{})["content"].(string))
+			}
+			return contents
+		}
+		if got := flushed("--priority", "P0"); len(got) != 1 || got[0] != "Production is down" {
+			t.Errorf("unexpected --priority flush: %v", got)
+		}
+		if got := flushed("--channel", "slack", "--force"); len(got) != 1 || got[0] != "Standup moved" {
+			t.Errorf("unexpected --channel flush: %v", got)
+		}
+		if got := flushed("--ids", "1,2", "--since", "1h", "--force"); len(got) != 1 || got[0] != "Lunch order placed" {
+			t.Errorf("unexpected --ids flush: %v", got)
+		}
+	})
+
+	t.Run("rejects invalid filters", func(t *testing.T) {
+		if _, _, err := runCLI(t, "flush", "--priority", "P9"); err == nil {
+			t.Error("expected error for unknown priority")
+		}
+		if _, _, err := runCLI(t, "flush", "--dry-run", "--map-reduce"); err == nil {
+			t.Error("expected error for --dry-run with --map-reduce")
+		}
+	})
+
+	t.Run("retract rejects unknown thought", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "retract", "99").CombinedOutput()
+		if !strings.Contains(string(out), "not pending") {
+			t.Errorf("expected not-pending notice, got %s", out)
+		}
+	})
+
+	t.Run("json thoughts show their recorded state", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--json").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var doc map[string]interface{}
+		json.Unmarshal(out, &doc)
+		thought := doc["thoughts"].([]interface{})[0].(map[string]interface{})
+		if thought["status"] != "synthesized" || thought["event_id"] != doc["event_id"] {
+			t.Errorf("expected the thought claimed by event %v, got %v", doc["event_id"], thought)
+		}
+	})
+
+	t.Run("messages for several destinations name each one", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--channel", "discord", "--target", "#ops", "Ops one").Run()
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--channel", "slack", "General").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--format", "messages", "--shape", "anthropic").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var docs []map[string]interface{}
+		if err := json.Unmarshal(out, &docs); err != nil || len(docs) != 2 {
+			t.Fatalf("expected a JSON array of two destinations: %v\n%s", err, out)
+		}
+		for _, doc := range docs {
+			if doc["channel"] == nil || doc["system"] == nil || doc["messages"] == nil {
+				t.Errorf("expected channel, system and messages, got %v", doc)
+			}
+		}
+	})
+
+	t.Run("buffer defaults to cli channel", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "--json", "buffer", "Hello").Output()
+		var result map[string]interface{}
+		json.Unmarshal(out, &result)
+		if result["channel"] != "cli" || result["target"] != "" {
+			t.Errorf("unexpected destination: %v", result)
+		}
+	})
+
+	t.Run("format messages requires single agent", func(t *testing.T) {
+		if _, _, err := runCLI(t, "flush", "--all", "--format", "messages"); err == nil {
+			t.Error("expected error for --all with messages")
+		}
+	})
+
+	t.Run("rejects invalid template at load", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(filepath.Join(tmpDir, "bad.tmpl"), []byte(`{{.Nope}}`), 0644)
+		os.WriteFile(configPath, []byte(`{"prompt_template": "bad.tmpl"}`), 0644)
+
+		cmd := exec.Command(binaryPath, "--db", filepath.Join(tmpDir, "test.db"), "--config", configPath, "status")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err == nil || !strings.Contains(stderr.String(), "prompt_template") {
+			t.Errorf("expected template error, got %v: %s", err, stderr.String())
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// GATE COMMAND TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestGateCommand(t *testing.T) {
+	gate := func(t *testing.T, dbPath string, args ...string) map[string]interface{} {
+		t.Helper()
+		allArgs := append([]string{"--db", dbPath, "gate", "--json"}, args...)
+		cmd := exec.Command(binaryPath, allArgs...)
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("gate failed: %v", err)
+		}
+		var result map[string]interface{}
+		if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		return result
+	}
+
+	t.Run("passes when healthy", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+
+		result := gate(t, dbPath, "Hello")
+		if result["action"] != "pass" {
+			t.Errorf("expected pass, got %v", result["action"])
+		}
+	})
+
+	t.Run("buffers P1 when congested", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "simulate", "20000").Run()
+
+		result := gate(t, dbPath, "Normal message")
+		if result["action"] != "buffer" {
+			t.Errorf("expected buffer, got %v", result["action"])
+		}
+		if _, ok := result["id"]; !ok {
+			t.Error("expected buffered thought id")
+		}
+	})
+
+	t.Run("express lane passes P0 until rate limit", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"express": {"limit": 1, "window": "1h"}}`), 0644)
+		exec.Command(binaryPath, "--db", dbPath, "force").Run()
+
+		first := gate(t, dbPath, "--config", configPath, "--priority", "P0", "Prod down")
+		if first["action"] != "pass" || first["express"] != true {
+			t.Errorf("expected express pass, got %v", first)
+		}
+
+		second := gate(t, dbPath, "--config", configPath, "--priority", "P0", "Still down")
+		if second["action"] != "buffer" {
+			t.Errorf("expected buffer after limit, got %v", second["action"])
+		}
+
+		cmd := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "status", "--json")
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Run()
+
+		var status map[string]interface{}
+		json.Unmarshal(stdout.Bytes(), &status)
+		if status["express_sent"] != float64(1) {
+			t.Errorf("expected 1 express send this episode, got %v", status["express_sent"])
+		}
+	})
+
+	t.Run("halt blocks express lane", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "halt").Run()
+
+		result := gate(t, dbPath, "--priority", "P0", "Prod down")
+		if result["action"] != "buffer" {
+			t.Errorf("expected buffer while halted, got %v", result["action"])
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// DEBOUNCE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestDebounce(t *testing.T) {
+	setup := func(t *testing.T) (string, string) {
+		t.Helper()
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"debounce": "1s"}`), 0644)
+		return filepath.Join(tmpDir, "test.db"), configPath
+	}
+	run := func(t *testing.T, dbPath, configPath string, args ...string) []byte {
+		t.Helper()
+		allArgs := append([]string{"--db", dbPath, "--config", configPath, "--json"}, args...)
+		cmd := exec.Command(binaryPath, allArgs...)
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+		return stdout.Bytes()
+	}
+
+	t.Run("gate buffers burst while healthy", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		var result map[string]interface{}
+		json.Unmarshal(run(t, dbPath, configPath, "gate", "First"), &result)
+		if result["action"] != "buffer" {
+			t.Errorf("expected buffer, got %v", result["action"])
+		}
+		if _, ok := result["window_closes_at"]; !ok {
+			t.Error("expected window close time")
+		}
+	})
+
+	t.Run("next gate call fires flush after window closes", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		run(t, dbPath, configPath, "gate", "First")
+		run(t, dbPath, configPath, "gate", "Second")
+		time.Sleep(2100 * time.Millisecond)
+
+		var result map[string]interface{}
+		json.Unmarshal(run(t, dbPath, configPath, "gate", "Third"), &result)
+		flushed, ok := result["flushed"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected flushed burst, got %v", result)
+		}
+		if flushed["thoughts"] != float64(2) {
+			t.Errorf("expected 2 flushed thoughts, got %v", flushed["thoughts"])
+		}
+		if !strings.Contains(flushed["prompt"].(string), "Second") {
+			t.Error("expected burst content in prompt")
+		}
+		if strings.Contains(flushed["prompt"].(string), "NETWORK RECOVERED") {
+			t.Error("a burst on a healthy network is not a recovery")
+		}
+	})
+
+	t.Run("burst is prompted with aged priorities", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+		os.WriteFile(configPath, []byte(`{"debounce": "1s", "aging": {"interval": "1s", "ceiling": "P0"}}`), 0644)
+
+		run(t, dbPath, configPath, "gate", "--priority", "P2", "First")
+		time.Sleep(2100 * time.Millisecond)
+
+		var result map[string]interface{}
+		json.Unmarshal(run(t, dbPath, configPath, "gate", "Second"), &result)
+		flushed, ok := result["flushed"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected flushed burst, got %v", result)
+		}
+		if !strings.Contains(flushed["prompt"].(string), "[CRITICAL]") {
+			t.Errorf("expected the waiting thought aged to P0:\n%s", flushed["prompt"])
+		}
+	})
+
+	t.Run("burst across destinations lists them all", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		run(t, dbPath, configPath, "gate", "--channel", "discord", "First")
+		run(t, dbPath, configPath, "gate", "--channel", "slack", "Second")
+		time.Sleep(2100 * time.Millisecond)
+
+		var result map[string]interface{}
+		json.Unmarshal(run(t, dbPath, configPath, "gate", "Third"), &result)
+		if _, ok := result["flushed"].(map[string]interface{}); !ok {
+			t.Fatalf("expected flushed to stay an object, got %v", result["flushed"])
+		}
+		if all, ok := result["flushed_all"].([]interface{}); !ok || len(all) != 2 {
+			t.Errorf("expected both destinations in flushed_all, got %v", result["flushed_all"])
+		}
+	})
+
+	t.Run("daemon fires due windows", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		run(t, dbPath, configPath, "gate", "--agent", "architect", "Burst")
+
+		early := run(t, dbPath, configPath, "daemon", "--once")
+		if len(bytes.TrimSpace(early)) != 0 {
+			t.Errorf("expected no flush before window closes, got %s", early)
+		}
+
+		time.Sleep(2100 * time.Millisecond)
+		var result map[string]interface{}
+		json.Unmarshal(run(t, dbPath, configPath, "daemon", "--once"), &result)
+		if result["agent"] != "architect" || result["trigger"] != "debounce" {
+			t.Errorf("unexpected daemon output: %v", result)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// COOLDOWN TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestCooldown(t *testing.T) {
+	setup := func(t *testing.T, config string) (string, string) {
+		t.Helper()
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(config), 0644)
+		return filepath.Join(tmpDir, "test.db"), configPath
+	}
+
+	t.Run("gate buffers after flush and status shows remaining", func(t *testing.T) {
+		dbPath, configPath := setup(t, `{"cooldown": "1h"}`)
+
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "buffer", "First").Run()
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "flush").Run()
+
+		cmd := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "gate", "--json", "Right after")
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Run()
+
+		var result map[string]interface{}
+		json.Unmarshal(stdout.Bytes(), &result)
+		if result["action"] != "buffer" || !strings.Contains(result["reason"].(string), "cooldown") {
+			t.Errorf("expected cooldown buffering, got %v", result)
+		}
+
+		cmd = exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "status", "--json")
+		stdout.Reset()
+		cmd.Stdout = &stdout
+		cmd.Run()
+
+		var status map[string]interface{}
+		json.Unmarshal(stdout.Bytes(), &status)
+		cooldowns := status["cooldowns"].(map[string]interface{})
+		if remaining, ok := cooldowns["main"].(float64); !ok || remaining <= 0 {
+			t.Errorf("expected remaining cooldown for main, got %v", cooldowns)
+		}
+	})
+
+	t.Run("min interval blocks second flush unless forced", func(t *testing.T) {
+		dbPath, configPath := setup(t, `{"min_interval": "1h"}`)
+		run := func(args ...string) error {
+			return exec.Command(binaryPath, append([]string{"--db", dbPath, "--config", configPath}, args...)...).Run()
+		}
+
+		run("buffer", "First")
+		if err := run("flush"); err != nil {
+			t.Fatalf("first flush failed: %v", err)
+		}
+		run("buffer", "Second")
+		if err := run("flush"); err == nil {
+			t.Error("expected second flush to be refused")
+		}
+		if err := run("flush", "--force"); err != nil {
+			t.Errorf("forced flush failed: %v", err)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// STAGGERED RECOVERY TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestStaggeredRecovery(t *testing.T) {
+	setup := func(t *testing.T) (func(args ...string) []byte, string) {
+		t.Helper()
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"recovery": {"max_concurrent": 1, "spacing": "100ms", "jitter": "50ms"}}`), 0644)
+		run := func(args ...string) []byte {
+			cmd := exec.Command(binaryPath, append([]string{"--db", dbPath, "--config", configPath}, args...)...)
+			var stdout bytes.Buffer
+			cmd.Stdout = &stdout
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("%v failed: %v", args, err)
+			}
+			return stdout.Bytes()
+		}
+		run("buffer", "--agent", "low", "--priority", "P2", "Chatter")
+		run("buffer", "--agent", "urgent", "--priority", "P0", "Down")
+		run("buffer", "--agent", "normal", "Update")
+		return run, dbPath
+	}
+	agentsIn := func(out []byte) []string {
+		var agents []string
+		for _, line := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+			var event map[string]interface{}
+			if json.Unmarshal(line, &event) == nil {
+				agents = append(agents, event["agent"].(string))
+			}
+		}
+		return agents
+	}
+
+	t.Run("flush all staggered admits agents by urgency", func(t *testing.T) {
+		run, _ := setup(t)
+
+		agents := agentsIn(run("--json", "flush", "--all", "--staggered"))
+		want := []string{"urgent", "normal", "low"}
+		if strings.Join(agents, ",") != strings.Join(want, ",") {
+			t.Errorf("expected %v, got %v", want, agents)
+		}
+	})
+
+	t.Run("staggered needs all", func(t *testing.T) {
+		if _, stderr, err := runCLI(t, "flush", "--staggered"); err == nil || !strings.Contains(stderr, "--staggered needs --all") {
+			t.Errorf("expected --staggered error, got %v: %s", err, stderr)
+		}
+	})
+
+	t.Run("daemon admits one wave per pass", func(t *testing.T) {
+		run, _ := setup(t)
+
+		agents := agentsIn(run("--json", "daemon", "--once"))
+		if len(agents) != 1 || agents[0] != "urgent" {
+			t.Errorf("expected only urgent in first wave, got %v", agents)
+		}
+	})
+
+	t.Run("daemon ages priorities before ordering waves", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"aging": {"interval": "1s", "ceiling": "P0"}, "recovery": {"max_concurrent": 1}}`), 0644)
+		run := func(args ...string) []byte {
+			out, err := exec.Command(binaryPath, append([]string{"--db", dbPath, "--config", configPath}, args...)...).Output()
+			if err != nil {
+				t.Fatalf("%v failed: %v", args, err)
+			}
+			return out
+		}
+		run("buffer", "--agent", "waiting", "--priority", "P2", "Chatter")
+		time.Sleep(2100 * time.Millisecond)
+		run("buffer", "--agent", "fresh", "Update")
+
+		out := run("--json", "daemon", "--once")
+		var event map[string]interface{}
+		json.Unmarshal(bytes.TrimSpace(out), &event)
+		if event["agent"] != "waiting" || !strings.Contains(fmt.Sprint(event["prompt"]), "[CRITICAL]") {
+			t.Errorf("expected the aged thought first and prompted as critical, got %s", out)
+		}
+	})
+
+	t.Run("daemon holds flushes while congested", func(t *testing.T) {
+		run, _ := setup(t)
+		run("simulate", "20000")
+
+		if agents := agentsIn(run("--json", "daemon", "--once")); len(agents) != 0 {
+			t.Errorf("expected no flush while congested, got %v", agents)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// LIST COMMAND TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestLeaseCommands(t *testing.T) {
+	setup := func(t *testing.T) (string, string) {
+		t.Helper()
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"bulkhead": {"resources": {"llm": {"per_agent": 1, "global": 2}}}}`), 0644)
+		return filepath.Join(tmpDir, "test.db"), configPath
+	}
+
+	t.Run("acquire prints a lease and busy exits 75", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire", "--resource", "llm").Output()
+		if err != nil {
+			t.Fatalf("acquire failed: %v", err)
+		}
+		if strings.TrimSpace(string(out)) == "" {
+			t.Fatal("expected a lease ID")
+		}
+
+		err = exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire", "--resource", "llm").Run()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 75 {
+			t.Errorf("expected exit code 75, got %v", err)
+		}
+	})
+
+	t.Run("release frees the slot", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire").Output()
+		id := strings.TrimSpace(string(out))
+		if err := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "release", id).Run(); err != nil {
+			t.Fatalf("release failed: %v", err)
+		}
+		if err := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire").Run(); err != nil {
+			t.Errorf("expected acquire after release to succeed: %v", err)
+		}
+	})
+
+	t.Run("expired lease frees the slot", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire", "--ttl", "1s").Run()
+		time.Sleep(2100 * time.Millisecond)
+		if err := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire").Run(); err != nil {
+			t.Errorf("expected expired lease to be reaped: %v", err)
+		}
+	})
+
+	t.Run("status shows lease counts", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire", "--agent", "main").Run()
+		exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire", "--agent", "architect").Run()
+
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "status", "--json").Output()
+		var status map[string]interface{}
+		json.Unmarshal(out, &status)
+		llm := status["leases"].(map[string]interface{})["llm"].(map[string]interface{})
+		if llm["in_use"] != float64(2) || llm["global_limit"] != float64(2) {
+			t.Errorf("unexpected lease usage: %v", llm)
+		}
+	})
+
+	t.Run("rejects unknown resource", func(t *testing.T) {
+		dbPath, configPath := setup(t)
+
+		if err := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "acquire", "--resource", "gpu").Run(); err == nil {
+			t.Error("expected error for unknown resource")
+		}
+	})
+}
+
+func TestMapReduceFlush(t *testing.T) {
+	setup := func(t *testing.T, n int) string {
+		t.Helper()
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		for i := 1; i <= n; i++ {
+			exec.Command(binaryPath, "--db", dbPath, "buffer", fmt.Sprintf("Thought %d", i)).Run()
+		}
+		return dbPath
+	}
+	step := func(t *testing.T, args ...string) map[string]interface{} {
+		t.Helper()
+		out, err := exec.Command(binaryPath, args...).Output()
+		if err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+		var result map[string]interface{}
+		if err := json.Unmarshal(out, &result); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		return result
+	}
+
+	t.Run("runs map rounds then reduce", func(t *testing.T) {
+		dbPath := setup(t, 5)
+
+		first := step(t, "--db", dbPath, "flush", "--map-reduce", "--chunk-size", "2", "--format", "json")
+		if first["kind"] != "map" || first["round"] != float64(1) || first["rounds"] != float64(4) {
+			t.Fatalf("unexpected first step: %v", first)
+		}
+		if !strings.Contains(first["prompt"].(string), "PART 1 OF 3") {
+			t.Error("expected map prompt")
+		}
+
+		next := first
+		for i := 1; i <= 3; i++ {
+			id := fmt.Sprint(next["event_id"])
+			next = step(t, "--db", dbPath, "flush", "--submit", id, "--output", fmt.Sprintf("partial %d", i), "--format", "json")
+		}
+		if next["kind"] != "reduce" || !strings.Contains(next["prompt"].(string), "partial 3") {
+			t.Fatalf("expected reduce step with partials, got %v", next)
+		}
+
+		done := step(t, "--db", dbPath, "flush", "--submit", fmt.Sprint(next["event_id"]), "--output", "final", "--format", "json")
+		if done["done"] != true || done["output"] != "final" {
+			t.Errorf("expected finished plan, got %v", done)
+		}
+
+		status := step(t, "--db", dbPath, "status", "--json")
+		if status["pending"] != float64(0) {
+			t.Errorf("expected all thoughts claimed, got %v", status["pending"])
+		}
+	})
+
+	t.Run("resumes the open plan", func(t *testing.T) {
+		dbPath := setup(t, 3)
+
+		first := step(t, "--db", dbPath, "flush", "--map-reduce", "--chunk-size", "2", "--format", "json")
+		again := step(t, "--db", dbPath, "flush", "--map-reduce", "--format", "json")
+		if again["event_id"] != first["event_id"] {
+			t.Errorf("expected the same pending round, got %v then %v", first["event_id"], again["event_id"])
+		}
+	})
+
+	t.Run("small backlog flushes in one round", func(t *testing.T) {
+		dbPath := setup(t, 2)
+
+		out, _ := exec.Command(binaryPath, "--db", dbPath, "flush", "--map-reduce", "--chunk-size", "5").Output()
+		if !strings.Contains(string(out), "Synthesized 2 thoughts") {
+			t.Errorf("expected a normal flush, got %s", out)
+		}
+	})
+
+	t.Run("submit reads stdin", func(t *testing.T) {
+		dbPath := setup(t, 3)
+
+		first := step(t, "--db", dbPath, "flush", "--map-reduce", "--chunk-size", "2", "--format", "json")
+		cmd := exec.Command(binaryPath, "--db", dbPath, "flush", "--submit", fmt.Sprint(first["event_id"]), "--output", "-", "--format", "json")
+		cmd.Stdin = strings.NewReader("from stdin\n")
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("submit failed: %v", err)
+		}
+		out := step(t, "--db", dbPath, "flush", "--map-reduce", "--format", "json")
+		if out["round"] != float64(2) {
+			t.Errorf("expected round 2 after submit, got %v", out["round"])
+		}
+	})
+
+	t.Run("rejects submit for a finished step", func(t *testing.T) {
+		dbPath := setup(t, 1)
+
+		exec.Command(binaryPath, "--db", dbPath, "flush").Run()
+		if err := exec.Command(binaryPath, "--db", dbPath, "flush", "--submit", "1", "--output", "x").Run(); err == nil {
+			t.Error("expected error for single flush event")
+		}
+	})
+
+	t.Run("submit requires output", func(t *testing.T) {
+		if _, _, err := runCLI(t, "flush", "--submit", "1"); err == nil {
+			t.Error("expected error without --output")
+		}
+	})
+}
+
+func TestListCommand(t *testing.T) {
+	t.Run("lists pending with both priorities", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P2", "Low thought").Run()
+
+		cmd := exec.Command(binaryPath, "--db", dbPath, "list", "--json")
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("command failed: %v", err)
+		}
+
+		var thoughts []map[string]interface{}
+		if err := json.Unmarshal(stdout.Bytes(), &thoughts); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(thoughts) != 1 {
+			t.Fatalf("expected 1 thought, got %d", len(thoughts))
+		}
+		if thoughts[0]["priority"] != "P2" || thoughts[0]["effective_priority"] != "P2" {
+			t.Errorf("unexpected priorities: %v", thoughts[0])
+		}
+	})
+
+	t.Run("aligns columns for long class names", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{
+			"aging": {"ceiling": "user-facing"},
+			"priorities": {"default": "user-facing", "classes": [
+				{"name": "security", "rank": 0},
+				{"name": "user-facing", "rank": 1}
+			]}
+		}`), 0644)
+		run := func(args ...string) string {
+			out, _ := exec.Command(binaryPath, append([]string{"--db", dbPath, "--config", configPath, "--no-color"}, args...)...).Output()
+			return string(out)
+		}
+		run("buffer", "--priority", "security", "Key leaked")
+		run("buffer", "Page updated")
+
+		var columns []int
+		for _, line := range strings.Split(strings.TrimRight(run("list"), "\n"), "\n") {
+			columns = append(columns, len([]rune(line[:strings.Index(line, " main ")])))
+		}
+		if len(columns) != 2 || columns[0] != columns[1] {
+			t.Errorf("expected the agent column aligned, got offsets %v", columns)
+		}
+	})
+
+	t.Run("empty list", func(t *testing.T) {
+		stdout, _, err := runCLI(t, "list")
+		if err != nil {
+			t.Fatalf("command failed: %v", err)
+		}
+		if !strings.Contains(stdout, "No pending") {
+			t.Error("expected 'No pending' message")
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// COMPLETE COMMAND TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestCompleteCommand(t *testing.T) {
+	t.Run("records the sent message next to the prompt", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+		out, err := exec.Command(binaryPath, "--db", dbPath, "flush", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var flushed map[string]interface{}
+		json.Unmarshal(out, &flushed)
+		eventID := fmt.Sprint(flushed["event_id"])
+
+		cmd := exec.Command(binaryPath, "--db", dbPath, "--json", "complete", eventID,
+			"--output", "-", "--model", "model-a", "--prompt-tokens", "150", "--output-tokens", "9", "--delivery", "sent")
+		cmd.Stdin = strings.NewReader("Deploy is done.\n")
+		out, err = cmd.Output()
+		if err != nil {
+			t.Fatalf("complete failed: %v", err)
+		}
+		var doc struct {
+			Event map[string]interface{} `json:"event"`
+		}
+		if err := json.Unmarshal(out, &doc); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		e := doc.Event
+		if e["output"] != "Deploy is done." || e["model"] != "model-a" || e["delivery"] != "sent" ||
+			e["prompt_tokens"] != float64(150) || e["output_tokens"] != float64(9) {
+			t.Errorf("unexpected event: %v", e)
+		}
+		if !strings.Contains(e["prompt"].(string), "Deploy finished") {
+			t.Errorf("expected the prompt to be kept, got %v", e["prompt"])
+		}
+	})
+
+	t.Run("non-LLM strategies record their output directly", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+		exec.Command(binaryPath, "--db", dbPath, "flush", "--strategy", "latest-only").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "--json", "complete", "1", "--delivery", "sent").Output()
+		if err != nil {
+			t.Fatalf("complete failed: %v", err)
+		}
+		if !strings.Contains(string(out), `"output": "Deploy finished"`) || !strings.Contains(string(out), `"prompt": ""`) {
+			t.Errorf("expected output without a prompt:\n%s", out)
+		}
+	})
+
+	t.Run("strict rejects output that drops critical content", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "--priority", "P0", "Payments API down since 14:05").Run()
+		exec.Command(binaryPath, "--db", dbPath, "flush").Run()
+
+		cmd := exec.Command(binaryPath, "--db", dbPath, "--json", "complete", "1", "--output", "-", "--strict")
+		cmd.Stdin = strings.NewReader("All systems normal.")
+		out, err := cmd.Output()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 65 {
+			t.Fatalf("expected exit code 65, got %v", err)
+		}
+		if !strings.Contains(string(out), `"rejected": true`) || !strings.Contains(string(out), "14:05") {
+			t.Errorf("expected rejection with missing items:\n%s", out)
+		}
+
+		cmd = exec.Command(binaryPath, "--db", dbPath, "--json", "complete", "1", "--output", "-", "--strict")
+		cmd.Stdin = strings.NewReader("The payments API has been down since 14:05.")
+		out, err = cmd.Output()
+		if err != nil {
+			t.Fatalf("expected acceptance, got %v:\n%s", err, out)
+		}
+		if !strings.Contains(string(out), `"coverage": 1`) {
+			t.Errorf("expected full coverage recorded:\n%s", out)
+		}
+	})
+
+	t.Run("rejects missing output", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+		exec.Command(binaryPath, "--db", dbPath, "flush").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "complete", "1", "--model", "model-a").CombinedOutput()
+		if err == nil || !strings.Contains(string(out), "no output") {
+			t.Errorf("expected missing output error, got %v: %s", err, out)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// REQUEUE COMMAND TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestRequeueCommand(t *testing.T) {
+	t.Run("returns a failed flush to pending", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"min_interval": "1h"}`), 0644)
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+		out, err := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "flush", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		var flushed map[string]interface{}
+		json.Unmarshal(out, &flushed)
+		eventID := fmt.Sprint(flushed["event_id"])
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Arrived later").Run()
+
+		out, err = exec.Command(binaryPath, "--db", dbPath, "--json", "requeue", eventID).Output()
+		if err != nil {
+			t.Fatalf("requeue failed: %v", err)
+		}
+		var doc map[string]interface{}
+		json.Unmarshal(out, &doc)
+		if doc["requeued"] != true || doc["thoughts"] != float64(1) {
+			t.Errorf("unexpected requeue result: %s", out)
+		}
+
+		// The requeued thought is flushed again, without waiting out the interval
+		out, err = exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "flush", "--format", "json").Output()
+		if err != nil {
+			t.Fatalf("second flush failed: %v", err)
+		}
+		json.Unmarshal(out, &flushed)
+		if n := len(flushed["thoughts"].([]interface{})); n != 2 {
+			t.Errorf("expected the requeued and the later thought, got %d", n)
+		}
+
+		out, _ = exec.Command(binaryPath, "--db", dbPath, "requeue", eventID).CombinedOutput()
+		if !strings.Contains(string(out), "already rolled back") {
+			t.Errorf("expected already-rolled-back notice, got %s", out)
+		}
+	})
+
+	t.Run("refuses a delivered event without force", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+		exec.Command(binaryPath, "--db", dbPath, "flush").Run()
+		exec.Command(binaryPath, "--db", dbPath, "delivered", "1", "--status", "sent").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "requeue", "1").CombinedOutput()
+		if err == nil || !strings.Contains(string(out), "already delivered") {
+			t.Errorf("expected delivered event refused, got %s (%v)", out, err)
+		}
+		out, err = exec.Command(binaryPath, "--db", dbPath, "--json", "requeue", "1", "--force").Output()
+		var doc map[string]interface{}
+		json.Unmarshal(out, &doc)
+		if err != nil || doc["requeued"] != true {
+			t.Errorf("expected forced requeue, got %s (%v)", out, err)
+		}
+	})
+
+	t.Run("rejects invalid event ID", func(t *testing.T) {
+		if _, _, err := runCLI(t, "requeue", "abc"); err == nil {
+			t.Error("expected error for invalid event ID")
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// DEAD-LETTER QUEUE TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestDLQCommand(t *testing.T) {
+	t.Run("dead-letters a thought that keeps failing", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"dlq": {"max_attempts": 2}}`), 0644)
+		run := func(args ...string) []byte {
+			t.Helper()
+			args = append([]string{"--db", dbPath, "--config", configPath}, args...)
+			out, err := exec.Command(binaryPath, args...).Output()
+			if err != nil {
+				t.Fatalf("%v failed: %v", args, err)
+			}
+			return out
+		}
+
+		run("buffer", "Poison pill")
+		for i := 1; i <= 2; i++ {
+			run("flush")
+			run("requeue", fmt.Sprint(i))
+		}
+
+		var status map[string]interface{}
+		json.Unmarshal(run("--json", "status"), &status)
+		if status["dead"] != float64(1) || status["pending"] != float64(0) {
+			t.Errorf("expected one dead thought and none pending, got %v", status)
+		}
+		var dead []map[string]interface{}
+		json.Unmarshal(run("--json", "dlq", "list"), &dead)
+		if len(dead) != 1 || dead[0]["attempts"] != float64(2) {
+			t.Fatalf("unexpected dead-letter queue: %v", dead)
+		}
+
+		out := run("--json", "dlq", "retry", fmt.Sprint(dead[0]["id"]))
+		if !strings.Contains(string(out), `"retried": 1`) {
+			t.Errorf("expected one retried, got %s", out)
+		}
+		json.Unmarshal(run("--json", "status"), &status)
+		if status["dead"] != float64(0) || status["pending"] != float64(1) {
+			t.Errorf("expected the thought pending again, got %v", status)
+		}
+	})
+
+	t.Run("purge needs IDs or --all", func(t *testing.T) {
+		if _, _, err := runCLI(t, "dlq", "purge"); err == nil {
+			t.Error("expected error without IDs or --all")
+		}
+		if stdout, _, err := runCLI(t, "--json", "dlq", "purge", "--all"); err != nil || !strings.Contains(stdout, `"purged": 0`) {
+			t.Errorf("expected empty purge, got %q (%v)", stdout, err)
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// DELIVERY TRACKING TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestDeliveryTracking(t *testing.T) {
+	t.Run("reports undelivered messages until delivered", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"delivery": {"overdue": "1ns"}}`), 0644)
+		run := func(args ...string) []byte {
+			t.Helper()
+			args = append([]string{"--db", dbPath, "--config", configPath}, args...)
+			out, err := exec.Command(binaryPath, args...).Output()
+			if err != nil {
+				t.Fatalf("%v failed: %v", args, err)
+			}
+			return out
+		}
+
+		run("buffer", "Deploy finished")
+		run("flush")
+
+		var status map[string]interface{}
+		json.Unmarshal(run("--json", "status"), &status)
+		if status["undelivered"] != float64(1) {
+			t.Errorf("expected one undelivered message, got %v", status["undelivered"])
+		}
+		if out := run("status"); !strings.Contains(string(out), "undelivered") {
+			t.Errorf("expected undelivered warning:\n%s", out)
+		}
+		var events []map[string]interface{}
+		json.Unmarshal(run("--json", "undelivered"), &events)
+		if len(events) != 1 {
+			t.Fatalf("expected one undelivered event, got %v", events)
+		}
+
+		out := run("--json", "delivered", "1", "--status", "acked")
+		if !strings.Contains(string(out), `"delivery": "acked"`) {
+			t.Errorf("expected acked delivery, got %s", out)
+		}
+		json.Unmarshal(run("--json", "undelivered"), &events)
+		if len(events) != 0 {
+			t.Errorf("expected nothing undelivered, got %v", events)
+		}
+		json.Unmarshal(run("--json", "status"), &status)
+		if status["undelivered"] != float64(0) {
+			t.Errorf("expected the warning cleared, got %v", status["undelivered"])
+		}
+	})
+
+	t.Run("status leaves undelivered null when the check is off", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Deploy finished").Run()
+		exec.Command(binaryPath, "--db", dbPath, "flush").Run()
+
+		status := func() map[string]interface{} {
+			t.Helper()
+			out, _ := exec.Command(binaryPath, "--db", dbPath, "--config", configPath, "--json", "status").Output()
+			var result map[string]interface{}
+			json.Unmarshal(out, &result)
+			return result
+		}
+		// Default threshold: a fresh flush is not overdue yet
+		if got := status()["undelivered"]; got != float64(0) {
+			t.Errorf("expected nothing overdue yet, got %v", got)
+		}
+
+		os.WriteFile(configPath, []byte(`{"delivery": {"overdue": 0}}`), 0644)
+		result := status()
+		if got, ok := result["undelivered"]; !ok || got != nil {
+			t.Errorf("expected null undelivered with the check off, got %v", got)
+		}
+	})
+
+	t.Run("rejects unknown delivery status", func(t *testing.T) {
+		if _, _, err := runCLI(t, "delivered", "1", "--status", "lost"); err == nil {
+			t.Error("expected error for unknown status")
+		}
+	})
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// SPLIT COMMAND TESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func TestSplitCommand(t *testing.T) {
+	t.Run("splits output to the channel limit", func(t *testing.T) {
+		skipIfNoBinary(t)
+		tmpDir := t.TempDir()
+		dbPath := filepath.Join(tmpDir, "test.db")
+		configPath := filepath.Join(tmpDir, "config.json")
+		os.WriteFile(configPath, []byte(`{"channels": {"pager": {"max_length": 30, "markdown": false, "mentions": "none"}}}`), 0644)
+		outputPath := filepath.Join(tmpDir, "output.txt")
+		os.WriteFile(outputPath, []byte("Deploy finished at 14:02. All checks passed. Rollback is not needed."), 0644)
+		run := func(args ...string) []byte {
+			t.Helper()
+			args = append([]string{"--db", dbPath, "--config", configPath}, args...)
+			out, err := exec.Command(binaryPath, args...).Output()
+			if err != nil {
+				t.Fatalf("%v failed: %v", args, err)
+			}
+			return out
+		}
+
+		run("buffer", "--channel", "pager", "Deploy finished")
+		prompt := string(run("flush"))
+		for _, want := range []string{"under 30 characters", "plain text", "Do not @mention anyone"} {
+			if !strings.Contains(prompt, want) {
+				t.Errorf("expected %q in prompt:\n%s", want, prompt)
+			}
+		}
+
+		if out := run("complete", "1", "--output", outputPath); !strings.Contains(string(out), "antibeaver split 1") {
+			t.Errorf("expected split reminder:\n%s", out)
+		}
+
+		var result struct {
+			MaxLength int `json:"max_length"`
+			Messages  []struct {
+				Seq     int    `json:"seq"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.Unmarshal(run("--json", "split", "1"), &result); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		want := []string{"Deploy finished at 14:02.", "All checks passed.", "Rollback is not needed."}
+		if result.MaxLength != 30 || len(result.Messages) != len(want) {
+			t.Fatalf("unexpected split: %+v", result)
+		}
+		for i, m := range result.Messages {
+			if m.Seq != i+1 || m.Content != want[i] {
+				t.Errorf("message %d: expected %q, got %+v", i+1, want[i], m)
+			}
+		}
+
+		json.Unmarshal(run("--json", "split", "1", "--max-length", "0"), &result)
+		if len(result.Messages) != 1 {
+			t.Errorf("expected one message without a limit, got %+v", result.Messages)
+		}
+	})
+
+	t.Run("requires recorded output", func(t *testing.T) {
+		skipIfNoBinary(t)
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		exec.Command(binaryPath, "--db", dbPath, "buffer", "Thought").Run()
+		exec.Command(binaryPath, "--db", dbPath, "flush").Run()
+
+		out, err := exec.Command(binaryPath, "--db", dbPath, "split", "1").CombinedOutput()
+		if err == nil || !strings.Contains(string(out), "no output yet") {
+			t.Errorf("expected missing output error, got %v:\n%s", err, out)
+		}
+	})
 }
 
 // ═══════════════════════════════════════════════════════════════════════════